@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"todoissh/pkg/reminder"
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// runCommand runs an offline administration subcommand against the stores.
+// remindersPath is the reminder notifier's state file.
+func runCommand(args []string, userStore *user.Store, todoStore *todo.Store, remindersPath string, in io.Reader, out io.Writer) error {
+	switch {
+	case len(args) == 2 && args[0] == "user" && args[1] == "list":
+		for _, name := range userStore.Usernames() {
+			fmt.Fprintln(out, name)
+		}
+		return nil
+
+	case len(args) == 3 && args[0] == "user" && args[1] == "add":
+		username := args[2]
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		if err := userStore.Register(username, strings.TrimRight(line, "\r\n")); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Added user %s\n", username)
+		return nil
+
+	case len(args) == 3 && args[0] == "user" && args[1] == "invite":
+		username := args[2]
+		code, err := userStore.CreateOneTimeCode(username)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "One-time code for %s: %s\n", username, code)
+		return nil
+
+	case len(args) == 3 && args[0] == "user" && args[1] == "delete":
+		username := args[2]
+		if err := userStore.Delete(username); err != nil {
+			return err
+		}
+		if err := todoStore.DeleteUser(username); err != nil {
+			return fmt.Errorf("user deleted but their todos were not: %v", err)
+		}
+		fmt.Fprintf(out, "Deleted user %s\n", username)
+		return nil
+
+	case len(args) == 3 && args[0] == "todo" && args[1] == "list":
+		username := args[2]
+		if userStore.GetUser(username) == nil {
+			return fmt.Errorf("user %s not found", username)
+		}
+		text, err := todoStore.ExportPlain(username)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, text)
+		return err
+
+	case len(args) == 3 && args[0] == "todo" && args[1] == "export":
+		username := args[2]
+		if userStore.GetUser(username) == nil {
+			return fmt.Errorf("user %s not found", username)
+		}
+		data, err := todoStore.ExportJSON(username)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+
+	case len(args) == 3 && args[0] == "todo" && args[1] == "import":
+		username := args[2]
+		if userStore.GetUser(username) == nil {
+			return fmt.Errorf("user %s not found", username)
+		}
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return fmt.Errorf("failed to read checklist: %v", err)
+		}
+		if err := todoStore.ImportMarkdown(username, string(data), true); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Imported todos for %s\n", username)
+		return nil
+
+	case len(args) == 3 && args[0] == "todo" && args[1] == "compact":
+		username := args[2]
+		if userStore.GetUser(username) == nil {
+			return fmt.Errorf("user %s not found", username)
+		}
+		ids, err := todoStore.CompactIDs(username)
+		if err != nil {
+			return err
+		}
+		if err := reminder.RemapIDs(remindersPath, username, ids); err != nil {
+			return fmt.Errorf("todos renumbered but sent reminders were not: %v", err)
+		}
+		if prefs := userStore.GetUser(username).Preferences; prefs.SelectedID != 0 {
+			// A deleted todo's ID maps to 0, which selects the top
+			prefs.SelectedID = ids[prefs.SelectedID]
+			if err := userStore.SetPreferences(username, prefs); err != nil {
+				return fmt.Errorf("todos renumbered but the selected todo was not: %v", err)
+			}
+		}
+		fmt.Fprintf(out, "Renumbered todos for %s\n", username)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
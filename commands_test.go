@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// setupCommandStores creates stores in a temporary data directory with one
+// registered user, alice, returning the path of the reminders state file
+func setupCommandStores(t *testing.T) (*user.Store, *todo.Store, string) {
+	dataDir := t.TempDir()
+	userStore, err := user.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+	todoStore, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	if err := userStore.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return userStore, todoStore, filepath.Join(dataDir, "reminders.json")
+}
+
+// run runs a command with the given standard input, returning its output
+func run(t *testing.T, userStore *user.Store, todoStore *todo.Store, remindersPath, input string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := runCommand(args, userStore, todoStore, remindersPath, strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+// TestUserCommands verifies adding, listing and deleting users
+func TestUserCommands(t *testing.T) {
+	userStore, todoStore, remindersPath := setupCommandStores(t)
+
+	if out, err := run(t, userStore, todoStore, remindersPath, "secret123\n", "user", "add", "bob"); err != nil || out != "Added user bob\n" {
+		t.Fatalf("user add = %q, %v; want bob added", out, err)
+	}
+	if _, ok := userStore.Authenticate("bob", "secret123"); !ok {
+		t.Error("bob can't log in with the password read from standard input")
+	}
+	if out, err := run(t, userStore, todoStore, remindersPath, "", "user", "list"); err != nil || out != "alice\nbob\n" {
+		t.Errorf("user list = %q, %v; want alice and bob", out, err)
+	}
+
+	todoStore.Add("bob", "Private")
+	if out, err := run(t, userStore, todoStore, remindersPath, "", "user", "delete", "bob"); err != nil || out != "Deleted user bob\n" {
+		t.Fatalf("user delete = %q, %v; want bob deleted", out, err)
+	}
+	if userStore.GetUser("bob") != nil || todoStore.HasTodoFile("bob") {
+		t.Error("bob or their todos remain after user delete")
+	}
+
+	if _, err := run(t, userStore, todoStore, remindersPath, "", "user", "frobnicate"); err == nil {
+		t.Error("unknown command succeeded; want error")
+	}
+}
+
+// TestImportExportCommands verifies that a checklist imported on standard
+// input can be listed and exported, and that unknown users are refused
+func TestImportExportCommands(t *testing.T) {
+	userStore, todoStore, remindersPath := setupCommandStores(t)
+
+	checklist := "# Groceries\n- [ ] Milk\n* [x] Bread\n"
+	if out, err := run(t, userStore, todoStore, remindersPath, checklist, "todo", "import", "alice"); err != nil || out != "Imported todos for alice\n" {
+		t.Fatalf("todo import = %q, %v; want todos imported", out, err)
+	}
+
+	out, err := run(t, userStore, todoStore, remindersPath, "", "todo", "list", "alice")
+	if err != nil || out != "[ ]\t1\tMilk\n[x]\t2\tBread\n" {
+		t.Errorf("todo list = %q, %v; want Milk and completed Bread", out, err)
+	}
+
+	out, err = run(t, userStore, todoStore, remindersPath, "", "todo", "export", "alice")
+	if err != nil {
+		t.Fatalf("todo export error = %v", err)
+	}
+	var exported []*todo.Todo
+	if err := json.Unmarshal([]byte(out), &exported); err != nil {
+		t.Fatalf("todo export is not JSON: %v\n%s", err, out)
+	}
+	if len(exported) != 2 || exported[0].Text != "Milk" || exported[0].Completed || exported[1].Text != "Bread" || !exported[1].Completed {
+		t.Errorf("todo export = %s; want Milk and completed Bread", out)
+	}
+
+	for _, command := range []string{"list", "export", "import", "compact"} {
+		if _, err := run(t, userStore, todoStore, remindersPath, checklist, "todo", command, "nobody"); err == nil {
+			t.Errorf("todo %s for unknown user succeeded; want error", command)
+		}
+	}
+	if todoStore.HasTodoFile("nobody") {
+		t.Error("todo import for unknown user created a todos file")
+	}
+}
+
+// TestCompactCommand verifies that compacting renumbers the todos along
+// with the reminders already sent for them and the selected todo
+func TestCompactCommand(t *testing.T) {
+	userStore, todoStore, remindersPath := setupCommandStores(t)
+
+	todoStore.AddMany("alice", []string{"First", "Second", "Third"})
+	if _, err := todoStore.Delete("alice", 2); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	prefs := userStore.GetUser("alice").Preferences
+	prefs.SelectedID = 3
+	if err := userStore.SetPreferences("alice", prefs); err != nil {
+		t.Fatalf("SetPreferences() error = %v", err)
+	}
+	due := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+	data, _ := json.Marshal(map[string]time.Time{"alice/2": due, "alice/3": due, "bob/3": due})
+	if err := os.WriteFile(remindersPath, data, 0600); err != nil {
+		t.Fatalf("Failed to write reminders state: %v", err)
+	}
+
+	if out, err := run(t, userStore, todoStore, remindersPath, "", "todo", "compact", "alice"); err != nil || out != "Renumbered todos for alice\n" {
+		t.Fatalf("todo compact = %q, %v; want todos renumbered", out, err)
+	}
+
+	todos, _ := todoStore.List("alice")
+	if len(todos) != 2 || todos[0].ID != 1 || todos[1].ID != 2 || todos[1].Text != "Third" {
+		t.Errorf("todos after compact = %v; want First as 1 and Third as 2", todos)
+	}
+	if selected := userStore.GetUser("alice").Preferences.SelectedID; selected != 2 {
+		t.Errorf("SelectedID after compact = %d; want 2", selected)
+	}
+
+	data, err := os.ReadFile(remindersPath)
+	if err != nil {
+		t.Fatalf("Failed to read reminders state: %v", err)
+	}
+	var state map[string]time.Time
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to parse reminders state: %v", err)
+	}
+	if len(state) != 2 || !state["alice/2"].Equal(due) || !state["bob/3"].Equal(due) {
+		t.Errorf("reminders state after compact = %v; want alice/3 moved to alice/2 and bob untouched", state)
+	}
+}
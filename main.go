@@ -1,16 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"todoissh/pkg/admin"
 	"todoissh/pkg/config"
+	"todoissh/pkg/reminder"
 	sshpkg "todoissh/pkg/ssh"
 	"todoissh/pkg/todo"
 	"todoissh/pkg/ui"
 	"todoissh/pkg/user"
 
+	"github.com/spf13/pflag"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -31,18 +43,31 @@ func main() {
 
 	// Configure logging based on verbosity level
 	setupLogging(cfg.LogLevel)
+	sshpkg.SetLogLevel(cfg.LogLevel)
+
+	// Subcommands print their results to stdout, so keep logs out of it
+	args := pflag.Args()
+	if len(args) > 0 {
+		log.SetOutput(os.Stderr)
+	}
 
 	// Use DATA_DIR environment variable if set, otherwise use default "data"
 	dataDir := os.Getenv("DATA_DIR")
 	if dataDir == "" {
 		dataDir = "data"
 	}
-	log.Printf("Using data directory: %s", dataDir)
+	logInfo("Using data directory: %s", dataDir)
+	remindersPath := filepath.Join(dataDir, "reminders.json")
 
 	// Create data directory
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
+	// MkdirAll succeeds for an existing directory on a read-only mount, and
+	// saves would only start failing once people use the server
+	if err := checkWritable(dataDir); err != nil {
+		log.Fatalf("Data directory %s is not writable: %v", dataDir, err)
+	}
 
 	// Set the host key path to be in the data directory
 	hostKeyPath := filepath.Join(dataDir, "id_rsa")
@@ -51,33 +76,168 @@ func main() {
 		cfg.HostKey = hostKeyPath
 	}
 
-	// Initialize user store
-	userStore, err := user.NewStore(dataDir)
+	// Initialize user store, locking the data directory if asked to
+	newUserStore := user.NewStore
+	if cfg.LockDataDir {
+		newUserStore = user.NewLockedStore
+	}
+	userStore, err := newUserStore(dataDir)
 	if err != nil {
 		log.Fatalf("Failed to initialize user store: %v", err)
 	}
+	userStore.SetPasswordPolicy(user.Policy{
+		MinLength:        cfg.PasswordMinLength,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireMixedCase: cfg.PasswordRequireMixedCase,
+		RequireSymbol:    cfg.PasswordRequireSymbol,
+	})
+	userStore.SetInviteOnly(cfg.InviteOnly)
+	userStore.SetReservedNames(cfg.ReservedNames)
+	userStore.SetMaxRegistrations(cfg.MaxRegistrations)
+	userStore.SetDeletionArchiveDir(cfg.DeletionArchiveDir)
+
+	// Admin commands run against the stores and exit
+	if cfg.ResetUser != "" {
+		if err := resetPassword(userStore, cfg.ResetUser, os.Stdin); err != nil {
+			log.Fatalf("Failed to reset password for %s: %v", cfg.ResetUser, err)
+		}
+		return
+	}
 
 	// Initialize todo store
 	todoStore, err := todo.NewStore(dataDir)
 	if err != nil {
 		log.Fatalf("Failed to initialize todo store: %v", err)
 	}
+	if err := todoStore.SetTodosDir(cfg.TodosDir); err != nil {
+		log.Fatalf("Failed to set todos directory: %v", err)
+	}
+	// Always applied, so dropping the flag moves files back to the flat layout
+	if err := todoStore.SetSharded(cfg.ShardTodos); err != nil {
+		log.Fatalf("Failed to migrate todo store layout: %v", err)
+	}
+	todoStore.SetTimestampPrecision(cfg.TimestampPrecision)
+	todoStore.SetQuota(cfg.UserQuota)
+	todoStore.SetEnforceBlockers(cfg.EnforceBlockers)
+	todoStore.SetDeletionArchiveDir(cfg.DeletionArchiveDir)
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			log.Fatalf("Invalid time zone %q: %v", cfg.Timezone, err)
+		}
+		todoStore.SetLocation(loc)
+	}
+
+	// Offline administration subcommands exit without starting the server
+	if len(args) > 0 {
+		if err := runCommand(args, userStore, todoStore, remindersPath, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Rotate the host key if requested; clients will see a changed key warning
+	if cfg.RegenHostKey {
+		if cfg.HostKeyDir != "" {
+			log.Fatalf("--regen-hostkey can't be used with --hostkey-dir")
+		}
+		if err := sshpkg.RegenerateHostKey(cfg.HostKey); err != nil {
+			log.Fatalf("Failed to regenerate host key: %v", err)
+		}
+	}
 
 	// Create and start SSH server
-	log.Printf("Starting server on port %d...", cfg.Port)
-	server, err := sshpkg.NewServer(cfg.Port, cfg.HostKey, userStore)
+	logInfo("Starting server on port %d...", cfg.Port)
+	var server *sshpkg.Server
+	if cfg.HostKeyDir != "" {
+		server, err = sshpkg.NewServerFromKeyDir(cfg.Port, cfg.HostKeyDir, userStore)
+	} else {
+		server, err = sshpkg.NewServer(cfg.Port, cfg.HostKey, userStore)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create SSH server: %v", err)
 	}
 
+	server.SetTodoStore(todoStore)
+	server.SetMaxSessionsPerUser(cfg.MaxSessions)
+	server.SetHandshakeTimeout(cfg.HandshakeTimeout)
+	if err := server.SetNetwork(cfg.Network); err != nil {
+		log.Fatalf("Invalid --network: %v", err)
+	}
+
+	// Serve admin requests against the live stores if enabled
+	var adminServer *admin.Server
+	if cfg.AdminSocket != "" {
+		adminServer, err = admin.Listen(cfg.AdminSocket, userStore, todoStore, filepath.Join(dataDir, "backups"))
+		if err != nil {
+			log.Fatalf("Failed to start admin socket: %v", err)
+		}
+		adminServer.SetUptime(server.Uptime)
+		adminServer.SetMaintenance(server.SetReadOnly)
+		logInfo("Admin socket listening on %s", cfg.AdminSocket)
+	}
+
+	// Post reminders for due todos if enabled
+	var notifier *reminder.Notifier
+	if cfg.ReminderWebhook != "" {
+		notifier, err = reminder.New(cfg.ReminderWebhook, userStore, todoStore, remindersPath)
+		if err != nil {
+			log.Fatalf("Failed to start reminders: %v", err)
+		}
+		notifier.Start(cfg.ReminderInterval)
+		logInfo("Sending reminders to %s", cfg.ReminderWebhook)
+	}
+
+	// Archive long-completed todos in the background if enabled
+	stopArchiving := make(chan struct{})
+	var archiving sync.WaitGroup
+	if cfg.AutoArchiveAfter > 0 {
+		todoStore.SetAutoArchiveAfter(cfg.AutoArchiveAfter)
+		archiving.Add(1)
+		go func() {
+			defer archiving.Done()
+			autoArchive(userStore, todoStore, autoArchiveInterval, stopArchiving)
+		}()
+		logInfo("Archiving todos completed more than %v ago", cfg.AutoArchiveAfter)
+	}
+
+	inputLayout := ui.InputLayout{Separator: cfg.InputSeparator, Row: cfg.InputRow}
+	if err := inputLayout.Validate(); err != nil {
+		log.Fatalf("Invalid input layout: %v", err)
+	}
+
 	// Set channel handler
-	server.SetChannelHandler(func(username string, channel ssh.Channel, requests <-chan *ssh.Request) {
-		// Check if this is a new user
-		isNewUser := userStore.GetUser(username) == nil
+	server.SetChannelHandlerContext(func(ctx context.Context, info sshpkg.SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		username := info.Username
+		if info.IsNew && todoStore.HasTodoFile(username) && !claimOrphanedTodos(username, todoStore, cfg.ReclaimOrphans) {
+			channel.Close()
+			return
+		}
 
 		// Create terminal UI with user information
-		termUI := ui.NewTerminalUI(channel, todoStore, userStore, username, isNewUser)
-		termUI.HandleChannel(requests)
+		termUI := ui.NewTerminalUI(channel, todoStore, userStore, username, info.IsNew)
+		if currentUser := userStore.GetUser(username); currentUser != nil {
+			termUI.ApplyPreferences(currentUser.Preferences)
+		}
+		termUI.SetIdleTimeout(cfg.IdleTimeout)
+		termUI.SetMaxSessionDuration(cfg.MaxDuration)
+		termUI.SetASCII(cfg.ASCII)
+		if cfg.WelcomeMessage != nil || cfg.GoodbyeMessage != nil {
+			welcome, goodbye := ui.DefaultStrings.Welcome, ui.DefaultStrings.Goodbye
+			if cfg.WelcomeMessage != nil {
+				welcome = *cfg.WelcomeMessage
+			}
+			if cfg.GoodbyeMessage != nil {
+				goodbye = *cfg.GoodbyeMessage
+			}
+			termUI.SetGreetings(welcome, goodbye)
+		}
+		if cfg.EmptyListMessage != nil {
+			termUI.SetEmptyListMessage(*cfg.EmptyListMessage)
+		}
+		termUI.SetAutoAdd(cfg.AutoAdd)
+		termUI.SetInputLayout(inputLayout)
+		termUI.HandleChannelContext(ctx, requests)
 	})
 
 	// Start server
@@ -85,9 +245,141 @@ func main() {
 		log.Fatalf("Server error: %v", err)
 	}
 
-	// Keep the main function running
-	log.Printf("Server running on port %d. Press Ctrl+C to exit...", cfg.Port)
-	select {} // Block forever
+	// Keep running until interrupted or terminated
+	logInfo("Server running on port %d with %d registered users. Press Ctrl+C to exit...", cfg.Port, userStore.Count())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	flush := flushOnSignal(todoStore)
+	<-stop
+	signal.Stop(flush)
+
+	// Shut down cleanly: stop everything that changes the stores, waiting
+	// for sessions to finish, then save what is left and release the lock
+	logInfo("Shutting down...")
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	if notifier != nil {
+		notifier.Stop()
+	}
+	close(stopArchiving)
+	archiving.Wait()
+	server.Close()
+	if err := todoStore.FlushAll(); err != nil {
+		log.Printf("Failed to save todos on shutdown: %v", err)
+	}
+	userStore.Close()
+}
+
+// flushOnSignal saves unsaved todos whenever one of the flush signals
+// arrives, returning the channel they are delivered on
+func flushOnSignal(todoStore *todo.Store) chan os.Signal {
+	flush := make(chan os.Signal, 1)
+	if len(flushSignals) == 0 {
+		return flush
+	}
+	signal.Notify(flush, flushSignals...)
+	go func() {
+		for range flush {
+			n := todoStore.PendingWrites()
+			if err := todoStore.FlushAll(); err != nil {
+				log.Printf("Failed to save todos: %v", err)
+				continue
+			}
+			logInfo("Saved unsaved todos of %d user(s)", n)
+		}
+	}()
+	return flush
+}
+
+// resetPassword reads a new password from r and sets it for username
+func resetPassword(userStore *user.Store, username string, r io.Reader) error {
+	fmt.Fprintf(os.Stderr, "New password for %s: ", username)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("failed to read password: %v", err)
+	}
+
+	if err := userStore.AdminResetPassword(username, strings.TrimRight(line, "\r\n")); err != nil {
+		return err
+	}
+	logInfo("Admin reset the password of user %s", username)
+	return nil
+}
+
+// autoArchiveInterval is how often completed todos are checked for archiving
+const autoArchiveInterval = time.Hour
+
+// autoArchive archives every user's long-completed todos, then again every
+// interval until stop is closed. The todo store's lock keeps each sweep safe
+// alongside sessions.
+func autoArchive(userStore *user.Store, todoStore *todo.Store, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		total := 0
+		for _, username := range userStore.Usernames() {
+			n, err := todoStore.AutoArchive(username)
+			if err != nil {
+				log.Printf("Failed to archive completed todos of %s: %v", username, err)
+				continue
+			}
+			total += n
+		}
+		if total > 0 {
+			logInfo("Archived %d completed todos", total)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// claimOrphanedTodos deals with todos left by a user who is missing from
+// the user store, e.g. after users.json was lost. Unless reclaim is set they
+// are archived, so registering the same name doesn't hand them to a
+// stranger. It reports whether the session may continue.
+func claimOrphanedTodos(username string, todoStore *todo.Store, reclaim bool) bool {
+	if reclaim {
+		logInfo("User %s is registering over existing todos; they will be restored", username)
+		return true
+	}
+
+	path, err := todoStore.ArchiveUser(username)
+	if err != nil {
+		log.Printf("Failed to archive orphaned todos of %s: %v", username, err)
+		return false
+	}
+	logInfo("Archived orphaned todos of unregistered user %s to %s (use --reclaim-orphaned-todos to restore them on registration)", username, path)
+	return true
+}
+
+// checkWritable creates and removes a file in dir to make sure the server
+// will be able to save there
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	closeErr := f.Close()
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// quiet suppresses informational startup messages when set
+var quiet bool
+
+// logInfo logs an informational message unless quiet mode is enabled
+func logInfo(format string, v ...any) {
+	if quiet {
+		return
+	}
+	log.Printf(format, v...)
 }
 
 // setupLogging configures the logging based on the verbosity level
@@ -95,8 +387,12 @@ func setupLogging(level config.LogLevel) {
 	// Default logger settings
 	log.SetOutput(os.Stdout)
 	log.SetFlags(log.LstdFlags)
+	quiet = level == config.LogLevelQuiet
 
 	switch level {
+	case config.LogLevelQuiet:
+		// For quiet mode, only warnings and errors are logged
+		log.SetFlags(log.LstdFlags)
 	case config.LogLevelNormal:
 		// For normal mode, use minimal logging
 		log.SetFlags(log.LstdFlags)
@@ -0,0 +1,92 @@
+// Package account coordinates the user and todo stores for operations that
+// span a whole account, such as data portability exports.
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// BundleVersion is the format version written by ExportUser
+const BundleVersion = 1
+
+// Profile holds the non-secret parts of a user record
+type Profile struct {
+	Username    string           `json:"username"`
+	CreatedAt   time.Time        `json:"created_at,omitempty"`
+	Preferences user.Preferences `json:"preferences"`
+}
+
+// Bundle is a complete, portable export of one user's data.
+// It never contains the password hash.
+type Bundle struct {
+	Version int             `json:"version"`
+	Profile Profile         `json:"profile"`
+	Todos   json.RawMessage `json:"todos"`
+}
+
+// ExportUser assembles the user's profile and todos into a JSON bundle
+func ExportUser(users *user.Store, todos *todo.Store, username string) ([]byte, error) {
+	u := users.GetUser(username)
+	if u == nil {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+
+	todoData, err := todos.ExportJSON(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export todos: %v", err)
+	}
+
+	bundle := Bundle{
+		Version: BundleVersion,
+		Profile: Profile{
+			Username:    u.Username,
+			CreatedAt:   u.CreatedAt,
+			Preferences: u.Preferences,
+		},
+		Todos: todoData,
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize bundle: %v", err)
+	}
+	return data, nil
+}
+
+// ImportUser restores a bundle produced by ExportUser. Since bundles carry
+// no credentials, the account is (re)registered with the given password.
+// The user's todos are replaced by the ones in the bundle.
+func ImportUser(users *user.Store, todos *todo.Store, data []byte, password string) (string, error) {
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return "", fmt.Errorf("failed to parse bundle: %v", err)
+	}
+	if bundle.Version != BundleVersion {
+		return "", fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+
+	username := bundle.Profile.Username
+	if username == "" {
+		return "", fmt.Errorf("bundle has no username")
+	}
+
+	if err := users.Upsert(username, password); err != nil {
+		return "", fmt.Errorf("failed to register user: %v", err)
+	}
+	if err := users.SetPreferences(username, bundle.Profile.Preferences); err != nil {
+		return "", fmt.Errorf("failed to restore preferences: %v", err)
+	}
+
+	if len(bundle.Todos) > 0 {
+		if err := todos.ImportJSON(username, bundle.Todos, false); err != nil {
+			return "", fmt.Errorf("failed to import todos: %v", err)
+		}
+	}
+
+	return username, nil
+}
@@ -0,0 +1,101 @@
+package account
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Test constants
+const (
+	testUsername = "testuser"
+	testPassword = "test-password123"
+)
+
+// setupTestStores creates a temporary data directory with user and todo stores.
+// The caller is responsible for removing the returned directory.
+func setupTestStores(t *testing.T) (*user.Store, *todo.Store, string) {
+	tempDir, err := os.MkdirTemp("", "todoissh-account-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	users, err := user.NewStore(tempDir)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+	users.SetPasswordCost(bcrypt.MinCost)
+	todos, err := todo.NewStore(tempDir)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+
+	return users, todos, tempDir
+}
+
+// TestExportImportUser verifies that a bundle round-trips todos and preferences
+// but never carries the password hash
+func TestExportImportUser(t *testing.T) {
+	users, todos, tempDir := setupTestStores(t)
+	defer os.RemoveAll(tempDir)
+
+	if _, err := ExportUser(users, todos, testUsername); err == nil {
+		t.Error("ExportUser() did not return error for unknown user")
+	}
+
+	if err := users.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	colors := false
+	if err := users.SetPreferences(testUsername, user.Preferences{Colors: &colors}); err != nil {
+		t.Fatalf("SetPreferences() error = %v", err)
+	}
+	if _, err := todos.Add(testUsername, "Portable todo"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	data, err := ExportUser(users, todos, testUsername)
+	if err != nil {
+		t.Fatalf("ExportUser() error = %v", err)
+	}
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), users.GetUser(testUsername).PasswordHash) {
+		t.Error("Bundle contains password data")
+	}
+
+	// Import into a fresh data directory
+	users2, todos2, tempDir2 := setupTestStores(t)
+	defer os.RemoveAll(tempDir2)
+
+	newPassword := "fresh-password"
+	username, err := ImportUser(users2, todos2, data, newPassword)
+	if err != nil {
+		t.Fatalf("ImportUser() error = %v", err)
+	}
+	if username != testUsername {
+		t.Errorf("ImportUser() username = %q; want %q", username, testUsername)
+	}
+	if _, ok := users2.Authenticate(testUsername, newPassword); !ok {
+		t.Error("Imported user cannot authenticate with the fresh password")
+	}
+	if prefs := users2.GetUser(testUsername).Preferences; prefs.Colors == nil || *prefs.Colors {
+		t.Errorf("Preferences not restored: %+v", prefs)
+	}
+	list, err := todos2.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 1 || list[0].Text != "Portable todo" {
+		t.Errorf("Imported todos = %v; want one %q", list, "Portable todo")
+	}
+
+	if _, err := ImportUser(users2, todos2, []byte("{}"), newPassword); err == nil {
+		t.Error("ImportUser() did not return error for empty bundle")
+	}
+}
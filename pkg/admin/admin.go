@@ -0,0 +1,361 @@
+// Package admin serves operator requests over a local Unix socket so the
+// live stores can be inspected and backed up without stopping the server.
+//
+// Each connection carries newline-delimited JSON. A request looks like
+//
+//	{"id": 1, "method": "todos.count", "params": {"username": "alice"}}
+//
+// and is answered on one line with either a result or an error:
+//
+//	{"id": 1, "result": {"total": 3, "completed": 1, "pending": 2}}
+//	{"id": 1, "error": "unknown method \"foo\""}
+//
+// Methods:
+//
+//	users.list   -> ["alice", "bob"]
+//	users.invite -> {"code": "..."}, a one-time login code for params.username
+//	todos.count  -> counts for params.username, or for every user by name
+//	todos.usage  -> bytes on disk for params.username, or for every user by name
+//	summary      -> instance totals, and counts for up to params.limit users
+//	backup       -> {"path": "<backup directory>"}
+//	maintenance  -> {"read_only": true}; params.enabled turns read-only mode on or off
+//	status       -> {"started_at": "...", "uptime_seconds": 3600} of the SSH server
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// maxRequestSize bounds a single request line
+const maxRequestSize = 64 * 1024
+
+// Request is a single admin call
+type Request struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response answers a Request. Exactly one of Result and Error is set.
+type Response struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Result any             `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Counts summarizes a user's todos
+type Counts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Pending   int `json:"pending"`
+}
+
+// DefaultSummaryLimit is how many users a summary lists unless the request
+// asks for a different number
+const DefaultSummaryLimit = 100
+
+// UserSummary is a user's entry in a summary
+type UserSummary struct {
+	Counts
+	Completion float64 `json:"completion"` // Completed share of current todos, 0 without any
+}
+
+// Summary is a bird's-eye view of the instance
+type Summary struct {
+	Users     int                    `json:"users"`
+	Todos     int                    `json:"todos"` // Across every todos file, including ones without an account
+	PerUser   map[string]UserSummary `json:"per_user"`
+	Truncated bool                   `json:"truncated"` // More users exist than are listed
+}
+
+// Status describes the running SSH server
+type Status struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+}
+
+// Server answers admin requests on a Unix socket
+type Server struct {
+	listener  net.Listener
+	users     *user.Store
+	todos     *todo.Store
+	backupDir string
+	wg        sync.WaitGroup
+
+	mu          sync.Mutex
+	uptime      func() time.Duration // reported by status; nil until SetUptime
+	setReadOnly func(bool)           // used by maintenance; nil until SetMaintenance
+}
+
+// Listen creates the socket at path, readable only by the server's user,
+// and starts serving requests. Backups are written under backupDir.
+func Listen(path string, users *user.Store, todos *todo.Store, backupDir string) (*Server, error) {
+	// Remove a socket left behind by a previous run
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin socket: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict admin socket: %v", err)
+	}
+
+	s := &Server{
+		listener:  listener,
+		users:     users,
+		todos:     todos,
+		backupDir: backupDir,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// SetUptime sets where status learns how long the SSH server has been
+// running, usually its Uptime method
+func (s *Server) SetUptime(uptime func() time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uptime = uptime
+}
+
+// SetMaintenance sets how the maintenance method turns read-only mode on
+// and off, usually the SSH server's SetReadOnly. Until it is called, the
+// todo store is switched directly.
+func (s *Server) SetMaintenance(setReadOnly func(bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setReadOnly = setReadOnly
+}
+
+// Close stops accepting requests and waits for open connections to finish
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Admin socket accept failed: %v", err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn answers each request line on the connection until it closes
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := encoder.Encode(s.handle(scanner.Bytes())); err != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		encoder.Encode(Response{Error: fmt.Sprintf("invalid request: %v", err)})
+	}
+}
+
+// handle decodes and runs a single request
+func (s *Server) handle(line []byte) Response {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return Response{Error: fmt.Sprintf("invalid request: %v", err)}
+	}
+
+	result, err := s.call(req)
+	if err != nil {
+		return Response{ID: req.ID, Error: err.Error()}
+	}
+	return Response{ID: req.ID, Result: result}
+}
+
+// call dispatches a request to its method
+func (s *Server) call(req Request) (any, error) {
+	switch req.Method {
+	case "users.list":
+		return s.users.Usernames(), nil
+
+	case "users.invite":
+		var params struct {
+			Username string `json:"username"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, fmt.Errorf("invalid params: %v", err)
+			}
+		}
+		code, err := s.users.CreateOneTimeCode(params.Username)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Admin created a one-time code for %s", params.Username)
+		return map[string]string{"code": code}, nil
+
+	case "todos.count":
+		return perUser(s, req, s.count)
+
+	case "todos.usage":
+		return perUser(s, req, s.todos.UserDiskUsage)
+
+	case "summary":
+		var params struct {
+			Limit int `json:"limit"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, fmt.Errorf("invalid params: %v", err)
+			}
+		}
+		if params.Limit <= 0 {
+			params.Limit = DefaultSummaryLimit
+		}
+		return s.summary(params.Limit)
+
+	case "backup":
+		dir := filepath.Join(s.backupDir, time.Now().Format("20060102-150405.000"))
+		if err := s.users.Backup(dir); err != nil {
+			return nil, err
+		}
+		if err := s.todos.Backup(dir); err != nil {
+			return nil, err
+		}
+		log.Printf("Admin backup written to %s", dir)
+		return map[string]string{"path": dir}, nil
+
+	case "maintenance":
+		var params struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, fmt.Errorf("invalid params: %v", err)
+			}
+		}
+		if params.Enabled != nil {
+			s.mu.Lock()
+			setReadOnly := s.setReadOnly
+			s.mu.Unlock()
+			if setReadOnly == nil {
+				setReadOnly = s.todos.SetReadOnly
+			}
+			setReadOnly(*params.Enabled)
+			log.Printf("Admin set maintenance mode to %v", *params.Enabled)
+		}
+		return map[string]bool{"read_only": s.todos.ReadOnly()}, nil
+
+	case "status":
+		s.mu.Lock()
+		uptime := s.uptime
+		s.mu.Unlock()
+		if uptime == nil {
+			return nil, errors.New("server status is not available")
+		}
+		up := uptime()
+		return Status{
+			StartedAt:     time.Now().Add(-up).Round(time.Second),
+			UptimeSeconds: int64(up / time.Second),
+		}, nil
+
+	case "":
+		return nil, errors.New("missing method")
+	}
+	return nil, fmt.Errorf("unknown method %q", req.Method)
+}
+
+// perUser answers a request with get for params.username, or for every
+// user by name when no username is given
+func perUser[T any](s *Server, req Request, get func(string) (T, error)) (any, error) {
+	var params struct {
+		Username string `json:"username"`
+	}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, fmt.Errorf("invalid params: %v", err)
+		}
+	}
+	if params.Username != "" {
+		if s.users.GetUser(params.Username) == nil {
+			return nil, fmt.Errorf("user %s not found", params.Username)
+		}
+		return get(params.Username)
+	}
+	results := make(map[string]T)
+	for _, name := range s.users.Usernames() {
+		result, err := get(name)
+		if err != nil {
+			return nil, err
+		}
+		results[name] = result
+	}
+	return results, nil
+}
+
+// count summarizes one user's todos
+func (s *Server) count(username string) (Counts, error) {
+	stats, err := s.todos.Stats(username)
+	if err != nil {
+		return Counts{}, err
+	}
+	return Counts{Total: stats.Total, Completed: stats.Completed, Pending: stats.Pending}, nil
+}
+
+// summary totals the instance and lists the first limit users by name.
+// Users who aren't cached are read from disk without being cached, one at
+// a time, so a summary doesn't load everyone's todos into memory.
+func (s *Server) summary(limit int) (Summary, error) {
+	total, err := s.todos.TotalTodos()
+	if err != nil {
+		return Summary{}, err
+	}
+	names := s.users.Usernames()
+	summary := Summary{
+		Users:     len(names),
+		Todos:     total,
+		PerUser:   make(map[string]UserSummary),
+		Truncated: len(names) > limit,
+	}
+	for _, name := range names[:min(limit, len(names))] {
+		stats, err := s.todos.StoredStats(name)
+		if err != nil {
+			return Summary{}, err
+		}
+		entry := UserSummary{Counts: Counts{Total: stats.Total, Completed: stats.Completed, Pending: stats.Pending}}
+		if stats.Total > 0 {
+			entry.Completion = float64(stats.Completed) / float64(stats.Total)
+		}
+		summary.PerUser[name] = entry
+	}
+	return summary, nil
+}
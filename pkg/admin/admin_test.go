@@ -0,0 +1,243 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setupTestServer starts an admin server over fresh stores with one user
+func setupTestServer(t *testing.T) (*Server, string, string) {
+	dataDir, err := os.MkdirTemp("", "todoissh-admin-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	users, err := user.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+	users.SetPasswordCost(bcrypt.MinCost)
+	todos, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	todos.AddMany("alice", []string{"One", "Two"})
+	todos.ToggleComplete("alice", 1)
+
+	socket := filepath.Join(dataDir, "admin.sock")
+	server, err := Listen(socket, users, todos, filepath.Join(dataDir, "backups"))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, socket, dataDir
+}
+
+// TestAdminSocket verifies the request/response protocol, including
+// malformed requests, over a single connection
+func TestAdminSocket(t *testing.T) {
+	server, socket, dataDir := setupTestServer(t)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("socket missing: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode = %04o; want 0600", mode)
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	call := func(line string) map[string]any {
+		t.Helper()
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		reply, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("ReadBytes() error = %v", err)
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(reply, &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", reply, err)
+		}
+		return resp
+	}
+
+	resp := call(`{"id": 1, "method": "users.list"}`)
+	if users, ok := resp["result"].([]any); !ok || len(users) != 1 || users[0] != "alice" || resp["id"] != 1.0 {
+		t.Errorf("users.list = %v; want [alice] with id 1", resp)
+	}
+
+	resp = call(`{"id": "a", "method": "todos.count", "params": {"username": "alice"}}`)
+	counts, _ := resp["result"].(map[string]any)
+	if counts["total"] != 2.0 || counts["completed"] != 1.0 || counts["pending"] != 1.0 {
+		t.Errorf("todos.count = %v; want total 2, completed 1, pending 1", resp)
+	}
+	resp = call(`{"method": "todos.count"}`)
+	if all, _ := resp["result"].(map[string]any); all["alice"] == nil {
+		t.Errorf("todos.count without username = %v; want counts by user", resp)
+	}
+	if resp := call(`{"method": "todos.count", "params": {"username": "bob"}}`); resp["error"] == nil {
+		t.Errorf("todos.count for unknown user = %v; want error", resp)
+	}
+
+	resp = call(`{"method": "users.invite", "params": {"username": "carol"}}`)
+	if result, _ := resp["result"].(map[string]any); result["code"] == nil || !server.users.RedeemOneTimeCode("carol", result["code"].(string)) {
+		t.Errorf("users.invite = %v; want a code carol can log in with", resp)
+	}
+	if resp := call(`{"method": "users.invite", "params": {"username": "alice"}}`); resp["error"] == nil {
+		t.Errorf("users.invite for existing user = %v; want error", resp)
+	}
+
+	resp = call(`{"method": "todos.usage", "params": {"username": "alice"}}`)
+	if bytes, _ := resp["result"].(float64); bytes <= 0 {
+		t.Errorf("todos.usage = %v; want the size of alice's todos file", resp)
+	}
+
+	resp = call(`{"method": "backup"}`)
+	result, _ := resp["result"].(map[string]any)
+	path, _ := result["path"].(string)
+	if !strings.HasPrefix(path, filepath.Join(dataDir, "backups")) {
+		t.Fatalf("backup = %v; want a path under the backup directory", resp)
+	}
+	for _, name := range []string{"users.json", filepath.Join("todos", "alice.json")} {
+		if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+			t.Errorf("backup is missing %s: %v", name, err)
+		}
+	}
+
+	// Maintenance mode blocks changes until it is turned off again
+	resp = call(`{"method": "maintenance", "params": {"enabled": true}}`)
+	if result, _ := resp["result"].(map[string]any); result["read_only"] != true {
+		t.Errorf("maintenance on = %v; want read_only true", resp)
+	}
+	if _, err := server.todos.Add("alice", "Three"); !errors.Is(err, todo.ErrReadOnly) {
+		t.Errorf("Add() during maintenance error = %v; want ErrReadOnly", err)
+	}
+	resp = call(`{"method": "maintenance", "params": {"enabled": false}}`)
+	if result, _ := resp["result"].(map[string]any); result["read_only"] != false {
+		t.Errorf("maintenance off = %v; want read_only false", resp)
+	}
+
+	// Malformed requests get an error and the connection stays usable
+	for _, line := range []string{`not json`, `{"method": ""}`, `{"method": "nope"}`, `{"method": "todos.count", "params": 5}`} {
+		if resp := call(line); resp["error"] == nil || resp["result"] != nil {
+			t.Errorf("request %s = %v; want only an error", line, resp)
+		}
+	}
+	if resp := call(`{"method": "users.list"}`); resp["error"] != nil {
+		t.Errorf("connection unusable after malformed requests: %v", resp)
+	}
+}
+
+// TestSummary verifies instance totals, per-user completion ratios and the
+// cap on how many users are listed
+func TestSummary(t *testing.T) {
+	server, socket, _ := setupTestServer(t)
+	if err := server.users.Register("bob", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	server.todos.Add("carol", "No account") // Counted in the total only
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	summary := func(params string) Summary {
+		t.Helper()
+		conn.Write([]byte(`{"method": "summary", "params": ` + params + "}\n"))
+		reply, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("ReadBytes() error = %v", err)
+		}
+		var resp struct {
+			Result Summary `json:"result"`
+			Error  string  `json:"error"`
+		}
+		if err := json.Unmarshal(reply, &resp); err != nil || resp.Error != "" {
+			t.Fatalf("summary = %s; want a result", reply)
+		}
+		return resp.Result
+	}
+
+	got := summary(`{}`)
+	if got.Users != 2 || got.Todos != 3 || got.Truncated {
+		t.Errorf("summary = %+v; want 2 users, 3 todos, not truncated", got)
+	}
+	if alice := got.PerUser["alice"]; alice.Total != 2 || alice.Completed != 1 || alice.Completion != 0.5 {
+		t.Errorf("alice = %+v; want 1 of 2 completed", alice)
+	}
+	if bob, ok := got.PerUser["bob"]; !ok || bob.Total != 0 || bob.Completion != 0 {
+		t.Errorf("bob = %+v, %v; want listed with no todos", bob, ok)
+	}
+
+	got = summary(`{"limit": 1}`)
+	if len(got.PerUser) != 1 || got.PerUser["alice"].Total != 2 || !got.Truncated {
+		t.Errorf("summary with limit 1 = %+v; want only alice, truncated", got)
+	}
+}
+
+// TestStatus verifies that status reports the uptime it is given, and
+// fails when there is none
+func TestStatus(t *testing.T) {
+	server, _, _ := setupTestServer(t)
+	if _, err := server.call(Request{Method: "status"}); err == nil {
+		t.Error("status without SetUptime() succeeded; want error")
+	}
+
+	server.SetUptime(func() time.Duration { return 90 * time.Minute })
+	result, err := server.call(Request{Method: "status"})
+	if err != nil {
+		t.Fatalf("status error = %v", err)
+	}
+	status := result.(Status)
+	if status.UptimeSeconds != 5400 {
+		t.Errorf("uptime_seconds = %d; want 5400", status.UptimeSeconds)
+	}
+	if since := time.Since(status.StartedAt); since < 89*time.Minute || since > 91*time.Minute {
+		t.Errorf("started_at = %v; want 90 minutes ago", status.StartedAt)
+	}
+}
+
+// TestSetMaintenance verifies that maintenance goes through the function
+// given to SetMaintenance, so the SSH server can announce the change
+func TestSetMaintenance(t *testing.T) {
+	server, _, _ := setupTestServer(t)
+
+	var calls []bool
+	server.SetMaintenance(func(enabled bool) {
+		calls = append(calls, enabled)
+		server.todos.SetReadOnly(enabled)
+	})
+	result, err := server.call(Request{Method: "maintenance", Params: json.RawMessage(`{"enabled": true}`)})
+	if err != nil {
+		t.Fatalf("maintenance error = %v", err)
+	}
+	if result.(map[string]bool)["read_only"] != true || len(calls) != 1 || !calls[0] {
+		t.Errorf("maintenance = %v with calls %v; want read_only true through SetMaintenance", result, calls)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -17,15 +18,65 @@ const (
 type LogLevel int
 
 const (
-	LogLevelNormal LogLevel = iota
+	LogLevelQuiet LogLevel = iota - 1 // Only warnings and errors
+	LogLevelNormal
 	LogLevelVerbose
 	LogLevelDebug
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port     int
-	HostKey  string
+	Port        int
+	Network     string // tcp, tcp4, tcp6, or both for separate IPv4 and IPv6 listeners
+	HostKey     string
+	HostKeyDir  string // Load every key in this directory instead of HostKey
+	MaxSessions int
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+	ShardTodos  bool
+	TodosDir    string // Subdirectory of the data directory holding todo files
+	UserQuota   int64  // Maximum bytes of todos per user; 0 for unlimited
+	ASCII       bool   // Draw the UI with ASCII symbols only
+	AdminSocket string // Unix socket for admin requests; empty disables it
+
+	HandshakeTimeout time.Duration // Time allowed to finish the SSH handshake and log in
+
+	AutoArchiveAfter   time.Duration // Archive todos completed this long ago; 0 disables it
+	TimestampPrecision time.Duration // Truncate stored todo timestamps; 0 keeps full precision
+	Timezone           string        // IANA time zone for display and the today view; empty uses the local zone
+	EnforceBlockers    bool          // Refuse to complete todos while the todos they are blocked by are incomplete
+
+	ReminderWebhook  string // POST due todos here; empty disables reminders
+	ReminderInterval time.Duration
+
+	// Messages shown to users; nil keeps the built-in text, empty hides it
+	WelcomeMessage   *string
+	GoodbyeMessage   *string
+	EmptyListMessage *string
+
+	AutoAdd bool // Open the new todo input when a session starts with an empty list
+
+	// Input field layout
+	InputSeparator string // Drawn above the input; empty for none
+	InputRow       int    // Screen row of the input, counting up from 1 for the last row
+
+	// Password policy for registration
+	PasswordMinLength        int
+	PasswordRequireDigit     bool
+	PasswordRequireMixedCase bool
+	PasswordRequireSymbol    bool
+
+	InviteOnly       bool     // Only the operator can create accounts
+	ReservedNames    []string // Usernames nobody may register themselves
+	MaxRegistrations int      // Passwords hashed at once; 0 for unlimited
+
+	ReclaimOrphans     bool   // Let new accounts take over todos left by a lost users.json
+	DeletionArchiveDir string // Keep copies of deleted users' profiles and todos here; empty keeps none
+	LockDataDir        bool   // Refuse to start while another instance uses the data directory
+
+	ResetUser    string // Reset this user's password and exit
+	RegenHostKey bool   // Replace the host key before starting
+
 	ShowHelp bool
 	ShowVer  bool
 	LogLevel LogLevel
@@ -35,13 +86,54 @@ type Config struct {
 func ParseFlags() *Config {
 	cfg := &Config{
 		Port:     2222,
+		Network:  "tcp",
 		HostKey:  "id_rsa",
 		LogLevel: LogLevelNormal,
+
+		PasswordMinLength: 6,
+		ReminderInterval:  time.Minute,
+		HandshakeTimeout:  30 * time.Second,
+		MaxRegistrations:  4,
+
+		InputSeparator: "─",
+		InputRow:       2,
 	}
 
 	// Define command-line flags
 	pflag.IntVarP(&cfg.Port, "port", "p", cfg.Port, "Port number for the SSH server")
+	pflag.StringVar(&cfg.Network, "network", cfg.Network, "Address families to listen on: tcp (the platform default, often both), tcp4, tcp6, or both for separate IPv4 and IPv6 listeners")
 	pflag.StringVar(&cfg.HostKey, "hostkey", cfg.HostKey, "Path to the host key file")
+	pflag.StringVar(&cfg.HostKeyDir, "hostkey-dir", "", "Load every private key in this directory as a host key (overrides --hostkey)")
+	pflag.BoolVar(&cfg.RegenHostKey, "regen-hostkey", false, "Generate a new host key at startup, keeping the old one as <hostkey>.old")
+	pflag.IntVar(&cfg.MaxSessions, "max-sessions", cfg.MaxSessions, "Maximum concurrent sessions per user (0 for unlimited)")
+	pflag.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "Log out sessions idle for this long (0 to disable)")
+	pflag.DurationVar(&cfg.MaxDuration, "max-session-duration", cfg.MaxDuration, "Log out sessions after this long regardless of activity (0 to disable)")
+	pflag.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", cfg.HandshakeTimeout, "Drop connections that haven't finished the SSH handshake and logged in within this long (0 to disable)")
+	pflag.IntVar(&cfg.PasswordMinLength, "password-min-length", cfg.PasswordMinLength, "Minimum password length for new accounts")
+	pflag.BoolVar(&cfg.PasswordRequireDigit, "password-require-digit", cfg.PasswordRequireDigit, "Require new passwords to contain a digit")
+	pflag.BoolVar(&cfg.PasswordRequireMixedCase, "password-require-mixed-case", cfg.PasswordRequireMixedCase, "Require new passwords to contain upper and lower case letters")
+	pflag.BoolVar(&cfg.PasswordRequireSymbol, "password-require-symbol", cfg.PasswordRequireSymbol, "Require new passwords to contain a symbol")
+	pflag.BoolVar(&cfg.InviteOnly, "invite-only", false, "Refuse logins from unknown usernames instead of offering registration (add accounts with 'user add' or 'user invite')")
+	pflag.StringSliceVar(&cfg.ReservedNames, "reserved-names", nil, "Comma-separated usernames nobody may register, e.g. admin,root")
+	pflag.IntVar(&cfg.MaxRegistrations, "max-registrations", cfg.MaxRegistrations, "Maximum registrations hashing passwords at once; others wait briefly, then are asked to retry (0 for unlimited)")
+	pflag.StringVar(&cfg.Timezone, "timezone", "", "Time zone, e.g. Europe/Berlin, for showing timestamps and deciding what counts as today (default: the server's local zone)")
+	pflag.StringVar(&cfg.ReminderWebhook, "reminder-webhook", "", "POST a JSON reminder to this URL when a todo comes due (disabled by default)")
+	pflag.DurationVar(&cfg.ReminderInterval, "reminder-interval", cfg.ReminderInterval, "How often to check for due todos when reminders are enabled")
+	pflag.StringVar(&cfg.AdminSocket, "admin-socket", "", "Serve admin requests on this Unix socket (disabled by default)")
+	pflag.BoolVar(&cfg.ASCII, "ascii", false, "Draw the UI with ASCII symbols for every client, not just those without UTF-8")
+	pflag.Int64Var(&cfg.UserQuota, "user-quota", 0, "Maximum size in bytes of each user's todos file (0 for unlimited)")
+	pflag.StringVar(&cfg.TodosDir, "todos-dir", "todos", "Subdirectory of the data directory to keep todo files in (existing files are not moved)")
+	pflag.BoolVar(&cfg.ShardTodos, "shard-todos", cfg.ShardTodos, "Store todo files in hashed subdirectories (migrates existing files)")
+	pflag.DurationVar(&cfg.AutoArchiveAfter, "auto-archive-after", 0, "Move todos out of the list once they have been completed this long, e.g. 720h for 30 days (0 to disable)")
+	pflag.BoolVar(&cfg.EnforceBlockers, "enforce-blockers", false, "Only allow completing a todo once every todo it is blocked by is complete")
+	pflag.DurationVar(&cfg.TimestampPrecision, "timestamp-precision", cfg.TimestampPrecision, "Truncate stored todo timestamps to this granularity, e.g. 1s (0 for full precision)")
+
+	pflag.BoolVar(&cfg.ReclaimOrphans, "reclaim-orphaned-todos", false, "Give todos without a matching account to whoever registers that username (otherwise they are archived)")
+	pflag.StringVar(&cfg.DeletionArchiveDir, "deletion-archive-dir", "", "Keep a copy of each deleted user's profile and todos in a subdirectory of this directory (disabled by default)")
+	pflag.BoolVar(&cfg.LockDataDir, "lock-data-dir", false, "Lock the data directory, refusing to start while another server or command is using it")
+
+	// Admin commands
+	pflag.StringVar(&cfg.ResetUser, "reset-user", "", "Reset the password of this user, reading the new one from stdin, then exit")
 
 	// Help and version flags
 	pflag.BoolVarP(&cfg.ShowHelp, "help", "h", false, "Show help information")
@@ -50,16 +142,40 @@ func ParseFlags() *Config {
 	// Verbosity flags
 	verbose := pflag.BoolP("verbose", "v", false, "Enable verbose logging")
 	debug := pflag.Bool("debug", false, "Enable debug logging (implies verbose)")
+	quiet := pflag.BoolP("quiet", "q", false, "Only log warnings and errors")
+
+	// Branding flags
+	welcome := pflag.String("welcome-message", "", "Message shown at the top of the registration screen (empty to hide)")
+	goodbye := pflag.String("goodbye-message", "", "Message shown when a session ends (empty to hide)")
+	emptyList := pflag.String("empty-list-message", "", "Message shown instead of an empty todo list (empty to hide)")
+	pflag.BoolVar(&cfg.AutoAdd, "auto-add", false, "Start typing a new todo right away when a session opens on an empty list")
+
+	// Layout flags
+	pflag.StringVar(&cfg.InputSeparator, "input-separator", cfg.InputSeparator, "Character drawn in a line above the input field (empty for no line)")
+	pflag.IntVar(&cfg.InputRow, "input-row", cfg.InputRow, "Screen row of the input field, counting up from 1 for the last row")
 
 	// Parse flags
 	pflag.Parse()
 
+	// Only override messages that were given, since empty means hidden
+	if pflag.CommandLine.Changed("welcome-message") {
+		cfg.WelcomeMessage = welcome
+	}
+	if pflag.CommandLine.Changed("goodbye-message") {
+		cfg.GoodbyeMessage = goodbye
+	}
+	if pflag.CommandLine.Changed("empty-list-message") {
+		cfg.EmptyListMessage = emptyList
+	}
+
 	// Set log level based on verbosity flags
 	switch {
 	case *debug:
 		cfg.LogLevel = LogLevelDebug
 	case *verbose:
 		cfg.LogLevel = LogLevelVerbose
+	case *quiet:
+		cfg.LogLevel = LogLevelQuiet
 	default:
 		cfg.LogLevel = LogLevelNormal
 	}
@@ -74,10 +190,19 @@ func PrintVersion() {
 
 // PrintHelp prints the help information
 func PrintHelp() {
-	fmt.Printf("Usage: %s [OPTIONS]\n\n", os.Args[0])
+	fmt.Printf("Usage: %s [OPTIONS] [COMMAND]\n\n", os.Args[0])
 	fmt.Printf("A terminal-based todo list application accessible via SSH.\n\n")
 	fmt.Println("Options:")
 	pflag.PrintDefaults()
+	fmt.Println("\nCommands (run against the data directory, then exit):")
+	fmt.Println("  user list              List registered users")
+	fmt.Println("  user add <name>        Create a user, reading the password from stdin")
+	fmt.Println("  user invite <name>     Print a one-time code <name> can log in with to choose a password")
+	fmt.Println("  user delete <name>     Delete a user and their todos")
+	fmt.Println("  todo list <name>       Print a user's todos as tab-separated text")
+	fmt.Println("  todo export <name>     Print a user's todos as JSON")
+	fmt.Println("  todo import <name>     Add the \"- [ ]\" checklist items of Markdown on stdin to a user's todos")
+	fmt.Println("  todo compact <name>    Renumber a user's todos from 1, e.g. after deleting most of them (changes IDs)")
 }
 
 // NewConfig creates a new configuration with default values
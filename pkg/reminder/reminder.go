@@ -0,0 +1,228 @@
+// Package reminder notifies a webhook when todos come due, so reminders can
+// show up in chat. Each due todo is announced once with a POST like
+//
+//	{"username": "alice", "id": 3, "text": "Pay rent", "due_at": "2025-05-01T09:00:00Z"}
+//
+// Sent reminders are recorded in a state file so they aren't repeated after
+// a restart. Moving a todo's due date arms its reminder again.
+package reminder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// DefaultInterval is how often due dates are checked unless set otherwise
+const DefaultInterval = time.Minute
+
+// Payload is the JSON body posted to the webhook for a due todo
+type Payload struct {
+	Username string    `json:"username"`
+	ID       int       `json:"id"`
+	Text     string    `json:"text"`
+	DueAt    time.Time `json:"due_at"`
+}
+
+// Notifier periodically posts reminders for due todos to a webhook
+type Notifier struct {
+	url       string
+	users     *user.Store
+	todos     *todo.Store
+	statePath string
+	client    *http.Client
+
+	mu    sync.Mutex
+	fired map[string]time.Time // "username/id" -> due date the reminder was sent for
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a notifier posting to url, recording sent reminders in the
+// file at statePath
+func New(url string, users *user.Store, todos *todo.Store, statePath string) (*Notifier, error) {
+	n := &Notifier{
+		url:       url,
+		users:     users,
+		todos:     todos,
+		statePath: statePath,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	fired, err := readState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	n.fired = fired
+	return n, nil
+}
+
+// RemapIDs updates the reminders recorded in the state file at statePath
+// after the user's todos were renumbered, such as by todo.Store.CompactIDs.
+// ids gives the new ID of each todo by its old one; reminders for todos it
+// leaves out are forgotten. No notifier may be using the file meanwhile.
+func RemapIDs(statePath, username string, ids map[int]int) error {
+	fired, err := readState(statePath)
+	if err != nil {
+		return err
+	}
+
+	remapped := make(map[string]time.Time, len(fired))
+	changed := false
+	for key, due := range fired {
+		rest, ok := strings.CutPrefix(key, username+"/")
+		old, err := strconv.Atoi(rest)
+		if !ok || err != nil {
+			remapped[key] = due
+			continue
+		}
+		changed = true
+		if id, ok := ids[old]; ok {
+			remapped[fmt.Sprintf("%s/%d", username, id)] = due
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writeState(statePath, remapped)
+}
+
+// readState loads the sent reminders from the state file, which may not
+// exist yet
+func readState(path string) (map[string]time.Time, error) {
+	fired := make(map[string]time.Time)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read reminder state: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fired); err != nil {
+			return nil, fmt.Errorf("failed to parse reminder state: %v", err)
+		}
+	}
+	return fired, nil
+}
+
+// writeState writes the sent reminders to the state file
+func writeState(path string, fired map[string]time.Time) error {
+	data, err := json.MarshalIndent(fired, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal reminder state: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write reminder state: %v", err)
+	}
+	return nil
+}
+
+// Start checks for due todos every interval until Stop is called
+func (n *Notifier) Start(interval time.Duration) {
+	n.stop = make(chan struct{})
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := n.Check(time.Now()); err != nil {
+				log.Printf("Reminder check failed: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-n.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the checks started by Start and waits for the current one
+func (n *Notifier) Stop() {
+	if n.stop != nil {
+		close(n.stop)
+		n.wg.Wait()
+	}
+}
+
+// Check sends a reminder for every incomplete todo due at or before now
+// that hasn't had one yet. Failed posts are retried on the next check.
+func (n *Notifier) Check(now time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	seen := make(map[string]bool)
+	changed := false
+	for _, username := range n.users.Usernames() {
+		todos, err := n.todos.ListUnordered(username)
+		if err != nil {
+			return err
+		}
+		for _, t := range todos {
+			if t.DueAt == nil {
+				continue
+			}
+			key := fmt.Sprintf("%s/%d", username, t.ID)
+			seen[key] = true
+
+			if todo.ClassifyDue(t, now) != todo.DueOverdue {
+				continue
+			}
+			if due, ok := n.fired[key]; ok && due.Equal(*t.DueAt) {
+				continue
+			}
+			if err := n.post(Payload{Username: username, ID: t.ID, Text: t.Text, DueAt: *t.DueAt}); err != nil {
+				log.Printf("Failed to send reminder for %s: %v", key, err)
+				continue
+			}
+			n.fired[key] = *t.DueAt
+			changed = true
+		}
+	}
+
+	// Forget todos that were deleted or lost their due date
+	for key := range n.fired {
+		if !seen[key] {
+			delete(n.fired, key)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return n.save()
+}
+
+// post sends a single reminder to the webhook
+func (n *Notifier) post(payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// save writes the sent reminders to the state file.
+// We assume the caller already has the lock.
+func (n *Notifier) save() error {
+	return writeState(n.statePath, n.fired)
+}
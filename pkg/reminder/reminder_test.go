@@ -0,0 +1,179 @@
+package reminder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+)
+
+// TestCheck verifies that each due todo is announced once, even across
+// restarts, and again after its due date moves
+func TestCheck(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "todoissh-reminder-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	var mu sync.Mutex
+	var received []Payload
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, p)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	users, err := user.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+	todos, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	todos.AddMany("alice", []string{"Pay rent", "Later", "Done", "No date"})
+
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	todos.SetDueDate("alice", 1, &past)
+	todos.SetDueDate("alice", 2, &future)
+	todos.SetDueDate("alice", 3, &past)
+	todos.ToggleComplete("alice", 3)
+
+	statePath := filepath.Join(dataDir, "reminders.json")
+	n, err := New(webhook.URL, users, todos, statePath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	if err := n.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if count() != 1 || received[0].Username != "alice" || received[0].ID != 1 || received[0].Text != "Pay rent" {
+		t.Fatalf("reminders = %+v; want one for todo 1", received)
+	}
+
+	// Nothing is repeated, including by a notifier reading the saved state
+	n, err = New(webhook.URL, users, todos, statePath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := n.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if count() != 1 {
+		t.Errorf("reminders after restart = %d; want 1", count())
+	}
+
+	// Todo 2 comes due, and moving todo 1's due date arms it again
+	later := now.Add(2 * time.Hour)
+	moved := now.Add(90 * time.Minute)
+	todos.SetDueDate("alice", 1, &moved)
+	if err := n.Check(later); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if count() != 3 {
+		t.Errorf("reminders after due dates passed = %d; want 3", count())
+	}
+}
+
+// TestCheckWebhookFailure verifies that a failed post is retried on the next check
+func TestCheckWebhookFailure(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "todoissh-reminder-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataDir) })
+
+	var mu sync.Mutex
+	status, calls := http.StatusInternalServerError, 0
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer webhook.Close()
+
+	users, _ := user.NewStore(dataDir)
+	todos, _ := todo.NewStore(dataDir)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	todos.Add("alice", "Pay rent")
+	now := time.Now()
+	due := now.Add(-time.Minute)
+	todos.SetDueDate("alice", 1, &due)
+
+	n, err := New(webhook.URL, users, todos, filepath.Join(dataDir, "reminders.json"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n.Check(now)
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	n.Check(now)
+	n.Check(now)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("webhook calls = %d; want 2 (one failure, one retry)", calls)
+	}
+}
+
+// TestRemapIDs verifies that sent reminders follow renumbered todos, and
+// that other users' reminders are left alone
+func TestRemapIDs(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "reminders.json")
+	if err := RemapIDs(statePath, "alice", map[int]int{5: 1}); err != nil {
+		t.Fatalf("RemapIDs() without a state file error = %v", err)
+	}
+
+	due := time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC)
+	state := map[string]time.Time{"alice/5": due, "alice/7": due, "alice/1": due, "bob/5": due}
+	data, _ := json.Marshal(state)
+	if err := os.WriteFile(statePath, data, 0600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	// 1 was deleted; 5 and 7 become 1 and 2
+	if err := RemapIDs(statePath, "alice", map[int]int{5: 1, 7: 2}); err != nil {
+		t.Fatalf("RemapIDs() error = %v", err)
+	}
+	got, err := readState(statePath)
+	if err != nil {
+		t.Fatalf("readState() error = %v", err)
+	}
+	want := map[string]time.Time{"alice/1": due, "alice/2": due, "bob/5": due}
+	if len(got) != len(want) {
+		t.Fatalf("state after RemapIDs() = %v; want %v", got, want)
+	}
+	for key, at := range want {
+		if !got[key].Equal(at) {
+			t.Errorf("state[%s] = %v; want %v", key, got[key], at)
+		}
+	}
+}
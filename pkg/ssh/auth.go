@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+
+	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthResult is how a login attempt went. The server tells these apart
+// for logging and for counting failures, but clients are only ever told
+// that authentication failed.
+type AuthResult int
+
+const (
+	AuthAccepted      AuthResult = iota // Correct password or authorized key
+	AuthNewUser                         // Unknown user who may register
+	AuthOneTimeCode                     // Provisioned user logging in with their code
+	AuthWrongPassword                   // Existing user, wrong password
+	AuthUnknownUser                     // No such user, and they may not register
+	AuthKeyRejected                     // Public key not authorized; clients then try a password
+)
+
+// String returns a short name for the result, as used in logs
+func (r AuthResult) String() string {
+	switch r {
+	case AuthAccepted:
+		return "accepted"
+	case AuthNewUser:
+		return "new user"
+	case AuthOneTimeCode:
+		return "one-time code"
+	case AuthWrongPassword:
+		return "wrong password"
+	case AuthUnknownUser:
+		return "unknown user"
+	case AuthKeyRejected:
+		return "key rejected"
+	default:
+		return "unknown"
+	}
+}
+
+// Failed reports whether the attempt was refused
+func (r AuthResult) Failed() bool {
+	return r >= AuthWrongPassword
+}
+
+// AuthAttempt describes a login attempt, as passed to an auth observer
+type AuthAttempt struct {
+	Username   string
+	RemoteAddr net.Addr
+	Method     string // "password" or "publickey"
+	Result     AuthResult
+}
+
+// errAuthFailed is what clients are told about any refused password, so
+// they can't learn which usernames exist
+var errAuthFailed = errors.New("invalid username or password")
+
+// SetAuthObserver sets a function called with the outcome of every login
+// attempt, such as to count failures per user or address. It runs during
+// the handshake, so it should return quickly. Nil removes it.
+func (s *Server) SetAuthObserver(observer func(AuthAttempt)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authObserver = observer
+}
+
+// checkPassword authenticates a password login, returning the permissions
+// to grant if it is accepted
+func (s *Server) checkPassword(c ssh.ConnMetadata, pass []byte) (AuthResult, *ssh.Permissions) {
+	username := c.User()
+
+	// Check if user exists and password is correct
+	currentUser, authenticated := s.userStore.Authenticate(username, string(pass))
+	if authenticated {
+		return AuthAccepted, permissionsFor(SessionInfo{Username: username})
+	}
+
+	// Existing user with the wrong password
+	if currentUser == nil || !currentUser.IsNew {
+		return AuthWrongPassword, nil
+	}
+
+	// Usernames name files on disk, so refuse any that could escape the
+	// data directory before going further
+	if err := user.ValidateUsername(username); err != nil {
+		logDebug("Refusing username %q: %v", username, err)
+		return AuthUnknownUser, nil
+	}
+
+	// If user doesn't exist, we'll handle registration in the channel
+	// handler. A provisioned user logging in with their one-time code may
+	// register even where others can't.
+	if s.userStore.RedeemOneTimeCode(username, string(pass)) {
+		logInfo("User %s logged in with a one-time code", username)
+		return AuthOneTimeCode, permissionsFor(SessionInfo{Username: username, IsNew: true})
+	}
+	if err := s.userStore.CanRegister(username); err != nil {
+		// Someone who used their code but dropped off before registering
+		// may come back to finish
+		if s.userStore.ResumeInvite(username, string(pass)) {
+			logInfo("User %s resumed registering with a one-time code", username)
+			return AuthOneTimeCode, permissionsFor(SessionInfo{Username: username, IsNew: true})
+		}
+		logDebug("Refusing to register %s: %v", username, err)
+		return AuthUnknownUser, nil
+	}
+	return AuthNewUser, permissionsFor(SessionInfo{Username: username, IsNew: true})
+}
+
+// recordAuth logs a login attempt and passes it to the auth observer
+func (s *Server) recordAuth(c ssh.ConnMetadata, method string, result AuthResult) {
+	if result.Failed() {
+		logDebug("Failed %s login for %s from %s: %s", method, c.User(), c.RemoteAddr(), result)
+	}
+
+	s.mu.Lock()
+	observer := s.authObserver
+	s.mu.Unlock()
+	if observer != nil {
+		observer(AuthAttempt{
+			Username:   c.User(),
+			RemoteAddr: c.RemoteAddr(),
+			Method:     method,
+			Result:     result,
+		})
+	}
+}
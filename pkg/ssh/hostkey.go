@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadHostKey reads the host key at path, generating one if the file does
+// not exist yet. Paths that can't be used as a key file are reported with
+// a specific error so the server refuses to start.
+func loadHostKey(path string) (ssh.Signer, error) {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := createHostKey(path); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("host key unreadable: %v", err)
+	case info.IsDir():
+		return nil, fmt.Errorf("host key path %s is a directory", path)
+	}
+
+	privateBytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrPermission) {
+		return nil, fmt.Errorf("host key unreadable: permission denied")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("host key unreadable: %v", err)
+	}
+
+	private, err := ssh.ParsePrivateKey(privateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return private, nil
+}
+
+// loadHostKeyDir reads every private key in dir, in name order. Files that
+// aren't private keys, such as the matching .pub files, are skipped with a
+// warning. A later key replaces an earlier one of the same type, since
+// clients are only offered one key per type.
+func loadHostKeyDir(dir string) ([]ssh.Signer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("host key directory unreadable: %v", err)
+	}
+
+	var keys []ssh.Signer
+	byType := make(map[string]string) // key type -> file it came from
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+
+		privateBytes, err := os.ReadFile(path)
+		if err != nil {
+			logWarn("Skipping host key %s: %v", path, err)
+			continue
+		}
+		private, err := ssh.ParsePrivateKey(privateBytes)
+		if err != nil {
+			logWarn("Skipping %s: not a private key: %v", path, err)
+			continue
+		}
+
+		keyType := private.PublicKey().Type()
+		if previous, exists := byType[keyType]; exists {
+			logWarn("Host key %s replaces %s (both are %s)", path, previous, keyType)
+		}
+		byType[keyType] = path
+		keys = append(keys, private)
+		logDebug("Loaded %s host key %s", keyType, path)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found in %s", dir)
+	}
+	return keys, nil
+}
+
+// createHostKey generates a new host key at path, warning if the file did
+// not end up private to the server's user
+func createHostKey(path string) error {
+	privateKey, err := generateHostKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate host key: %v", err)
+	}
+	logInfo("Generated new host key: %s", path)
+	if err := os.WriteFile(path, privateKey, 0600); err != nil {
+		return fmt.Errorf("failed to write host key: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("host key unreadable: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		logWarn("Host key %s has mode %04o; expected 0600", path, mode)
+	}
+	return nil
+}
+
+// RegenerateHostKey replaces the host key at path with a fresh one, keeping
+// the previous key as <path>.old. Clients that saw the old key will get a
+// host key changed warning on their next connection.
+func RegenerateHostKey(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return createHostKey(path)
+	case err != nil:
+		return fmt.Errorf("host key unreadable: %v", err)
+	case info.IsDir():
+		return fmt.Errorf("host key path %s is a directory", path)
+	}
+
+	backup := path + ".old"
+	if err := os.Rename(path, backup); err != nil {
+		return fmt.Errorf("failed to back up host key: %v", err)
+	}
+	logInfo("Backed up host key to %s", backup)
+
+	if err := createHostKey(path); err != nil {
+		// Put the old key back so the server can still start with it
+		if restoreErr := os.Rename(backup, path); restoreErr != nil {
+			logError("Failed to restore host key from %s: %v", backup, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestLoadHostKey verifies that a missing key is generated with mode 0600
+// and reused afterwards
+func TestLoadHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_rsa")
+
+	first, err := loadHostKey(path)
+	if err != nil {
+		t.Fatalf("loadHostKey() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("host key not written: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("host key mode = %04o; want 0600", mode)
+	}
+
+	second, err := loadHostKey(path)
+	if err != nil {
+		t.Fatalf("loadHostKey() error = %v on reload", err)
+	}
+	if string(first.PublicKey().Marshal()) != string(second.PublicKey().Marshal()) {
+		t.Error("loadHostKey() generated a new key instead of reusing the existing one")
+	}
+}
+
+// TestLoadHostKeyErrors verifies the specific errors for unusable paths
+func TestLoadHostKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := loadHostKey(dir); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("loadHostKey(dir) error = %v; want directory error", err)
+	}
+
+	garbage := filepath.Join(dir, "garbage")
+	if err := os.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	if _, err := loadHostKey(garbage); err == nil || !strings.Contains(err.Error(), "parse") {
+		t.Errorf("loadHostKey(garbage) error = %v; want parse error", err)
+	}
+
+	unreadable := filepath.Join(dir, "unreadable")
+	if err := os.WriteFile(unreadable, []byte("key"), 0000); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	if _, err := os.ReadFile(unreadable); err == nil {
+		t.Skip("running with permissions that ignore file modes")
+	}
+	if _, err := loadHostKey(unreadable); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("loadHostKey(unreadable) error = %v; want permission error", err)
+	}
+}
+
+// TestRegenerateHostKey verifies that the old key is kept as a backup and
+// a different key takes its place
+func TestRegenerateHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_rsa")
+
+	old, err := loadHostKey(path)
+	if err != nil {
+		t.Fatalf("loadHostKey() error = %v", err)
+	}
+	if err := RegenerateHostKey(path); err != nil {
+		t.Fatalf("RegenerateHostKey() error = %v", err)
+	}
+
+	backup, err := loadHostKey(path + ".old")
+	if err != nil {
+		t.Fatalf("backup key unusable: %v", err)
+	}
+	if string(backup.PublicKey().Marshal()) != string(old.PublicKey().Marshal()) {
+		t.Error("backup does not hold the previous key")
+	}
+
+	fresh, err := loadHostKey(path)
+	if err != nil {
+		t.Fatalf("loadHostKey() error = %v after regeneration", err)
+	}
+	if string(fresh.PublicKey().Marshal()) == string(old.PublicKey().Marshal()) {
+		t.Error("RegenerateHostKey() kept the same key")
+	}
+}
+
+// TestLoadHostKeyDir verifies that every private key in a directory is
+// loaded and other files are skipped
+func TestLoadHostKeyDir(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := loadHostKeyDir(dir); err == nil {
+		t.Error("loadHostKeyDir() on an empty directory succeeded; want error")
+	}
+
+	rsaKey, err := generateHostKey()
+	if err != nil {
+		t.Fatalf("generateHostKey() error = %v", err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	edBlock, err := ssh.MarshalPrivateKey(edPrivate, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() error = %v", err)
+	}
+
+	files := map[string][]byte{
+		"ssh_host_rsa_key":     rsaKey,
+		"ssh_host_ed25519_key": pem.EncodeToMemory(edBlock),
+		"ssh_host_rsa_key.pub": []byte("ssh-rsa AAAA comment\n"),
+		"README":               []byte("keys go here\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "old"), 0700); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+
+	keys, err := loadHostKeyDir(dir)
+	if err != nil {
+		t.Fatalf("loadHostKeyDir() error = %v", err)
+	}
+	var types []string
+	for _, key := range keys {
+		types = append(types, key.PublicKey().Type())
+	}
+	if strings.Join(types, ",") != "ssh-ed25519,ssh-rsa" {
+		t.Errorf("loadHostKeyDir() key types = %v; want [ssh-ed25519 ssh-rsa]", types)
+	}
+}
@@ -0,0 +1,46 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+
+	"todoissh/pkg/config"
+)
+
+// logLevel controls which messages the server logs
+var logLevel = config.LogLevelNormal
+
+// SetLogLevel sets the verbosity of the SSH server's logging
+func SetLogLevel(level config.LogLevel) {
+	logLevel = level
+}
+
+// logDebug logs per-connection details, only in verbose or debug mode
+func logDebug(format string, v ...any) {
+	if logLevel >= config.LogLevelVerbose {
+		output("DEBUG", format, v...)
+	}
+}
+
+// logInfo logs an informational message unless quiet mode is enabled
+func logInfo(format string, v ...any) {
+	if logLevel > config.LogLevelQuiet {
+		output("INFO", format, v...)
+	}
+}
+
+// logWarn logs a recoverable problem; always emitted
+func logWarn(format string, v ...any) {
+	output("WARN", format, v...)
+}
+
+// logError logs a failure; always emitted
+func logError(format string, v ...any) {
+	output("ERROR", format, v...)
+}
+
+// output writes a message with its level prefix, attributing the
+// file and line to the caller of the level helper
+func output(level, format string, v ...any) {
+	log.Output(3, level+" "+fmt.Sprintf(format, v...))
+}
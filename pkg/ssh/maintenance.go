@@ -0,0 +1,41 @@
+package ssh
+
+import (
+	"todoissh/pkg/todo"
+)
+
+// SetTodoStore sets the todo store sessions work on, which SetReadOnly
+// switches in and out of read-only mode
+func (s *Server) SetTodoStore(todos *todo.Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.todoStore = todos
+}
+
+// SetReadOnly turns maintenance mode on or off without restarting. While
+// it is on, changes sessions make to their todos fail with
+// todo.ErrReadOnly and the list shows a maintenance banner, but todos can
+// still be read and nobody is disconnected. It has no effect until
+// SetTodoStore is called.
+func (s *Server) SetReadOnly(enabled bool) {
+	s.mu.Lock()
+	todos := s.todoStore
+	s.mu.Unlock()
+	if todos == nil {
+		return
+	}
+	todos.SetReadOnly(enabled)
+	if enabled {
+		logInfo("Maintenance mode on: todos are read-only")
+	} else {
+		logInfo("Maintenance mode off")
+	}
+}
+
+// ReadOnly reports whether maintenance mode is on
+func (s *Server) ReadOnly() bool {
+	s.mu.Lock()
+	todos := s.todoStore
+	s.mu.Unlock()
+	return todos != nil && todos.ReadOnly()
+}
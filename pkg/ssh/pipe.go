@@ -0,0 +1,109 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DialPipe connects a client to the server over an in-memory pipe instead of
+// a socket, so sessions can be driven end to end in tests. The connection is
+// served like any other until the client or the server is closed.
+func (s *Server) DialPipe(config *ssh.ClientConfig) (*ssh.Client, error) {
+	serverConn, clientConn := newPipe()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.ServeConn(serverConn)
+	}()
+
+	conn, chans, reqs, err := ssh.NewClientConn(clientConn, "pipe", config)
+	if err != nil {
+		clientConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// newPipe returns the two ends of an in-memory connection. Unlike net.Pipe,
+// writes are buffered, since both sides of an SSH handshake send their
+// version before reading the other's.
+func newPipe() (net.Conn, net.Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: a, w: b}, &pipeConn{r: b, w: a}
+}
+
+// pipeBuffer carries bytes in one direction of a pipe
+type pipeBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// read blocks until data is available or the buffer is closed
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.cond.Broadcast()
+	return b.buf.Write(p)
+}
+
+func (b *pipeBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// pipeConn is one end of a pipe created by newPipe
+type pipeConn struct {
+	r, w *pipeBuffer
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.write(p) }
+
+// Close ends both directions, so the other side sees EOF
+func (c *pipeConn) Close() error {
+	c.r.close()
+	c.w.close()
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr                { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr               { return pipeAddr{} }
+func (c *pipeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
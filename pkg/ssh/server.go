@@ -7,148 +7,270 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"log"
 	"net"
-	"os"
 	"sync"
+	"time"
 
+	"todoissh/pkg/todo"
 	"todoissh/pkg/user"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// ChannelHandler handles a session channel for an authenticated user.
+// The context is cancelled when the connection closes or the server shuts down.
+type ChannelHandler func(ctx context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request)
+
+// DefaultHandshakeTimeout is how long a client has to finish the SSH
+// handshake, including authentication, unless set otherwise
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// Networks the server can listen on; see SetNetwork
+const (
+	NetworkTCP  = "tcp"  // Whatever the platform does for ":port", often dual-stack
+	NetworkTCP4 = "tcp4" // IPv4 only
+	NetworkTCP6 = "tcp6" // IPv6 only
+	NetworkBoth = "both" // Separate IPv4 and IPv6 listeners
+)
+
 // Server represents an SSH server instance
 type Server struct {
 	config    *ssh.ServerConfig
 	port      int
-	hostKey   string
-	handler   func(string, ssh.Channel, <-chan *ssh.Request) // Updated to include username
-	listener  net.Listener
+	hostKey   string // key file, or directory of key files
+	handler   ChannelHandler
+	network   string // one of the Network constants
+	listeners []net.Listener
+	started   time.Time // when Start began listening; zero before
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
 	mu        sync.Mutex
 	conns     map[net.Conn]struct{}
 	userStore *user.Store
+	todoStore *todo.Store // switched by SetReadOnly; may be nil
+
+	maxSessions int            // per user; 0 means unlimited
+	sessions    map[string]int // active connections by username
+
+	handshakeTimeout time.Duration // 0 waits forever
+
+	authObserver func(AuthAttempt) // told of every login attempt; may be nil
 }
 
 // NewServer creates a new SSH server instance
 func NewServer(port int, hostKeyPath string, userStore *user.Store) (*Server, error) {
+	// Load the server's private key, generating it if it doesn't exist
+	private, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(port, hostKeyPath, []ssh.Signer{private}, userStore), nil
+}
+
+// NewServerFromKeyDir creates a new SSH server instance offering every
+// private key found in dir as a host key. Unlike NewServer it never
+// generates a key, so dir must contain at least one.
+func NewServerFromKeyDir(port int, dir string, userStore *user.Store) (*Server, error) {
+	keys, err := loadHostKeyDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(port, dir, keys, userStore), nil
+}
+
+// newServer creates a server offering the given host keys
+func newServer(port int, hostKey string, keys []ssh.Signer, userStore *user.Store) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server{
 		port:      port,
-		hostKey:   hostKeyPath,
+		hostKey:   hostKey,
+		network:   NetworkTCP,
 		ctx:       ctx,
 		cancel:    cancel,
 		conns:     make(map[net.Conn]struct{}),
 		userStore: userStore,
-	}
+		sessions:  make(map[string]int),
 
-	// Generate the server's private key if it doesn't exist
-	if _, err := os.Stat(hostKeyPath); os.IsNotExist(err) {
-		privateKey, err := generateHostKey()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate host key: %v", err)
-		}
-		log.Printf("Generated new host key: %s", hostKeyPath)
-		if err := os.WriteFile(hostKeyPath, privateKey, 0600); err != nil {
-			return nil, fmt.Errorf("failed to write host key: %v", err)
-		}
-	}
-
-	// Read the host key
-	privateBytes, err := os.ReadFile(hostKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read host key: %v", err)
-	}
-
-	private, err := ssh.ParsePrivateKey(privateBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %v", err)
+		handshakeTimeout: DefaultHandshakeTimeout,
 	}
 
 	config := &ssh.ServerConfig{
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			username := c.User()
-
-			// Check if user exists and password is correct
-			currentUser, authenticated := server.userStore.Authenticate(username, string(pass))
-
-			if authenticated {
-				// User exists and password is correct
-				return &ssh.Permissions{
-					Extensions: map[string]string{
-						"username": username,
-						"is_new":   "false",
-					},
-				}, nil
+			result, perms := server.checkPassword(c, pass)
+			server.recordAuth(c, "password", result)
+			if result.Failed() {
+				// The same error whatever the reason, so clients can't
+				// probe for usernames
+				return nil, errAuthFailed
 			}
+			return perms, nil
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			username := c.User()
 
-			// If user doesn't exist, we'll handle registration in the channel handler
-			// Allow connection to proceed, but mark that this is a new user
-			if currentUser != nil && currentUser.IsNew {
-				return &ssh.Permissions{
-					Extensions: map[string]string{
-						"username": username,
-						"is_new":   "true",
-					},
-				}, nil
+			// Only registered users can have authorized keys; others fall back to password auth
+			if !server.userStore.AuthenticateKey(username, key) {
+				server.recordAuth(c, "publickey", AuthKeyRejected)
+				return nil, fmt.Errorf("public key not authorized")
 			}
 
-			// Invalid password for existing user
-			return nil, fmt.Errorf("invalid username or password")
+			server.recordAuth(c, "publickey", AuthAccepted)
+			return permissionsFor(SessionInfo{Username: username}), nil
 		},
 	}
-	config.AddHostKey(private)
+	for _, key := range keys {
+		config.AddHostKey(key)
+	}
 	server.config = config
 
-	return server, nil
+	return server
 }
 
 // SetChannelHandler sets the handler for new SSH channels
+//
+// Deprecated: Use SetChannelHandlerContext, which also passes a context
+// tied to the connection lifecycle.
 func (s *Server) SetChannelHandler(handler func(string, ssh.Channel, <-chan *ssh.Request)) {
+	if handler == nil {
+		s.handler = nil
+		return
+	}
+	s.handler = func(_ context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		handler(info.Username, channel, requests)
+	}
+}
+
+// SetChannelHandlerContext sets the handler for new SSH channels
+func (s *Server) SetChannelHandlerContext(handler ChannelHandler) {
 	s.handler = handler
 }
 
-// Start starts the SSH server
-func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
-	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %v", s.port, err)
+// SetMaxSessionsPerUser limits how many connections a single user may have
+// open at once. Zero means unlimited.
+func (s *Server) SetMaxSessionsPerUser(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSessions = n
+}
+
+// SetHandshakeTimeout limits how long a client may take to complete the SSH
+// handshake and authenticate, so connections that never finish don't tie up
+// the server. Zero or less disables the limit.
+func (s *Server) SetHandshakeTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handshakeTimeout = max(d, 0)
+}
+
+// SetNetwork chooses the address families Start listens on: NetworkTCP,
+// the default, NetworkTCP4, NetworkTCP6, or NetworkBoth for one listener
+// of each.
+func (s *Server) SetNetwork(network string) error {
+	switch network {
+	case NetworkTCP, NetworkTCP4, NetworkTCP6, NetworkBoth:
+	default:
+		return fmt.Errorf("unknown network %q; use tcp, tcp4, tcp6 or both", network)
 	}
-	log.Printf("Listening on port %d...", s.port)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.network = network
+	return nil
+}
 
-	s.listener = listener
+// acquireSession registers a new session for the user, reporting false if
+// the user is already at the session limit
+func (s *Server) acquireSession(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				select {
-				case <-s.ctx.Done():
-					return
-				default:
-					log.Printf("Failed to accept connection: %v", err)
-					continue
-				}
+	if s.maxSessions > 0 && s.sessions[username] >= s.maxSessions {
+		return false
+	}
+	s.sessions[username]++
+	return true
+}
+
+// releaseSession unregisters a session acquired with acquireSession
+func (s *Server) releaseSession(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[username]--
+	if s.sessions[username] <= 0 {
+		delete(s.sessions, username)
+	}
+}
+
+// Start starts the SSH server, listening on every network chosen with
+// SetNetwork
+func (s *Server) Start() error {
+	s.mu.Lock()
+	networks := []string{s.network}
+	if s.network == NetworkBoth {
+		networks = []string{NetworkTCP4, NetworkTCP6}
+	}
+	s.mu.Unlock()
+
+	var listeners []net.Listener
+	for _, network := range networks {
+		listener, err := net.Listen(network, fmt.Sprintf(":%d", s.port))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
 			}
-			s.wg.Add(1)
-			go s.handleConnection(conn)
+			return fmt.Errorf("failed to listen on port %d (%s): %v", s.port, network, err)
 		}
-	}()
+		logInfo("Listening on %s...", listener.Addr())
+		listeners = append(listeners, listener)
+	}
 
+	s.mu.Lock()
+	s.listeners = listeners
+	s.started = time.Now()
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		s.wg.Add(1)
+		go s.accept(listener)
+	}
+	s.wg.Add(1)
+	go s.logUptime(uptimeLogInterval)
 	return nil
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
+// accept serves connections from listener until it is closed
+func (s *Server) accept(listener net.Listener) {
 	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				logWarn("Failed to accept connection: %v", err)
+				continue
+			}
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn runs an SSH connection over conn as if it had been accepted by
+// the listener, returning when it ends. Start calls it for every incoming
+// connection; tests can pass one end of an in-memory pipe instead.
+func (s *Server) ServeConn(conn net.Conn) {
 	defer conn.Close()
 
 	// Track connection
 	s.mu.Lock()
 	s.conns[conn] = struct{}{}
+	timeout := s.handshakeTimeout
 	s.mu.Unlock()
 
 	// Cleanup connection tracking on exit
@@ -158,20 +280,43 @@ func (s *Server) handleConnection(conn net.Conn) {
 		s.mu.Unlock()
 	}()
 
+	// Give up on clients that stall before the handshake is done
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
 	if err != nil {
-		log.Printf("Failed to establish SSH connection: %v", err)
+		logWarn("Failed to establish SSH connection: %v", err)
 		return
 	}
 	defer sshConn.Close()
+	conn.SetDeadline(time.Time{})
+
+	// Cancelled when the connection ends or the server shuts down
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
 
-	log.Printf("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
+	logDebug("New SSH connection from %s (%s)", sshConn.RemoteAddr(), sshConn.ClientVersion())
 
 	go ssh.DiscardRequests(reqs)
 
-	// Get the username from the connection permissions
-	username := sshConn.Permissions.Extensions["username"]
-	_ = sshConn.Permissions.Extensions["is_new"] == "true" // We'll use this in the handler
+	// Find out who the connection authenticated as
+	info, ok := sessionInfo(sshConn)
+	if !ok {
+		logError("No authentication recorded for connection from %s", sshConn.RemoteAddr())
+		return
+	}
+	username := info.Username
+
+	// Enforce the per-user session limit
+	if !s.acquireSession(username) {
+		logWarn("Rejecting session for %s: too many active sessions", username)
+		if newChannel, ok := <-chans; ok {
+			newChannel.Reject(ssh.Prohibited, "too many active sessions for this user")
+		}
+		return
+	}
+	defer s.releaseSession(username)
 
 	for newChannel := range chans {
 		if newChannel.ChannelType() != "session" {
@@ -181,13 +326,17 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			log.Printf("Failed to accept channel: %v", err)
+			logError("Failed to accept channel: %v", err)
 			continue
 		}
 
 		if s.handler != nil {
-			// Pass the username to the channel handler
-			go s.handler(username, channel, requests)
+			// Counted so Close waits for handlers to finish saving
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handler(ctx, info, channel, requests)
+			}()
 		} else {
 			channel.Close()
 		}
@@ -212,10 +361,12 @@ func generateHostKey() ([]byte, error) {
 func (s *Server) Close() error {
 	s.cancel() // Signal shutdown
 
-	// Close listener
-	if s.listener != nil {
-		s.listener.Close()
+	// Close listeners
+	s.mu.Lock()
+	for _, listener := range s.listeners {
+		listener.Close()
 	}
+	s.mu.Unlock()
 
 	// Close all active connections
 	s.mu.Lock()
@@ -0,0 +1,512 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/ui"
+	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// syncBuffer collects session output written from another goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestServer creates a server with a fresh host key and stores in a
+// temporary directory, serving each session with the terminal UI
+func newTestServer(t *testing.T) (*Server, *user.Store, *todo.Store) {
+	dataDir := t.TempDir()
+	users, err := user.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+	users.SetPasswordCost(bcrypt.MinCost)
+	todos, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+
+	server := newServer(0, "", []ssh.Signer{signer}, users)
+	server.SetChannelHandlerContext(func(ctx context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		termUI := ui.NewTerminalUI(channel, todos, users, info.Username, info.IsNew)
+		termUI.HandleChannelContext(ctx, requests)
+	})
+	t.Cleanup(func() { server.Close() })
+	return server, users, todos
+}
+
+// waitFor polls the output until it contains want or the deadline passes
+func waitFor(t *testing.T, out *syncBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output never contained %q:\n%s", want, out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSessionOverPipe verifies that a password login reaches the todo list
+// and that keystrokes sent over the session drive the UI
+func TestSessionOverPipe(t *testing.T) {
+	server, users, todos := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	todos.Add("alice", "Buy milk")
+
+	client, err := server.DialPipe(&ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("DialPipe() error = %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe() error = %v", err)
+	}
+	out := &syncBuffer{}
+	session.Stdout = out
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty() error = %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell() error = %v", err)
+	}
+
+	waitFor(t, out, "Buy milk")
+
+	// Add a todo, then leave with Ctrl+C
+	stdin.Write([]byte("\tBread\r"))
+	waitFor(t, out, "Bread")
+	stdin.Write([]byte{3})
+	var exitErr *ssh.ExitError
+	if err := session.Wait(); !errors.As(err, &exitErr) || exitErr.Signal() != "INT" {
+		t.Errorf("Wait() error = %v; want an exit by SIGINT", err)
+	}
+
+	list, err := todos.List("alice")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list) != 2 || list[1].Text != "Bread" {
+		t.Errorf("todos after session = %+v; want Buy milk and Bread", list)
+	}
+}
+
+// TestWrongPasswordOverPipe verifies that a bad password is refused
+func TestWrongPasswordOverPipe(t *testing.T) {
+	server, users, _ := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	_, err := server.DialPipe(&ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		t.Fatal("DialPipe() with a wrong password succeeded")
+	}
+}
+
+// TestSessionInfoOverPipe verifies that the handler learns whether the user
+// still has to register
+func TestSessionInfoOverPipe(t *testing.T) {
+	server, users, _ := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	infos := make(chan SessionInfo, 1)
+	server.SetChannelHandlerContext(func(ctx context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		infos <- info
+		channel.Close()
+	})
+
+	for _, want := range []SessionInfo{{Username: "alice"}, {Username: "bob", IsNew: true}} {
+		client, err := server.DialPipe(&ssh.ClientConfig{
+			User:            want.Username,
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatalf("DialPipe() error = %v", err)
+		}
+		if session, err := client.NewSession(); err == nil {
+			session.Close()
+		}
+		if got := <-infos; got != want {
+			t.Errorf("SessionInfo = %+v; want %+v", got, want)
+		}
+		client.Close()
+	}
+}
+
+// TestInviteOnlyOverPipe verifies that unknown users can't log in to start
+// registering on an invite-only server, while existing users still can
+func TestInviteOnlyOverPipe(t *testing.T) {
+	server, users, _ := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	users.SetInviteOnly(true)
+
+	dial := func(username string) error {
+		client, err := server.DialPipe(&ssh.ClientConfig{
+			User:            username,
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	if err := dial("bob"); err == nil {
+		t.Error("DialPipe() as an unknown user succeeded on an invite-only server")
+	}
+	if err := dial("alice"); err != nil {
+		t.Errorf("DialPipe() as a registered user error = %v", err)
+	}
+
+	// An invited user gets in with their code as a new user, and again if
+	// they drop off before choosing a password
+	code, err := users.CreateOneTimeCode("carol")
+	if err != nil {
+		t.Fatalf("CreateOneTimeCode() error = %v", err)
+	}
+	infos := make(chan SessionInfo, 1)
+	server.SetChannelHandlerContext(func(_ context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		infos <- info
+		channel.Close()
+	})
+	login := func(password string, want bool) {
+		t.Helper()
+		client, err := server.DialPipe(&ssh.ClientConfig{
+			User:            "carol",
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if (err == nil) != want {
+			t.Fatalf("DialPipe() as carol with %q error = %v; want success %v", password, err, want)
+		}
+		if err != nil {
+			return
+		}
+		if session, err := client.NewSession(); err == nil {
+			session.Close()
+		}
+		if info := <-infos; info.Username != "carol" || !info.IsNew {
+			t.Errorf("SessionInfo = %+v; want new user carol", info)
+		}
+		client.Close()
+	}
+	login(code, true)
+	login(code, true)
+	login("password", false)
+
+	// Once they chose a password, that resumes the registration instead
+	if err := users.StartRegistration("carol", "password"); err != nil {
+		t.Fatalf("StartRegistration() error = %v", err)
+	}
+	login(code, false)
+	login("wrong", false)
+	login("password", true)
+	if err := users.ConfirmRegistration("carol", "password"); err != nil {
+		t.Fatalf("ConfirmRegistration() error = %v", err)
+	}
+	if err := dial("carol"); err != nil {
+		t.Errorf("DialPipe() after registering error = %v", err)
+	}
+}
+
+// TestAuthObserver verifies that the server tells failed logins apart
+// internally while clients get the same error either way
+func TestAuthObserver(t *testing.T) {
+	server, users, _ := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	users.SetInviteOnly(true)
+
+	var mu sync.Mutex
+	var attempts []AuthAttempt
+	server.SetAuthObserver(func(attempt AuthAttempt) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, attempt)
+	})
+
+	dial := func(username, password string) error {
+		client, err := server.DialPipe(&ssh.ClientConfig{
+			User:            username,
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	wrongPassword := dial("alice", "wrong")
+	unknownUser := dial("bob", "password")
+	if wrongPassword == nil || unknownUser == nil {
+		t.Fatalf("DialPipe() errors = %v, %v; want both refused", wrongPassword, unknownUser)
+	}
+	if wrongPassword.Error() != unknownUser.Error() {
+		t.Errorf("client errors differ: %q for a wrong password, %q for an unknown user", wrongPassword, unknownUser)
+	}
+	if err := dial("alice", "password"); err != nil {
+		t.Fatalf("DialPipe() with the right password error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []AuthAttempt{
+		{Username: "alice", Method: "password", Result: AuthWrongPassword},
+		{Username: "bob", Method: "password", Result: AuthUnknownUser},
+		{Username: "alice", Method: "password", Result: AuthAccepted},
+	}
+	if len(attempts) != len(want) {
+		t.Fatalf("observed %d attempts; want %d: %+v", len(attempts), len(want), attempts)
+	}
+	for i, attempt := range attempts {
+		if attempt.Username != want[i].Username || attempt.Method != want[i].Method || attempt.Result != want[i].Result {
+			t.Errorf("attempt %d = %s %s %s; want %s %s %s", i+1,
+				attempt.Username, attempt.Method, attempt.Result,
+				want[i].Username, want[i].Method, want[i].Result)
+		}
+		if attempt.RemoteAddr == nil {
+			t.Errorf("attempt %d has no remote address", i+1)
+		}
+	}
+}
+
+// TestInvalidUsername verifies that usernames which could name a file
+// outside the data directory can't log in to register
+func TestInvalidUsername(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var mu sync.Mutex
+	var results []AuthResult
+	server.SetAuthObserver(func(attempt AuthAttempt) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, attempt.Result)
+	})
+
+	for _, name := range []string{"../users", ".hidden", "a/b"} {
+		client, err := server.DialPipe(&ssh.ClientConfig{
+			User:            name,
+			Auth:            []ssh.AuthMethod{ssh.Password("password")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err == nil {
+			client.Close()
+			t.Errorf("DialPipe() as %q succeeded; want refused", name)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 3 {
+		t.Fatalf("observed %d attempts; want 3: %v", len(results), results)
+	}
+	for i, result := range results {
+		if result != AuthUnknownUser {
+			t.Errorf("attempt %d result = %s; want %s", i+1, result, AuthUnknownUser)
+		}
+	}
+}
+
+// TestHandshakeTimeout verifies that a client which never sends anything is
+// dropped once the handshake timeout passes
+func TestHandshakeTimeout(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	server.SetHandshakeTimeout(50 * time.Millisecond)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(serverConn)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn() still waiting on a silent client after the handshake timeout")
+	}
+}
+
+// TestSetNetwork verifies listening on chosen address families, with one
+// listener per family for "both", all of which Close shuts
+func TestSetNetwork(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	if err := server.SetNetwork("udp"); err == nil {
+		t.Error("SetNetwork(udp) succeeded; want error")
+	}
+
+	want := 1
+	if err := server.SetNetwork(NetworkTCP4); err != nil {
+		t.Fatalf("SetNetwork(tcp4) error = %v", err)
+	}
+	if probe, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		probe.Close()
+		want = 2
+		server.SetNetwork(NetworkBoth)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if len(server.listeners) != want {
+		t.Fatalf("%d listeners; want %d", len(server.listeners), want)
+	}
+	if addr := server.listeners[0].Addr().(*net.TCPAddr); addr.IP.To4() == nil {
+		t.Errorf("first listener on %v; want IPv4", addr)
+	}
+	if want == 2 {
+		if addr := server.listeners[1].Addr().(*net.TCPAddr); addr.IP.To4() != nil {
+			t.Errorf("second listener on %v; want IPv6", addr)
+		}
+	}
+
+	server.Close()
+	for _, listener := range server.listeners {
+		if conn, err := net.Dial(listener.Addr().Network(), listener.Addr().String()); err == nil {
+			conn.Close()
+			t.Errorf("%v still accepts connections after Close()", listener.Addr())
+		}
+	}
+}
+
+// TestUptime verifies that uptime counts from Start, and that the periodic
+// uptime log stops with the server
+func TestUptime(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	if up := server.Uptime(); up != 0 {
+		t.Errorf("Uptime() before Start() = %v; want 0", up)
+	}
+
+	uptimeLogInterval = time.Millisecond
+	defer func() { uptimeLogInterval = time.Hour }()
+	server.SetNetwork(NetworkTCP4)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if up := server.Uptime(); up < 5*time.Millisecond || up > time.Minute {
+		t.Errorf("Uptime() = %v; want the time since Start()", up)
+	}
+	server.Close()
+}
+
+// TestCloseWaitsForHandlers verifies that Close returns only once channel
+// handlers have finished, so nothing is saved after the server is closed
+func TestCloseWaitsForHandlers(t *testing.T) {
+	server, users, _ := newTestServer(t)
+	if err := users.Register("alice", "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	started, finished := make(chan struct{}), make(chan struct{})
+	server.SetChannelHandlerContext(func(ctx context.Context, info SessionInfo, channel ssh.Channel, requests <-chan *ssh.Request) {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond) // Saving on the way out
+		close(finished)
+	})
+
+	client, err := server.DialPipe(&ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password("password")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("DialPipe() error = %v", err)
+	}
+	defer client.Close()
+	if _, err := client.NewSession(); err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	<-started
+
+	server.Close()
+	select {
+	case <-finished:
+	default:
+		t.Error("Close() returned before the channel handler finished")
+	}
+}
+
+// TestSetReadOnly verifies that maintenance mode refuses changes from
+// sessions until it is turned off again
+func TestSetReadOnly(t *testing.T) {
+	server, _, todos := newTestServer(t)
+	server.SetReadOnly(true)
+	if server.ReadOnly() {
+		t.Error("ReadOnly() before SetTodoStore() = true; want false")
+	}
+
+	server.SetTodoStore(todos)
+	server.SetReadOnly(true)
+	if !server.ReadOnly() {
+		t.Error("ReadOnly() = false; want true")
+	}
+	if _, err := todos.Add("alice", "During maintenance"); err != todo.ErrReadOnly {
+		t.Errorf("Add() in maintenance mode error = %v; want ErrReadOnly", err)
+	}
+
+	server.SetReadOnly(false)
+	if _, err := todos.Add("alice", "After maintenance"); err != nil {
+		t.Errorf("Add() after maintenance mode error = %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package ssh
+
+import (
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes who a connection was authenticated as
+type SessionInfo struct {
+	Username string
+	IsNew    bool // No account yet; the handler should register one
+}
+
+// Permission extensions carrying the SessionInfo of a connection from
+// authentication to serving it
+const (
+	extUsername = "todoissh-username"
+	extNewUser  = "todoissh-new-user"
+)
+
+// permissionsFor returns the permissions to grant a successful
+// authentication, recording what the connection authenticated as. They
+// travel with the connection, so nothing is kept if the handshake fails.
+func permissionsFor(info SessionInfo) *ssh.Permissions {
+	extensions := map[string]string{extUsername: info.Username}
+	if info.IsNew {
+		extensions[extNewUser] = "true"
+	}
+	return &ssh.Permissions{Extensions: extensions}
+}
+
+// sessionInfo returns what the connection authenticated as
+func sessionInfo(conn *ssh.ServerConn) (SessionInfo, bool) {
+	if conn.Permissions == nil {
+		return SessionInfo{}, false
+	}
+	username, ok := conn.Permissions.Extensions[extUsername]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	_, isNew := conn.Permissions.Extensions[extNewUser]
+	return SessionInfo{Username: username, IsNew: isNew}, true
+}
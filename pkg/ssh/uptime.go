@@ -0,0 +1,36 @@
+package ssh
+
+import "time"
+
+// uptimeLogInterval is how often a running server logs its uptime, shown
+// in verbose and debug mode
+var uptimeLogInterval = time.Hour
+
+// Uptime returns how long the server has been running, or zero before Start
+func (s *Server) Uptime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started.IsZero() {
+		return 0
+	}
+	return time.Since(s.started)
+}
+
+// logUptime logs the uptime and number of open connections every interval
+// until the server shuts down
+func (s *Server) logUptime(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			conns := len(s.conns)
+			s.mu.Unlock()
+			logDebug("Up for %v with %d open connections", s.Uptime().Round(time.Second), conns)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package todo
+
+import "time"
+
+// ActivityAction names what a user did to a todo
+type ActivityAction string
+
+const (
+	ActivityCreated   ActivityAction = "created"
+	ActivityCompleted ActivityAction = "completed"
+	ActivityReopened  ActivityAction = "reopened"
+	ActivityDeleted   ActivityAction = "deleted"
+)
+
+// ActivityEntry records one action on a todo, with the todo's text at the
+// time so deleted todos can still be recognized
+type ActivityEntry struct {
+	Action ActivityAction `json:"action"`
+	ID     int            `json:"id"` // 0 if the todo was deleted before CompactIDs renumbered the rest
+	Text   string         `json:"text"`
+	At     time.Time      `json:"at"`
+}
+
+// activityLimit is how many entries are kept per user; older ones are
+// dropped as new ones come in
+const activityLimit = 100
+
+// RecentActivity returns up to n of the specified user's latest actions,
+// newest first. Activity is only kept in memory, from the time the server
+// started, and for no more than the last 100 actions.
+func (s *Store) RecentActivity(username string, n int) ([]ActivityEntry, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	log := s.activity[username]
+	n = min(max(n, 0), len(log))
+	entries := make([]ActivityEntry, n)
+	for i := range entries {
+		entries[i] = log[len(log)-1-i]
+	}
+	return entries, nil
+}
+
+// recordActivity adds an action on todo to the user's activity, dropping
+// the oldest entry once the limit is reached.
+// We assume the caller already has the lock.
+func (s *Store) recordActivity(username string, action ActivityAction, todo *Todo) {
+	log := s.activity[username]
+	if len(log) >= activityLimit {
+		log = append(log[:0], log[len(log)-activityLimit+1:]...)
+	}
+	s.activity[username] = append(log, ActivityEntry{
+		Action: action,
+		ID:     todo.ID,
+		Text:   todo.Text,
+		At:     s.timestamp(),
+	})
+}
+
+// toggleAction is the activity recorded for a todo whose completed status
+// just changed
+func toggleAction(todo *Todo) ActivityAction {
+	if todo.Completed {
+		return ActivityCompleted
+	}
+	return ActivityReopened
+}
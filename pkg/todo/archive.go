@@ -0,0 +1,97 @@
+package todo
+
+import (
+	"sort"
+	"time"
+)
+
+// SetAutoArchiveAfter sets how long todos stay in the list once completed
+// before AutoArchive moves them to the archive. Zero or less disables it.
+func (s *Store) SetAutoArchiveAfter(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.archiveAge = max(d, 0)
+}
+
+// AutoArchiveAfter returns the period set by SetAutoArchiveAfter
+func (s *Store) AutoArchiveAfter() time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+	return s.archiveAge
+}
+
+// completedAt returns when a completed todo was completed. Todos completed
+// before completion times were recorded fall back to their last update.
+func completedAt(todo *Todo) time.Time {
+	if todo.CompletedAt != nil {
+		return *todo.CompletedAt
+	}
+	return todo.UpdatedAt
+}
+
+// AutoArchive moves the user's todos that were completed longer ago than
+// the auto-archive period into their archive, returning how many moved.
+// It does nothing while auto-archiving is disabled.
+func (s *Store) AutoArchive(username string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.archiveAge <= 0 {
+		return 0, nil
+	}
+	cutoff := s.now().Add(-s.archiveAge)
+	var moved []*Todo
+	for _, todo := range userTodos.Todos {
+		if todo.Completed && completedAt(todo).Before(cutoff) {
+			moved = append(moved, todo)
+		}
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+	sort.Slice(moved, func(i, j int) bool {
+		return moved[i].ID < moved[j].ID
+	})
+
+	prevArchived := userTodos.Archived
+	for _, todo := range moved {
+		delete(userTodos.Todos, todo.ID)
+	}
+	userTodos.Archived = append(append([]*Todo(nil), prevArchived...), moved...)
+
+	// Save to disk, putting the todos back if that fails
+	if err := s.saveTodos(username); err != nil {
+		for _, todo := range moved {
+			userTodos.Todos[todo.ID] = todo
+		}
+		userTodos.Archived = prevArchived
+		return 0, err
+	}
+
+	for _, todo := range moved {
+		s.publish(EventDeleted, username, todo.ID)
+	}
+	return len(moved), nil
+}
+
+// ListArchived returns the specified user's archived todos, oldest first
+func (s *Store) ListArchived(username string) ([]*Todo, error) {
+	userTodos, err := s.getUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	todos := make([]*Todo, len(userTodos.Archived))
+	for i, todo := range userTodos.Archived {
+		todos[i] = todo.clone()
+	}
+	return todos, nil
+}
@@ -0,0 +1,85 @@
+package todo
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backup copies every user's todos file into the same subdirectory of dir,
+// keeping the current layout. Writes are blocked while copying, so the
+// copy is consistent.
+func (s *Store) Backup(dir string) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	todosDir := s.todosDir()
+	return filepath.WalkDir(todosDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dataDir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read todos file: %v", err)
+		}
+
+		target := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return fmt.Errorf("failed to create backup directory: %v", err)
+		}
+		if err := os.WriteFile(target, data, 0600); err != nil {
+			return fmt.Errorf("failed to write backup: %v", err)
+		}
+		return nil
+	})
+}
+
+// SetDeletionArchiveDir makes DeleteUser keep a copy of a user's todos
+// file in a subdirectory of dir named after the user, so accidental
+// deletions can be undone by moving it back. Copies are never removed
+// automatically. Empty, the default, keeps nothing.
+func (s *Store) SetDeletionArchiveDir(dir string) {
+	s.Lock()
+	defer s.Unlock()
+	s.deletionDir = dir
+}
+
+// keepDeleted copies the user's todos file into the deletion archive
+// directory, if one is set and the user has a file.
+// We assume the caller already has the lock.
+func (s *Store) keepDeleted(username string) error {
+	if s.deletionDir == "" {
+		return nil
+	}
+	path, err := s.todosPath(username)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read todos file: %v", err)
+	}
+
+	dir := filepath.Join(s.deletionDir, username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create deletion archive directory: %v", err)
+	}
+	name := "todos-" + s.now().Format("20060102-150405") + ".json"
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		return fmt.Errorf("failed to keep deleted todos: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrDependencyCycle is returned by SetBlockedBy when the blockers would
+// make a todo wait on itself, directly or through other todos
+var ErrDependencyCycle = errors.New("todos would block each other")
+
+// ErrBlocked is returned when completing a todo whose blockers aren't done
+// while blockers are enforced; see SetEnforceBlockers
+var ErrBlocked = errors.New("todo is blocked by incomplete todos")
+
+// SetEnforceBlockers sets whether todos can only be completed once all of
+// their blockers are
+func (s *Store) SetEnforceBlockers(enforce bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.enforceBlockers = enforce
+}
+
+// SetBlockedBy replaces the todos the todo with the specified ID waits on.
+// Every blocker must exist, and none may be the todo itself or wait on it.
+// An empty list clears the blockers.
+func (s *Store) SetBlockedBy(username string, id int, blockers []int) error {
+	if id < 1 {
+		return ErrInvalidID
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return err
+	}
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return fmt.Errorf("todo with ID %d not found", id)
+	}
+
+	var cleaned []int
+	seen := make(map[int]bool, len(blockers))
+	for _, blocker := range blockers {
+		if seen[blocker] {
+			continue
+		}
+		seen[blocker] = true
+		if blocker < 1 {
+			return ErrInvalidID
+		}
+		if _, ok := userTodos.Todos[blocker]; !ok {
+			return fmt.Errorf("todo with ID %d not found", blocker)
+		}
+		if blocker == id || waitsOn(userTodos, blocker, id) {
+			return ErrDependencyCycle
+		}
+		cleaned = append(cleaned, blocker)
+	}
+	sort.Ints(cleaned)
+
+	prev := *todo
+	todo.BlockedBy = cleaned
+	todo.UpdatedAt = s.timestamp()
+
+	// Save to disk, undoing the change if that fails
+	if err := s.saveTodos(username); err != nil {
+		*todo = prev
+		return err
+	}
+
+	s.publish(EventUpdated, username, id)
+	return nil
+}
+
+// waitsOn reports whether the todo with ID from is blocked by the todo with
+// ID target, directly or through its blockers.
+// We assume the caller already has the lock.
+func waitsOn(userTodos *UserTodos, from, target int) bool {
+	visited := make(map[int]bool)
+	queue := []int{from}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		todo, ok := userTodos.Todos[id]
+		if !ok {
+			continue
+		}
+		for _, blocker := range todo.BlockedBy {
+			if blocker == target {
+				return true
+			}
+			queue = append(queue, blocker)
+		}
+	}
+	return false
+}
+
+// blocked reports whether any blocker of the todo is still in the list and
+// incomplete. Deleted and archived blockers no longer hold it up.
+// We assume the caller already has the lock.
+func blocked(userTodos *UserTodos, todo *Todo) bool {
+	for _, id := range todo.BlockedBy {
+		if blocker, ok := userTodos.Todos[id]; ok && !blocker.Completed {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBlocked reports whether the todo with the specified ID for the
+// specified user is waiting on an incomplete todo
+func (s *Store) IsBlocked(username string, id int) (bool, error) {
+	if id < 1 {
+		return false, ErrInvalidID
+	}
+	userTodos, err := s.getUserTodos(username)
+	if err != nil {
+		return false, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return false, fmt.Errorf("todo with ID %d not found", id)
+	}
+	return blocked(userTodos, todo), nil
+}
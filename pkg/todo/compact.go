@@ -0,0 +1,87 @@
+package todo
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrIDsReferenced is returned by CompactIDs when todo IDs may be held
+// outside the user's list, so renumbering would make them point elsewhere
+var ErrIDsReferenced = errors.New("todo IDs are referenced elsewhere")
+
+// CompactIDs renumbers the specified user's todos to 1..N, keeping their
+// relative order, and continues numbering new todos at N+1. Blockers and
+// recent activity are renumbered to match and the display order is kept.
+// It returns the new ID of each todo by its old one, so the caller can
+// update IDs kept outside the store, such as a remembered selection or
+// sent reminders.
+//
+// IDs change, so anything else remembering one goes stale: exports, open
+// sessions and scripts. Run it offline, with the server stopped. It fails
+// with ErrIDsReferenced while anyone is subscribed to changes, or when the
+// user has archived todos, whose IDs would be reused.
+func (s *Store) CompactIDs(username string) (map[int]int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.subs.mu.Lock()
+	subscribed := len(s.subs.chans) > 0
+	s.subs.mu.Unlock()
+	if subscribed || len(userTodos.Archived) > 0 {
+		return nil, ErrIDsReferenced
+	}
+
+	todos := make([]*Todo, 0, len(userTodos.Todos))
+	for _, todo := range userTodos.Todos {
+		todos = append(todos, todo)
+	}
+	SortByPosition(todos)
+	positions := make(map[int]int, len(todos))
+	for i, todo := range todos {
+		positions[todo.ID] = i + 1
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].ID < todos[j].ID
+	})
+	renumbered := make(map[int]int, len(todos))
+	for i, todo := range todos {
+		renumbered[todo.ID] = i + 1
+	}
+
+	prevTodos, prevNextID := userTodos.Todos, userTodos.NextID
+	compacted := make(map[int]*Todo, len(todos))
+	for _, todo := range todos {
+		c := todo.clone()
+		c.ID = renumbered[todo.ID]
+		c.Position = positions[todo.ID]
+		c.BlockedBy = nil
+		for _, blocker := range todo.BlockedBy {
+			// Drop blockers that were deleted, so they can't come to
+			// mean other todos
+			if id, ok := renumbered[blocker]; ok {
+				c.BlockedBy = append(c.BlockedBy, id)
+			}
+		}
+		sort.Ints(c.BlockedBy)
+		compacted[c.ID] = c
+	}
+	userTodos.Todos = compacted
+	userTodos.NextID = len(compacted) + 1
+
+	// Save to disk, undoing the renumbering if that fails
+	if err := s.saveTodos(username); err != nil {
+		userTodos.Todos, userTodos.NextID = prevTodos, prevNextID
+		return nil, err
+	}
+
+	// Entries for deleted todos lose their ID, which may now be another's
+	for i, entry := range s.activity[username] {
+		s.activity[username][i].ID = renumbered[entry.ID]
+	}
+	return renumbered, nil
+}
@@ -0,0 +1,120 @@
+package todo
+
+import (
+	"sort"
+	"strings"
+)
+
+// duplicateKey normalizes todo text for duplicate detection
+func duplicateKey(text string) string {
+	return strings.ToLower(strings.TrimSpace(text))
+}
+
+// duplicateGroups groups todos with the same normalized text, oldest first.
+// Groups are ordered by their oldest todo. We assume the caller already
+// has the lock.
+func duplicateGroups(userTodos *UserTodos) [][]*Todo {
+	byKey := make(map[string][]*Todo)
+	for _, todo := range userTodos.Todos {
+		key := duplicateKey(todo.Text)
+		byKey[key] = append(byKey[key], todo)
+	}
+
+	var groups [][]*Todo
+	for _, group := range byKey {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if !group[i].CreatedAt.Equal(group[j].CreatedAt) {
+				return group[i].CreatedAt.Before(group[j].CreatedAt)
+			}
+			return group[i].ID < group[j].ID
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i][0], groups[j][0]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return groups
+}
+
+// FindDuplicates returns the IDs of todos whose text is identical once
+// trimmed and lowercased. Each group lists the oldest todo first.
+func (s *Store) FindDuplicates(username string) ([][]int, error) {
+	userTodos, err := s.getUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	var result [][]int
+	for _, group := range duplicateGroups(userTodos) {
+		ids := make([]int, len(group))
+		for i, todo := range group {
+			ids[i] = todo.ID
+		}
+		result = append(result, ids)
+	}
+	return result, nil
+}
+
+// MergeDuplicates keeps the oldest todo of each duplicate group and deletes
+// the rest, returning how many were removed. The kept todo is marked
+// completed if any copy was.
+func (s *Store) MergeDuplicates(username string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := make(map[*Todo]Todo)
+	var removed []*Todo
+	now := s.timestamp()
+	for _, group := range duplicateGroups(userTodos) {
+		keep := group[0]
+		kept[keep] = *keep
+		for _, dup := range group[1:] {
+			if dup.Completed && !keep.Completed {
+				keep.Completed = true
+				keep.UpdatedAt = now
+				keep.CompletedAt = dup.CompletedAt
+			}
+			delete(userTodos.Todos, dup.ID)
+			removed = append(removed, dup)
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	// Save to disk, restoring every todo if that fails
+	if err := s.saveTodos(username); err != nil {
+		for todo, state := range kept {
+			*todo = state
+		}
+		for _, todo := range removed {
+			userTodos.Todos[todo.ID] = todo
+		}
+		return 0, err
+	}
+
+	for todo, state := range kept {
+		if todo.Completed != state.Completed {
+			s.publish(EventToggled, username, todo.ID)
+		}
+	}
+	for _, todo := range removed {
+		s.publish(EventDeleted, username, todo.ID)
+	}
+	return len(removed), nil
+}
@@ -0,0 +1,73 @@
+package todo
+
+import "sync"
+
+// EventType names the kind of change an Event reports
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated" // Text, due date, tags, order and other details
+	EventToggled EventType = "toggled" // Completed or reopened
+	EventDeleted EventType = "deleted" // Deleted, merged away or archived
+)
+
+// Event describes a change to one todo, sent to subscribers once it is saved
+type Event struct {
+	Type     EventType `json:"type"`
+	Username string    `json:"username"`
+	ID       int       `json:"id"`
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind by
+// before further events are dropped for it
+const subscriberBuffer = 64
+
+// subscribers fans saved changes out to the channels handed out by Subscribe
+type subscribers struct {
+	mu    sync.Mutex
+	chans map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving an Event for every saved change to
+// any user's todos, and a function that ends the subscription and closes
+// the channel. Delivery never blocks the store: when a subscriber falls
+// more than a small buffer behind, the events it can't take are dropped.
+// Removing a user's todos with DeleteUser or ArchiveUser sends no events.
+func (s *Store) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	s.subs.mu.Lock()
+	if s.subs.chans == nil {
+		s.subs.chans = make(map[chan Event]struct{})
+	}
+	s.subs.chans[ch] = struct{}{}
+	s.subs.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.subs.mu.Lock()
+			defer s.subs.mu.Unlock()
+			delete(s.subs.chans, ch)
+			close(ch)
+		})
+	}
+}
+
+// publish sends events of one type for the user's todos with the given IDs
+// to every subscriber, dropping them for subscribers whose buffer is full
+func (s *Store) publish(eventType EventType, username string, ids ...int) {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+
+	for _, id := range ids {
+		event := Event{Type: eventType, Username: username, ID: id}
+		for ch := range s.subs.chans {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package todo
+
+import "time"
+
+// TodoStore is the set of todo operations front ends such as the terminal
+// UI rely on. *Store implements it; tests can substitute their own.
+type TodoStore interface {
+	Add(username, text string) (*Todo, error)
+	Get(username string, id int) (*Todo, error)
+	List(username string) ([]*Todo, error)
+	ListToday(username string) ([]*Todo, error)
+	ListByStatus(username string, completed bool) ([]*Todo, error)
+	ListSnoozed(username string) ([]*Todo, error)
+	SearchAll(username, query string, fields SearchField) ([]*Todo, error)
+	Update(username string, id int, text string) (*Todo, error)
+	Patch(username string, id int, patch TodoPatch) (*Todo, error)
+	ToggleComplete(username string, id int) (*Todo, error)
+	IsBlocked(username string, id int) (bool, error)
+	SetBlockedBy(username string, id int, blockers []int) error
+	Snooze(username string, id int, until time.Time) error
+	Move(username string, id int, offset int) error
+	Delete(username string, id int) (*Todo, error)
+	Stats(username string) (Stats, error)
+	RecentActivity(username string, n int) ([]ActivityEntry, error)
+	Invalidate(username string)
+
+	// Location is the time zone todos are shown in
+	Location() *time.Location
+	// ReadOnly reports whether changes are currently refused with ErrReadOnly
+	ReadOnly() bool
+	// PendingWrites is how many users have changes FlushAll would save
+	PendingWrites() int
+	FlushAll() error
+}
+
+var _ TodoStore = (*Store)(nil)
@@ -0,0 +1,59 @@
+package todo
+
+import "strings"
+
+// ImportMarkdown loads todos from the checklist items of a Markdown
+// document, such as "- [ ] Buy milk" or "* [x] Call mom", in the order they
+// appear. Indentation is ignored and other lines are skipped. merge works
+// as for ImportJSON; imported todos always get new IDs.
+func (s *Store) ImportMarkdown(username string, md string, merge bool) error {
+	s.RLock()
+	now := s.timestamp()
+	s.RUnlock()
+
+	var imported []*Todo
+	for _, line := range strings.Split(md, "\n") {
+		text, completed, ok := parseChecklistItem(line)
+		if !ok {
+			continue
+		}
+		todo := &Todo{
+			Text:      text,
+			Completed: completed,
+			CreatedAt: now,
+			UpdatedAt: now,
+
+			TextUpdatedAt: now,
+		}
+		if completed {
+			completedAt := now
+			todo.CompletedAt = &completedAt
+		}
+		imported = append(imported, todo)
+	}
+	return s.importTodos(username, imported, merge)
+}
+
+// parseChecklistItem returns the text and checkbox state of a Markdown
+// checklist item, with ok false for any other line or an item without text
+func parseChecklistItem(line string) (text string, completed bool, ok bool) {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || !strings.ContainsRune("-*+", rune(line[0])) || line[1] != ' ' {
+		return "", false, false
+	}
+	line = strings.TrimLeft(line[1:], " \t")
+
+	switch {
+	case strings.HasPrefix(line, "[ ]"):
+	case strings.HasPrefix(line, "[x]"), strings.HasPrefix(line, "[X]"):
+		completed = true
+	default:
+		return "", false, false
+	}
+	rest := line[3:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false, false
+	}
+	text = strings.TrimSpace(rest)
+	return text, completed, text != ""
+}
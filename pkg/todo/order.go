@@ -0,0 +1,152 @@
+package todo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// displayPosition returns the todo's position in the display order.
+// Todos saved before positions existed fall back to their ID.
+func displayPosition(todo *Todo) int {
+	if todo.Position > 0 {
+		return todo.Position
+	}
+	return todo.ID
+}
+
+// SortByPosition sorts todos in display order, breaking ties by ID
+func SortByPosition(todos []*Todo) {
+	sort.SliceStable(todos, func(i, j int) bool {
+		pi, pj := displayPosition(todos[i]), displayPosition(todos[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return todos[i].ID < todos[j].ID
+	})
+}
+
+// nextPosition returns the position that places a todo after all others.
+// We assume the caller already has the lock.
+func nextPosition(userTodos *UserTodos) int {
+	next := 1
+	for _, todo := range userTodos.Todos {
+		next = max(next, displayPosition(todo)+1)
+	}
+	return next
+}
+
+// AddMany adds several todos for the specified user in the given order with
+// a single save. The whole batch counts as one add against the rate limit.
+func (s *Store) AddMany(username string, texts []string) ([]*Todo, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.timestamp()
+	if !s.allowAdd(username, now) {
+		return nil, ErrRateLimited
+	}
+
+	position := nextPosition(userTodos)
+	added := make([]*Todo, 0, len(texts))
+	for _, text := range texts {
+		todo := &Todo{
+			ID:        userTodos.NextID,
+			Text:      text,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Position:  position,
+
+			TextUpdatedAt: now,
+		}
+		userTodos.Todos[todo.ID] = todo
+		userTodos.NextID++
+		position++
+		added = append(added, todo)
+	}
+	userTodos.CreatedCount += len(added)
+
+	// Save to disk, undoing the adds if that fails
+	if err := s.saveTodos(username); err != nil {
+		for _, todo := range added {
+			delete(userTodos.Todos, todo.ID)
+		}
+		userTodos.NextID -= len(added)
+		userTodos.CreatedCount -= len(added)
+		s.refundAdd(username)
+		return nil, err
+	}
+
+	for i, todo := range added {
+		s.publish(EventCreated, username, todo.ID)
+		s.recordActivity(username, ActivityCreated, todo)
+		added[i] = todo.clone()
+	}
+	return added, nil
+}
+
+// Move shifts the todo with the specified ID by offset places in the
+// display order (negative moves it up), clamped to the ends of the list
+func (s *Store) Move(username string, id int, offset int) error {
+	if id < 1 {
+		return ErrInvalidID
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return err
+	}
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return fmt.Errorf("todo with ID %d not found", id)
+	}
+
+	ordered := make([]*Todo, 0, len(userTodos.Todos))
+	prevPositions := make(map[*Todo]int, len(userTodos.Todos))
+	for _, t := range userTodos.Todos {
+		ordered = append(ordered, t)
+		prevPositions[t] = t.Position
+	}
+	SortByPosition(ordered)
+
+	from := 0
+	for i, t := range ordered {
+		if t == todo {
+			from = i
+			break
+		}
+	}
+	to := min(max(from+offset, 0), len(ordered)-1)
+	if to == from {
+		return nil
+	}
+
+	ordered = append(ordered[:from], ordered[from+1:]...)
+	ordered = append(ordered[:to], append([]*Todo{todo}, ordered[to:]...)...)
+	for i, t := range ordered {
+		t.Position = i + 1
+	}
+
+	// Save to disk, restoring the previous order if that fails
+	if err := s.saveTodos(username); err != nil {
+		for t, position := range prevPositions {
+			t.Position = position
+		}
+		return err
+	}
+
+	// Every todo between the old and new place moved
+	for _, t := range ordered {
+		if t.Position != prevPositions[t] {
+			s.publish(EventUpdated, username, t.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package todo
+
+import (
+	"fmt"
+	"time"
+)
+
+// TodoPatch lists changes to make to a todo with Patch. Nil fields are left
+// as they are.
+type TodoPatch struct {
+	Text      *string
+	Completed *bool
+	Priority  *int
+	DueAt     *time.Time
+	ClearDue  bool      // Remove the due date; DueAt takes precedence
+	Tags      *[]string // Replaces the tags, normalized as by SetTags; an empty list clears them
+	Notes     *string
+}
+
+// Patch applies the set fields of patch to the todo with the specified ID
+// for the specified user, saving them together. Completing a blocked todo
+// fails with ErrBlocked while blockers are enforced, and nothing is changed.
+func (s *Store) Patch(username string, id int, patch TodoPatch) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return nil, fmt.Errorf("todo with ID %d not found", id)
+	}
+	toggled := patch.Completed != nil && *patch.Completed != todo.Completed
+	if toggled && !todo.Completed && s.enforceBlockers && blocked(userTodos, todo) {
+		return nil, ErrBlocked
+	}
+	prev := *todo
+	prevCompletedCount := userTodos.CompletedCount
+
+	updated := false
+	if patch.Text != nil {
+		todo.Text = *patch.Text
+		updated = true
+	}
+	if patch.Priority != nil {
+		todo.Priority = *patch.Priority
+		updated = true
+	}
+	if patch.DueAt != nil {
+		due := patch.DueAt.UTC()
+		todo.DueAt = &due
+		updated = true
+	} else if patch.ClearDue {
+		todo.DueAt = nil
+		updated = true
+	}
+	if patch.Tags != nil {
+		todo.Tags = normalizeTags(*patch.Tags)
+		updated = true
+	}
+	if patch.Notes != nil {
+		todo.Notes = *patch.Notes
+		updated = true
+	}
+	if !updated && !toggled {
+		return todo.clone(), nil
+	}
+
+	todo.UpdatedAt = s.timestamp()
+	if patch.Text != nil {
+		todo.TextUpdatedAt = todo.UpdatedAt
+	}
+	if toggled {
+		todo.Completed = *patch.Completed
+		todo.CompletedAt = nil
+		if todo.Completed {
+			completedAt := todo.UpdatedAt
+			todo.CompletedAt = &completedAt
+			userTodos.CompletedCount++
+		}
+	}
+
+	// Save to disk, undoing the changes if that fails
+	if err := s.saveTodos(username); err != nil {
+		*todo = prev
+		userTodos.CompletedCount = prevCompletedCount
+		return nil, err
+	}
+
+	if updated {
+		s.publish(EventUpdated, username, id)
+	}
+	if toggled {
+		s.publish(EventToggled, username, id)
+		s.recordActivity(username, toggleAction(todo), todo)
+	}
+	return todo.clone(), nil
+}
@@ -0,0 +1,40 @@
+package todo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ExportPlain returns the user's todos in display order as plain text for
+// Unix tools, one per line: a status marker ("[x]" when completed, "[ ]"
+// otherwise), the ID and the text, separated by tabs. Control characters in
+// the text are replaced by spaces, so each todo stays on its own line and no
+// escape sequences reach the output. An empty list gives an empty string.
+func (s *Store) ExportPlain(username string) (string, error) {
+	todos, err := s.listAll(username)
+	if err != nil {
+		return "", err
+	}
+	SortByPosition(todos)
+
+	var b strings.Builder
+	for _, todo := range todos {
+		status := "[ ]"
+		if todo.Completed {
+			status = "[x]"
+		}
+		fmt.Fprintf(&b, "%s\t%d\t%s\n", status, todo.ID, plainText(todo.Text))
+	}
+	return b.String(), nil
+}
+
+// plainText replaces control characters, including tabs and newlines, with spaces
+func plainText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,57 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrQuotaExceeded is returned when saving would grow a user's todos file
+// past the configured quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// SetQuota limits the size of each user's todos file to the given number of
+// bytes. Changes that would grow a file past the limit fail with
+// ErrQuotaExceeded; changes that shrink it, such as deletions, are always
+// allowed. Zero or less removes the limit.
+func (s *Store) SetQuota(bytes int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.quota = max(bytes, 0)
+}
+
+// UserDiskUsage returns the number of bytes the specified user's todos take on disk
+func (s *Store) UserDiskUsage(username string) (int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	path, err := s.todosPath(username)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read todos file: %v", err)
+	}
+	return info.Size(), nil
+}
+
+// checkQuota returns ErrQuotaExceeded if writing size bytes for the user
+// would grow their file past the quota.
+// We assume the caller already has the lock.
+func (s *Store) checkQuota(username string, size int64) error {
+	if s.quota == 0 || size <= s.quota {
+		return nil
+	}
+	path, err := s.todosPath(username)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && size <= info.Size() {
+		return nil
+	}
+	return ErrQuotaExceeded
+}
@@ -0,0 +1,37 @@
+package todo
+
+import "fmt"
+
+// CurrentSchemaVersion is the version of the todos file format this build
+// writes. Files saved before versioning have no version and count as 0.
+const CurrentSchemaVersion = 2
+
+// migrateTodos brings todos read from disk up to CurrentSchemaVersion,
+// reporting whether they changed and should be rewritten. Files written by
+// a newer version are refused rather than risk losing what they added.
+func migrateTodos(userTodos *UserTodos) (bool, error) {
+	version := userTodos.SchemaVersion
+	if version > CurrentSchemaVersion {
+		return false, fmt.Errorf("todos file has schema version %d, but this version of todoissh only supports up to %d", version, CurrentSchemaVersion)
+	}
+	if version == CurrentSchemaVersion {
+		return false, nil
+	}
+
+	// Version 0 files may key todos by ID, which UnmarshalJSON already
+	// accepts, and may predate the lifetime counters
+	if version < 1 {
+		seedCounters(userTodos)
+	}
+	// Version 2 added TextUpdatedAt; older texts count as unchanged since creation
+	if version < 2 {
+		for _, todo := range userTodos.Todos {
+			todo.TextUpdatedAt = todo.CreatedAt
+		}
+		for _, todo := range userTodos.Archived {
+			todo.TextUpdatedAt = todo.CreatedAt
+		}
+	}
+	userTodos.SchemaVersion = CurrentSchemaVersion
+	return true, nil
+}
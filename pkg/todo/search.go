@@ -0,0 +1,60 @@
+package todo
+
+import "strings"
+
+// SearchField selects which todo fields a search matches against
+type SearchField int
+
+const (
+	SearchText SearchField = 1 << iota
+	SearchTags
+	SearchNotes
+
+	SearchAllFields = SearchText | SearchTags | SearchNotes
+)
+
+// Search returns the specified user's todos whose text contains query,
+// ignoring case, sorted by ID
+func (s *Store) Search(username, query string) ([]*Todo, error) {
+	return s.SearchAll(username, query, SearchText)
+}
+
+// SearchAll returns the specified user's todos where any of the selected
+// fields contains query, ignoring case, sorted by ID. For tags, the query
+// matches any part of any tag.
+func (s *Store) SearchAll(username, query string, fields SearchField) ([]*Todo, error) {
+	todos, err := s.List(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	query = strings.ToLower(query)
+	matches := make([]*Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.matches(query, fields) {
+			matches = append(matches, todo)
+		}
+	}
+	return matches, nil
+}
+
+// matches reports whether a lowercased query occurs in any selected field
+func (t *Todo) matches(query string, fields SearchField) bool {
+	if fields&SearchText != 0 && strings.Contains(strings.ToLower(t.Text), query) {
+		return true
+	}
+	if fields&SearchNotes != 0 && strings.Contains(strings.ToLower(t.Notes), query) {
+		return true
+	}
+	if fields&SearchTags != 0 {
+		for _, tag := range t.Tags {
+			if strings.Contains(strings.ToLower(tag), query) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package todo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shardPrefixLength is the number of hex digits of the username hash used
+// as the shard directory name, giving 256 shards
+const shardPrefixLength = 2
+
+// shardFor returns the shard directory name for a username
+func shardFor(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(sum[:])[:shardPrefixLength]
+}
+
+// SetTodosDir sets the subdirectory of the data directory that holds the
+// todos files, creating it if needed. Files in the previous directory are
+// not moved. The name must be a single path element.
+func (s *Store) SetTodosDir(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid todos directory name %q", name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(s.dataDir, name), 0700); err != nil {
+		return fmt.Errorf("failed to create todos directory: %v", err)
+	}
+	s.todosName = name
+	return nil
+}
+
+// todosDir returns the directory holding the todos files.
+// We assume the caller already has the lock.
+func (s *Store) todosDir() string {
+	if s.todosName == "" {
+		return filepath.Join(s.dataDir, DefaultTodosDir)
+	}
+	return filepath.Join(s.dataDir, s.todosName)
+}
+
+// todosPath returns the file holding a user's todos for the current layout,
+// or ErrInvalidUsername if the name would put it outside the todos directory.
+// We assume the caller already has the lock.
+func (s *Store) todosPath(username string) (string, error) {
+	if username == "" || strings.HasPrefix(username, ".") || strings.ContainsAny(username, "/\\\x00") {
+		return "", ErrInvalidUsername
+	}
+	path := s.layoutPath(username, s.sharded)
+	if rel, err := filepath.Rel(s.todosDir(), path); err != nil || !filepath.IsLocal(rel) {
+		return "", ErrInvalidUsername
+	}
+	return path, nil
+}
+
+// layoutPath returns the file holding a user's todos in the given layout
+func (s *Store) layoutPath(username string, sharded bool) string {
+	todosDir := s.todosDir()
+	if sharded {
+		return filepath.Join(todosDir, shardFor(username), username+".json")
+	}
+	return filepath.Join(todosDir, username+".json")
+}
+
+// SetSharded switches between the flat layout (todos/<user>.json) and the
+// sharded layout (todos/<hash prefix>/<user>.json), moving existing files
+// into the new layout. The flat layout is the default.
+func (s *Store) SetSharded(sharded bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.migrateLayout(sharded); err != nil {
+		return err
+	}
+	s.sharded = sharded
+	return nil
+}
+
+// migrateLayout moves every todos file into the given layout. Files already
+// in place are left alone. We assume the caller already has the lock.
+func (s *Store) migrateLayout(sharded bool) error {
+	todosDir := s.todosDir()
+	entries, err := os.ReadDir(todosDir)
+	if err != nil {
+		return fmt.Errorf("failed to read todos directory: %v", err)
+	}
+
+	// Collect the files stored in the other layout
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if sharded {
+				continue
+			}
+			shardFiles, err := filepath.Glob(filepath.Join(todosDir, entry.Name(), "*.json"))
+			if err != nil {
+				return fmt.Errorf("failed to read shard %s: %v", entry.Name(), err)
+			}
+			paths = append(paths, shardFiles...)
+			continue
+		}
+		if sharded && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, filepath.Join(todosDir, entry.Name()))
+		}
+	}
+
+	for _, path := range paths {
+		username := strings.TrimSuffix(filepath.Base(path), ".json")
+		target := s.layoutPath(username, sharded)
+		if target == path {
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			return fmt.Errorf("cannot migrate todos for %s: %s already exists", username, target)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return fmt.Errorf("failed to create shard directory: %v", err)
+		}
+		if err := os.Rename(path, target); err != nil {
+			return fmt.Errorf("failed to move todos for %s: %v", username, err)
+		}
+	}
+
+	// Drop shard directories left empty by a move back to the flat layout
+	if !sharded {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				os.Remove(filepath.Join(todosDir, entry.Name())) // Fails harmlessly if not empty
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package todo
+
+import (
+	"sort"
+	"time"
+)
+
+// IsSnoozed reports whether the todo is snoozed at now, and so hidden from
+// List until its snooze time passes
+func (t *Todo) IsSnoozed(now time.Time) bool {
+	return t.SnoozedUntil != nil && t.SnoozedUntil.After(now)
+}
+
+// Snooze hides the todo with the specified ID for the specified user from
+// List until the given time, after which it reappears by itself. A zero
+// time wakes it right away.
+func (s *Store) Snooze(username string, id int, until time.Time) error {
+	var snoozed *time.Time
+	if !until.IsZero() {
+		u := until.UTC()
+		snoozed = &u
+	}
+	_, err := s.modify(username, id, func(todo *Todo) {
+		todo.SnoozedUntil = snoozed
+	})
+	return err
+}
+
+// ListSnoozed returns the specified user's todos that are snoozed now,
+// those that reappear first first
+func (s *Store) ListSnoozed(username string) ([]*Todo, error) {
+	todos, err := s.ListUnordered(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	now := s.now()
+	s.RUnlock()
+
+	snoozed := make([]*Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.IsSnoozed(now) {
+			snoozed = append(snoozed, todo)
+		}
+	}
+	sort.Slice(snoozed, func(i, j int) bool {
+		a, b := snoozed[i], snoozed[j]
+		if !a.SnoozedUntil.Equal(*b.SnoozedUntil) {
+			return a.SnoozedUntil.Before(*b.SnoozedUntil)
+		}
+		return a.ID < b.ID
+	})
+	return snoozed, nil
+}
@@ -0,0 +1,128 @@
+package todo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stats summarizes a user's todos
+type Stats struct {
+	Total     int // Current todos
+	Completed int // Current todos that are completed
+	Pending   int // Current todos that are not completed
+
+	// Lifetime counters, including todos that have since been deleted
+	TotalCreated   int
+	TotalCompleted int
+}
+
+// Stats returns live counts and lifetime counters for the specified user
+func (s *Store) Stats(username string) (Stats, error) {
+	userTodos, err := s.getUserTodos(username)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return statsOf(userTodos), nil
+}
+
+// statsOf counts a user's todos.
+// We assume the caller already has the lock.
+func statsOf(userTodos *UserTodos) Stats {
+	stats := Stats{
+		Total:          len(userTodos.Todos),
+		TotalCreated:   userTodos.CreatedCount,
+		TotalCompleted: userTodos.CompletedCount,
+	}
+	for _, todo := range userTodos.Todos {
+		if todo.Completed {
+			stats.Completed++
+		}
+	}
+	stats.Pending = stats.Total - stats.Completed
+	return stats
+}
+
+// StoredStats is like Stats, but reads the file of a user who isn't cached
+// without caching them, so surveying every user doesn't keep all their
+// todos in memory. Users without a file have no todos.
+func (s *Store) StoredStats(username string) (Stats, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if userTodos, ok := s.userTodos[username]; ok {
+		return statsOf(userTodos), nil
+	}
+	path, err := s.todosPath(username)
+	if err != nil {
+		return Stats{}, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Stats{}, nil
+	}
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to read todos file: %v", err)
+	}
+	var userTodos UserTodos
+	if err := json.Unmarshal(data, &userTodos); err != nil {
+		return Stats{}, fmt.Errorf("failed to parse todos file: %v", err)
+	}
+	if _, err := migrateTodos(&userTodos); err != nil {
+		return Stats{}, err
+	}
+	return statsOf(&userTodos), nil
+}
+
+// TotalTodos returns the number of todos across every todos file, whether
+// or not its user has an account. Files are read one at a time with
+// StoredStats, so memory use doesn't grow with the number of users, but
+// the time taken does.
+func (s *Store) TotalTodos() (int, error) {
+	s.RLock()
+	todosDir := s.todosDir()
+	s.RUnlock()
+
+	var usernames []string
+	err := filepath.WalkDir(todosDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".json") {
+			usernames = append(usernames, strings.TrimSuffix(d.Name(), ".json"))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read todos directory: %v", err)
+	}
+
+	total := 0
+	for _, username := range usernames {
+		stats, err := s.StoredStats(username)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count todos for %s: %v", username, err)
+		}
+		total += stats.Total
+	}
+	return total, nil
+}
+
+// seedCounters makes sure lifetime counters are at least what the current
+// todos imply, for files written before the counters existed
+func seedCounters(userTodos *UserTodos) {
+	completed := 0
+	for _, todo := range userTodos.Todos {
+		if todo.Completed {
+			completed++
+		}
+	}
+	userTodos.CreatedCount = max(userTodos.CreatedCount, userTodos.NextID-1, len(userTodos.Todos))
+	userTodos.CompletedCount = max(userTodos.CompletedCount, completed)
+}
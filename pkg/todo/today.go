@@ -0,0 +1,77 @@
+package todo
+
+import "time"
+
+// SetLocation sets the time zone used to show timestamps and to decide
+// where a day starts and ends for ListToday. Timestamps are stored in UTC
+// regardless. Nil, the default, uses the server's local time zone.
+func (s *Store) SetLocation(loc *time.Location) {
+	s.Lock()
+	defer s.Unlock()
+	s.location = loc
+}
+
+// Location returns the time zone set with SetLocation
+func (s *Store) Location() *time.Location {
+	s.RLock()
+	defer s.RUnlock()
+	return s.zone()
+}
+
+// zone returns the configured time zone, defaulting to the local one.
+// We assume the caller already has the lock.
+func (s *Store) zone() *time.Location {
+	if s.location == nil {
+		return time.Local
+	}
+	return s.location
+}
+
+// In returns a copy of the todo with its timestamps shown in loc
+func (t *Todo) In(loc *time.Location) *Todo {
+	c := *t
+	c.CreatedAt = c.CreatedAt.In(loc)
+	c.UpdatedAt = c.UpdatedAt.In(loc)
+	c.TextUpdatedAt = c.TextUpdatedAt.In(loc)
+	if c.DueAt != nil {
+		due := c.DueAt.In(loc)
+		c.DueAt = &due
+	}
+	if c.CompletedAt != nil {
+		completed := c.CompletedAt.In(loc)
+		c.CompletedAt = &completed
+	}
+	if c.SnoozedUntil != nil {
+		snoozed := c.SnoozedUntil.In(loc)
+		c.SnoozedUntil = &snoozed
+	}
+	return &c
+}
+
+// ListToday returns the specified user's todos for today, sorted by ID:
+// incomplete todos due today or overdue, and any todo created today.
+// Todos without a due date only appear if they were created today.
+func (s *Store) ListToday(username string) ([]*Todo, error) {
+	todos, err := s.List(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	loc := s.zone()
+	now := s.now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 1)
+
+	today := make([]*Todo, 0, len(todos))
+	for _, todo := range todos {
+		due := !todo.Completed && todo.DueAt != nil && todo.DueAt.Before(end)
+		created := !todo.CreatedAt.Before(start) && todo.CreatedAt.Before(end)
+		if due || created {
+			today = append(today, todo)
+		}
+	}
+	return today, nil
+}
@@ -1,34 +1,207 @@
 package todo
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Todo represents a single todo item
 type Todo struct {
-	ID        int       `json:"id"`
-	Text      string    `json:"text"`
-	Completed bool      `json:"completed"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int        `json:"id"`
+	Text      string     `json:"text"`
+	Completed bool       `json:"completed"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DueAt     *time.Time `json:"due_at,omitempty"`
+	Priority  int        `json:"priority,omitempty"` // 0 is none; higher is more important
+	Position  int        `json:"position,omitempty"` // Display order; 0 falls back to the ID
+	Tags      []string   `json:"tags,omitempty"`
+	Notes     string     `json:"notes,omitempty"`
+	BlockedBy []int      `json:"blocked_by,omitempty"` // IDs of todos that must be completed first
+
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`  // When it was completed; nil while active
+	TextUpdatedAt time.Time  `json:"text_updated_at"`         // When the text last changed; UpdatedAt covers any change
+	SnoozedUntil  *time.Time `json:"snoozed_until,omitempty"` // Hidden from List until then; see Snooze
+}
+
+// DueStatus classifies a todo by how close it is to its due date
+type DueStatus int
+
+const (
+	DueNone    DueStatus = iota // No due date, or already completed
+	DueLater                    // Due more than DueSoonWindow from now
+	DueSoon                     // Due within DueSoonWindow
+	DueOverdue                  // Due date is now or in the past
+)
+
+// DueSoonWindow is how far ahead a due date counts as "due soon"
+const DueSoonWindow = 24 * time.Hour
+
+// ClassifyDue reports the due status of a todo relative to now.
+// A todo due exactly at now is considered overdue.
+func ClassifyDue(todo *Todo, now time.Time) DueStatus {
+	if todo == nil || todo.DueAt == nil || todo.Completed {
+		return DueNone
+	}
+	if !todo.DueAt.After(now) {
+		return DueOverdue
+	}
+	if todo.DueAt.Sub(now) <= DueSoonWindow {
+		return DueSoon
+	}
+	return DueLater
+}
+
+// WasEdited reports whether the todo's text was changed after it was
+// created. Completing it or changing other details doesn't count.
+func (t *Todo) WasEdited() bool {
+	return t.TextUpdatedAt.After(t.CreatedAt)
+}
+
+// clone returns a copy of the todo for callers outside the store, so they
+// can read it without the lock while sessions keep changing the original
+func (t *Todo) clone() *Todo {
+	c := *t
+	c.DueAt = cloneTime(t.DueAt)
+	c.CompletedAt = cloneTime(t.CompletedAt)
+	c.SnoozedUntil = cloneTime(t.SnoozedUntil)
+	c.Tags = append([]string(nil), t.Tags...)
+	c.BlockedBy = append([]int(nil), t.BlockedBy...)
+	return &c
+}
+
+// cloneTime returns a copy of the time t points to, or nil
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
 }
 
 // UserTodos stores todos for a single user
 type UserTodos struct {
+	SchemaVersion int `json:"schema_version"` // See CurrentSchemaVersion
+
 	Todos  map[int]*Todo `json:"todos"`
 	NextID int           `json:"next_id"`
+
+	// Lifetime counters; they never decrease, even when todos are deleted
+	CreatedCount   int `json:"created_count"`
+	CompletedCount int `json:"completed_count"`
+
+	// Completed todos moved out of the list; see AutoArchive
+	Archived []*Todo `json:"archived,omitempty"`
+}
+
+// MarshalJSON writes the todos as an array sorted by ID, so saved files
+// list them in a stable, readable order
+func (u *UserTodos) MarshalJSON() ([]byte, error) {
+	type plain UserTodos
+	todos := make([]*Todo, 0, len(u.Todos))
+	for _, todo := range u.Todos {
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].ID < todos[j].ID
+	})
+
+	return json.Marshal(struct {
+		*plain
+		Todos []*Todo `json:"todos"`
+	}{(*plain)(u), todos})
+}
+
+// UnmarshalJSON reads todos written either as an array or, by older
+// versions, as an object keyed by ID. The legacy form is rewritten as an
+// array the next time the todos are saved.
+func (u *UserTodos) UnmarshalJSON(data []byte) error {
+	type plain UserTodos
+	aux := struct {
+		*plain
+		Todos json.RawMessage `json:"todos"`
+	}{plain: (*plain)(u)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	u.Todos = make(map[int]*Todo)
+	raw := bytes.TrimSpace(aux.Todos)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '[':
+		var todos []*Todo
+		if err := json.Unmarshal(raw, &todos); err != nil {
+			return err
+		}
+		for _, todo := range todos {
+			if todo != nil {
+				u.Todos[todo.ID] = todo
+			}
+		}
+	default:
+		if err := json.Unmarshal(raw, &u.Todos); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// ErrReadOnly is returned by changes made while the store is read-only
+var ErrReadOnly = errors.New("todos are read-only for maintenance")
+
+// ErrInvalidUsername is returned for usernames whose todos file would lie
+// outside the todos directory
+var ErrInvalidUsername = errors.New("invalid username")
+
+// ErrInvalidID is returned for todo IDs below 1, which no todo can have
+var ErrInvalidID = errors.New("invalid todo ID")
+
+// ErrRateLimited is returned by Add when a user creates todos faster than the configured rate
+var ErrRateLimited = errors.New("too many todos created, slow down")
+
+// addBucket is a per-user token bucket limiting todo creation
+type addBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// DefaultTodosDir is the subdirectory of the data directory todos are kept
+// in unless SetTodosDir picks another
+const DefaultTodosDir = "todos"
+
 // Store manages todos for multiple users
 type Store struct {
 	sync.RWMutex
-	userTodos map[string]*UserTodos // map[username]todos
-	dataDir   string
+	userTodos  map[string]*UserTodos // map[username]todos
+	dataDir    string
+	todosName  string  // subdirectory of dataDir holding todos files; empty uses DefaultTodosDir
+	addRate    float64 // tokens per second; 0 means unlimited
+	addBurst   float64
+	addBuckets map[string]*addBucket
+	sharded    bool                                                   // store files under todos/<hash prefix>/; see SetSharded
+	precision  time.Duration                                          // stored timestamps are truncated to this; 0 keeps full precision
+	writeFile  func(name string, data []byte, perm os.FileMode) error // nil uses os.WriteFile
+	clock      func() time.Time                                       // nil uses time.Now
+	location   *time.Location                                         // time zone for display and ListToday; nil uses time.Local
+	quota      int64                                                  // maximum bytes per todos file; 0 means unlimited
+	subs       subscribers                                            // receive saved changes; see Subscribe
+	activity   map[string][]ActivityEntry                             // latest actions per user, oldest first
+	unsaved    map[string]bool                                        // loaded users whose file is behind memory; see FlushAll
+	readOnly   bool                                                   // refuse all saves; see SetReadOnly
+	archiveAge time.Duration                                          // archive todos completed this long ago; 0 disables it
+
+	enforceBlockers bool   // refuse to complete blocked todos; see SetEnforceBlockers
+	deletionDir     string // keep the todos of deleted users here; see SetDeletionArchiveDir
 }
 
 // NewStore creates a new todo store with the given data directory
@@ -39,32 +212,68 @@ func NewStore(dataDir string) (*Store, error) {
 	}
 
 	store := &Store{
-		userTodos: make(map[string]*UserTodos),
-		dataDir:   dataDir,
+		userTodos:  make(map[string]*UserTodos),
+		dataDir:    dataDir,
+		addBuckets: make(map[string]*addBucket),
+		activity:   make(map[string][]ActivityEntry),
+		unsaved:    make(map[string]bool),
 	}
 
 	// Create the todos directory if it doesn't exist
-	todosDir := filepath.Join(dataDir, "todos")
-	if err := os.MkdirAll(todosDir, 0700); err != nil {
+	if err := os.MkdirAll(store.todosDir(), 0700); err != nil {
 		return nil, fmt.Errorf("failed to create todos directory: %v", err)
 	}
 
 	return store, nil
 }
 
-// getUserTodos gets or creates a user's todos
+// getUserTodos gets or creates a user's todos. Cached users only take the
+// read lock, so concurrent readers don't serialize on every UI refresh.
+// Changes use loadUserTodos under the write lock instead, since Invalidate
+// may drop the cached todos between the two locks.
 func (s *Store) getUserTodos(username string) (*UserTodos, error) {
+	s.RLock()
+	userTodos, exists := s.userTodos[username]
+	s.RUnlock()
+	if exists {
+		return userTodos, nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.loadUserTodos(username)
+}
+
+// Invalidate drops the cached todos of the specified user, so the next
+// operation reads them from disk again, e.g. after the file was edited by
+// hand. Every change is saved as it is made, so nothing is lost.
+func (s *Store) Invalidate(username string) {
 	s.Lock()
 	defer s.Unlock()
+	delete(s.userTodos, username)
+	delete(s.unsaved, username)
+}
 
+// loadUserTodos returns a user's cached todos, loading them from disk or
+// creating them on a miss. Checking the cache again under the write lock
+// ensures concurrent first loads only read the file once.
+// We assume the caller already has the lock.
+func (s *Store) loadUserTodos(username string) (*UserTodos, error) {
 	userTodos, exists := s.userTodos[username]
 	if exists {
 		return userTodos, nil
 	}
 
 	// Try to load from disk
-	todosPath := filepath.Join(s.dataDir, "todos", username+".json")
-	if _, err := os.Stat(todosPath); err == nil {
+	todosPath, err := s.todosPath(username)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(todosPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read todos file: %v", err)
+	}
+	if err == nil {
 		// File exists, load it
 		data, err := os.ReadFile(todosPath)
 		if err != nil {
@@ -75,8 +284,19 @@ func (s *Store) getUserTodos(username string) (*UserTodos, error) {
 		if err := json.Unmarshal(data, &userTodos); err != nil {
 			return nil, fmt.Errorf("failed to parse todos file: %v", err)
 		}
+		migrated, err := migrateTodos(&userTodos)
+		if err != nil {
+			return nil, err
+		}
 
 		s.userTodos[username] = &userTodos
+		if migrated {
+			// Rewrite at the current version; if this fails (e.g. while
+			// read-only), the next successful save or FlushAll does it
+			if err := s.saveTodos(username); err != nil {
+				s.unsaved[username] = true
+			}
+		}
 		return &userTodos, nil
 	}
 
@@ -93,57 +313,304 @@ func (s *Store) getUserTodos(username string) (*UserTodos, error) {
 // saveTodos saves a user's todos to disk
 func (s *Store) saveTodos(username string) error {
 	// We assume the caller already has the lock
+	if s.readOnly {
+		return ErrReadOnly
+	}
 	userTodos, exists := s.userTodos[username]
 	if !exists {
 		return fmt.Errorf("no todos found for user %s", username)
 	}
 
+	userTodos.SchemaVersion = CurrentSchemaVersion
 	data, err := json.MarshalIndent(userTodos, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to serialize todos: %v", err)
 	}
+	if err := s.checkQuota(username, int64(len(data))); err != nil {
+		return err
+	}
+
+	todosPath, err := s.todosPath(username)
+	if err != nil {
+		return err
+	}
+	if s.sharded {
+		if err := os.MkdirAll(filepath.Dir(todosPath), 0700); err != nil {
+			return fmt.Errorf("failed to create shard directory: %v", err)
+		}
+	}
+	if err := s.writeAtomic(todosPath, data); err != nil {
+		return err
+	}
+	delete(s.unsaved, username)
+	return nil
+}
+
+// PendingWrites returns how many loaded users have changes FlushAll would
+// save
+func (s *Store) PendingWrites() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.unsaved)
+}
+
+// FlushAll saves every loaded user whose file is behind what is in memory,
+// such as one whose migration to the current schema couldn't be written
+// when it was loaded. Changes are otherwise saved as they are made, so this
+// is for making sure nothing is left behind, e.g. on shutdown or when asked
+// to save. A failure for one user doesn't stop the others; all failures are
+// returned together.
+func (s *Store) FlushAll() error {
+	s.Lock()
+	defer s.Unlock()
+
+	usernames := make([]string, 0, len(s.unsaved))
+	for username := range s.unsaved {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	var errs []error
+	for _, username := range usernames {
+		if err := s.saveTodos(username); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save todos of %s: %v", username, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeAtomic writes data to a temporary file and renames it into place,
+// so a failed write (e.g. a full disk) never leaves a truncated file behind
+func (s *Store) writeAtomic(path string, data []byte) error {
+	writeFile := s.writeFile
+	if writeFile == nil {
+		writeFile = os.WriteFile
+	}
+
+	tmpPath := path + ".tmp"
+	if err := writeFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write todos file: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace todos file: %v", err)
+	}
+	return nil
+}
+
+// SetReadOnly turns maintenance mode on or off. While it is on, every
+// change fails with ErrReadOnly and is undone, while reads keep working.
+func (s *Store) SetReadOnly(readOnly bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.readOnly = readOnly
+}
+
+// ReadOnly reports whether the store is in maintenance mode
+func (s *Store) ReadOnly() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.readOnly
+}
+
+// SetTimestampPrecision truncates the timestamps stored on todos to the
+// given granularity, e.g. time.Second, to keep files free of noisy
+// sub-second digits. Zero, the default, keeps full precision. Timestamps
+// already stored are loaded as they are.
+func (s *Store) SetTimestampPrecision(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.precision = max(d, 0)
+}
+
+// now returns the current time from the store's clock
+func (s *Store) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+// timestamp returns the current time in UTC at the configured precision.
+// We assume the caller already has the lock.
+func (s *Store) timestamp() time.Time {
+	now := s.now().UTC()
+	if s.precision > 0 {
+		now = now.Truncate(s.precision)
+	}
+	return now
+}
+
+// SetAddRate limits how fast each user can create todos: perMinute sustained,
+// with bursts of up to burst. A perMinute of 0 or less removes the limit.
+func (s *Store) SetAddRate(perMinute, burst int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if perMinute <= 0 {
+		s.addRate = 0
+	} else {
+		s.addRate = float64(perMinute) / 60
+	}
+	s.addBurst = float64(max(burst, 1))
+	s.addBuckets = make(map[string]*addBucket)
+}
+
+// allowAdd takes a token from the user's bucket, reporting whether one was available.
+// We assume the caller already has the lock.
+func (s *Store) allowAdd(username string, now time.Time) bool {
+	if s.addRate == 0 {
+		return true
+	}
+
+	bucket, exists := s.addBuckets[username]
+	if !exists {
+		bucket = &addBucket{tokens: s.addBurst, last: now}
+		s.addBuckets[username] = bucket
+	}
 
-	todosPath := filepath.Join(s.dataDir, "todos", username+".json")
-	return os.WriteFile(todosPath, data, 0600)
+	bucket.tokens = min(s.addBurst, bucket.tokens+now.Sub(bucket.last).Seconds()*s.addRate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// refundAdd gives back the token taken by allowAdd for an add that failed.
+// We assume the caller already has the lock.
+func (s *Store) refundAdd(username string) {
+	if bucket, exists := s.addBuckets[username]; exists && s.addRate != 0 {
+		bucket.tokens = min(s.addBurst, bucket.tokens+1)
+	}
 }
 
 // Add adds a new todo for the specified user
 func (s *Store) Add(username, text string) (*Todo, error) {
+	return s.AddCtx(context.Background(), username, text)
+}
+
+// AddCtx is like Add but gives up before saving once ctx is done
+func (s *Store) AddCtx(ctx context.Context, username, text string) (*Todo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
-	// Get or create user todos (without locking since we already have the lock)
-	userTodos, exists := s.userTodos[username]
-	if !exists {
-		// Create new user todos
-		userTodos = &UserTodos{
-			Todos:  make(map[int]*Todo),
-			NextID: 1,
-		}
-		s.userTodos[username] = userTodos
+	// Get or load user todos (without locking since we already have the
+	// lock), so a user who isn't cached keeps the todos on disk. A file
+	// that can't be read is left alone rather than replaced.
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
 	}
 
+	if !s.allowAdd(username, s.now()) {
+		return nil, ErrRateLimited
+	}
+	now := s.timestamp()
+
 	todo := &Todo{
 		ID:        userTodos.NextID,
 		Text:      text,
 		Completed: false,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Position:  nextPosition(userTodos),
+
+		TextUpdatedAt: now,
+	}
+
+	if err := ctx.Err(); err != nil {
+		s.refundAdd(username)
+		return nil, err
 	}
 
 	userTodos.Todos[todo.ID] = todo
 	userTodos.NextID++
+	userTodos.CreatedCount++
 
-	// Save to disk
+	// Save to disk, undoing the add if that fails
 	if err := s.saveTodos(username); err != nil {
+		delete(userTodos.Todos, todo.ID)
+		userTodos.NextID--
+		userTodos.CreatedCount--
+		s.refundAdd(username)
 		return nil, err
 	}
 
-	return todo, nil
+	s.publish(EventCreated, username, todo.ID)
+	s.recordActivity(username, ActivityCreated, todo)
+	return todo.clone(), nil
 }
 
-// List returns all todos for the specified user
+// List returns the todos for the specified user, sorted by ID, leaving
+// out snoozed ones until their snooze time passes
 func (s *Store) List(username string) ([]*Todo, error) {
+	return s.ListCtx(context.Background(), username)
+}
+
+// ListCtx is like List but gives up before loading once ctx is done
+func (s *Store) ListCtx(ctx context.Context, username string) ([]*Todo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	todos, err := s.listAll(username)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RLock()
+	now := s.now()
+	s.RUnlock()
+
+	visible := todos[:0]
+	for _, todo := range todos {
+		if !todo.IsSnoozed(now) {
+			visible = append(visible, todo)
+		}
+	}
+	return visible, nil
+}
+
+// listAll returns all todos for the specified user, snoozed or not,
+// sorted by ID
+func (s *Store) listAll(username string) ([]*Todo, error) {
+	todos, err := s.ListUnordered(username)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].ID < todos[j].ID
+	})
+	return todos, nil
+}
+
+// ListByStatus returns only the completed, or only the pending, todos for
+// the specified user, sorted by ID. Archived and snoozed todos are left out.
+func (s *Store) ListByStatus(username string, completed bool) ([]*Todo, error) {
+	todos, err := s.List(username)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make([]*Todo, 0, len(todos))
+	for _, todo := range todos {
+		if todo.Completed == completed {
+			matching = append(matching, todo)
+		}
+	}
+	return matching, nil
+}
+
+// ListUnordered returns all todos for the specified user in no particular order
+func (s *Store) ListUnordered(username string) ([]*Todo, error) {
 	userTodos, err := s.getUserTodos(username)
 	if err != nil {
 		return nil, err
@@ -154,13 +621,25 @@ func (s *Store) List(username string) ([]*Todo, error) {
 
 	todos := make([]*Todo, 0, len(userTodos.Todos))
 	for _, todo := range userTodos.Todos {
-		todos = append(todos, todo)
+		todos = append(todos, todo.clone())
 	}
 	return todos, nil
 }
 
 // Get returns the todo with the specified ID for the specified user
 func (s *Store) Get(username string, id int) (*Todo, error) {
+	return s.GetCtx(context.Background(), username, id)
+}
+
+// GetCtx is like Get but gives up before loading once ctx is done
+func (s *Store) GetCtx(ctx context.Context, username string, id int) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	userTodos, err := s.getUserTodos(username)
 	if err != nil {
 		return nil, err
@@ -173,77 +652,532 @@ func (s *Store) Get(username string, id int) (*Todo, error) {
 	if !ok {
 		return nil, fmt.Errorf("todo with ID %d not found", id)
 	}
-	return todo, nil
+	return todo.clone(), nil
 }
 
 // Update updates the todo with the specified ID for the specified user
 func (s *Store) Update(username string, id int, text string) (*Todo, error) {
-	userTodos, err := s.getUserTodos(username)
-	if err != nil {
+	return s.UpdateCtx(context.Background(), username, id, text)
+}
+
+// UpdateCtx is like Update but gives up before loading or saving once ctx is done
+func (s *Store) UpdateCtx(ctx context.Context, username string, id int, text string) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
 	s.Lock()
 	defer s.Unlock()
 
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
 	todo, ok := userTodos.Todos[id]
 	if !ok {
 		return nil, fmt.Errorf("todo with ID %d not found", id)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	prev := *todo
 
 	todo.Text = text
-	todo.UpdatedAt = time.Now()
+	todo.UpdatedAt = s.timestamp()
+	todo.TextUpdatedAt = todo.UpdatedAt
 
-	// Save to disk
+	// Save to disk, undoing the change if that fails
 	if err := s.saveTodos(username); err != nil {
+		*todo = prev
 		return nil, err
 	}
 
-	return todo, nil
+	s.publish(EventUpdated, username, id)
+	return todo.clone(), nil
 }
 
-// Delete deletes the todo with the specified ID for the specified user
-func (s *Store) Delete(username string, id int) error {
-	userTodos, err := s.getUserTodos(username)
+// modify applies change to the todo with the specified ID for the specified
+// user and saves, undoing the change if the save fails
+func (s *Store) modify(username string, id int, change func(todo *Todo)) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return nil, fmt.Errorf("todo with ID %d not found", id)
+	}
+	prev := *todo
+
+	change(todo)
+	todo.UpdatedAt = s.timestamp()
+
+	// Save to disk, undoing the change if that fails
+	if err := s.saveTodos(username); err != nil {
+		*todo = prev
+		return nil, err
+	}
+
+	s.publish(EventUpdated, username, id)
+	return todo.clone(), nil
+}
+
+// SetDueDate sets or clears (with nil) the due date of the todo with the specified ID for the specified user
+func (s *Store) SetDueDate(username string, id int, due *time.Time) (*Todo, error) {
+	if due != nil {
+		d := due.UTC()
+		due = &d
+	}
+	return s.modify(username, id, func(todo *Todo) {
+		todo.DueAt = due
+	})
+}
+
+// SetPriority sets the priority of the todo with the specified ID for the specified user
+func (s *Store) SetPriority(username string, id int, priority int) (*Todo, error) {
+	return s.modify(username, id, func(todo *Todo) {
+		todo.Priority = priority
+	})
+}
+
+// SetTags replaces the tags of the todo with the specified ID for the specified user.
+// Tags are trimmed, and empty or duplicate tags are dropped.
+func (s *Store) SetTags(username string, id int, tags []string) (*Todo, error) {
+	cleaned := normalizeTags(tags)
+	return s.modify(username, id, func(todo *Todo) {
+		todo.Tags = cleaned
+	})
+}
+
+// SetNotes sets the free-form notes of the todo with the specified ID for the specified user
+func (s *Store) SetNotes(username string, id int, notes string) (*Todo, error) {
+	return s.modify(username, id, func(todo *Todo) {
+		todo.Notes = notes
+	})
+}
+
+// normalizeTags trims tags and removes empty and duplicate (case-insensitive) entries
+func normalizeTags(tags []string) []string {
+	var cleaned []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, tag)
+	}
+	return cleaned
+}
+
+// HasTag reports whether the todo carries the tag, ignoring case
+func (t *Todo) HasTag(tag string) bool {
+	for _, existing := range t.Tags {
+		if strings.EqualFold(existing, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete deletes the todo with the specified ID for the specified user,
+// returning a copy of the removed todo
+func (s *Store) Delete(username string, id int) (*Todo, error) {
+	return s.DeleteCtx(context.Background(), username, id)
+}
+
+// DeleteCtx is like Delete but gives up before loading or saving once ctx is done
+func (s *Store) DeleteCtx(ctx context.Context, username string, id int) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	s.Lock()
 	defer s.Unlock()
 
-	if _, ok := userTodos.Todos[id]; !ok {
-		return fmt.Errorf("todo with ID %d not found", id)
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
+	todo, ok := userTodos.Todos[id]
+	if !ok {
+		return nil, fmt.Errorf("todo with ID %d not found", id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	delete(userTodos.Todos, id)
 
-	// Save to disk
-	return s.saveTodos(username)
+	// Save to disk, restoring the todo if that fails
+	if err := s.saveTodos(username); err != nil {
+		userTodos.Todos[id] = todo
+		return nil, err
+	}
+
+	s.publish(EventDeleted, username, id)
+	s.recordActivity(username, ActivityDeleted, todo)
+	deleted := *todo
+	return &deleted, nil
+}
+
+// DeleteUser removes all todos of the specified user, including their file.
+// With a deletion archive directory set, a copy of the file is kept there
+// first, and nothing is removed if that fails.
+func (s *Store) DeleteUser(username string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	path, err := s.todosPath(username)
+	if err != nil {
+		return err
+	}
+	if err := s.keepDeleted(username); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove todos file: %v", err)
+	}
+	delete(s.userTodos, username)
+	delete(s.addBuckets, username)
+	delete(s.activity, username)
+	delete(s.unsaved, username)
+	return nil
+}
+
+// HasTodoFile reports whether a todos file exists for the specified user
+func (s *Store) HasTodoFile(username string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	path, err := s.todosPath(username)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// ArchiveUser moves the specified user's todos file aside, so a new account
+// with the same name starts with an empty list, and returns where it went.
+// The file keeps its contents and can be moved back by hand.
+func (s *Store) ArchiveUser(username string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	path, err := s.todosPath(username)
+	if err != nil {
+		return "", err
+	}
+	archived := path + ".orphaned-" + s.now().Format("20060102-150405")
+	if err := os.Rename(path, archived); err != nil {
+		return "", fmt.Errorf("failed to archive todos file: %v", err)
+	}
+	delete(s.userTodos, username)
+	delete(s.unsaved, username)
+	return archived, nil
 }
 
 // ToggleComplete toggles the completed status of the todo with the specified ID for the specified user
 func (s *Store) ToggleComplete(username string, id int) (*Todo, error) {
-	userTodos, err := s.getUserTodos(username)
-	if err != nil {
+	return s.ToggleCompleteCtx(context.Background(), username, id)
+}
+
+// ToggleCompleteCtx is like ToggleComplete but gives up before loading or saving once ctx is done
+func (s *Store) ToggleCompleteCtx(ctx context.Context, username string, id int) (*Todo, error) {
+	if id < 1 {
+		return nil, ErrInvalidID
+	}
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
 
 	s.Lock()
 	defer s.Unlock()
 
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return nil, err
+	}
+
 	todo, ok := userTodos.Todos[id]
 	if !ok {
 		return nil, fmt.Errorf("todo with ID %d not found", id)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !todo.Completed && s.enforceBlockers && blocked(userTodos, todo) {
+		return nil, ErrBlocked
+	}
+	prev := *todo
+	prevCompletedCount := userTodos.CompletedCount
 
 	todo.Completed = !todo.Completed
-	todo.UpdatedAt = time.Now()
+	todo.UpdatedAt = s.timestamp()
+	todo.CompletedAt = nil
+	if todo.Completed {
+		completedAt := todo.UpdatedAt
+		todo.CompletedAt = &completedAt
+		userTodos.CompletedCount++
+	}
 
-	// Save to disk
+	// Save to disk, undoing the change if that fails
 	if err := s.saveTodos(username); err != nil {
+		*todo = prev
+		userTodos.CompletedCount = prevCompletedCount
+		return nil, err
+	}
+
+	s.publish(EventToggled, username, id)
+	s.recordActivity(username, toggleAction(todo), todo)
+	return todo.clone(), nil
+}
+
+// ToggleByTag sets the completed status of every todo carrying the tag to
+// completed, returning how many todos changed. Blocked todos are skipped
+// while blockers are enforced. All changes are saved at once and undone
+// together if saving fails.
+func (s *Store) ToggleByTag(username, tag string, completed bool) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return 0, err
+	}
+
+	// Decide what is blocked before changing anything, so the result
+	// doesn't depend on the order todos are visited in
+	var skip map[int]bool
+	if completed && s.enforceBlockers {
+		skip = make(map[int]bool)
+		for _, todo := range userTodos.Todos {
+			if blocked(userTodos, todo) {
+				skip[todo.ID] = true
+			}
+		}
+	}
+
+	var changed []*Todo
+	now := s.timestamp()
+	prev := make(map[*Todo]Todo)
+	for _, todo := range userTodos.Todos {
+		if todo.Completed == completed || !todo.HasTag(tag) || skip[todo.ID] {
+			continue
+		}
+		prev[todo] = *todo
+		todo.Completed = completed
+		todo.UpdatedAt = now
+		todo.CompletedAt = nil
+		if completed {
+			// Each todo gets its own copy, so changing one later can't
+			// change the others
+			completedAt := now
+			todo.CompletedAt = &completedAt
+		}
+		changed = append(changed, todo)
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	if completed {
+		userTodos.CompletedCount += len(changed)
+	}
+
+	// Save to disk, undoing every change if that fails
+	if err := s.saveTodos(username); err != nil {
+		for _, todo := range changed {
+			*todo = prev[todo]
+		}
+		if completed {
+			userTodos.CompletedCount -= len(changed)
+		}
+		return 0, err
+	}
+
+	ids := make([]int, len(changed))
+	for i, todo := range changed {
+		ids[i] = todo.ID
+		s.recordActivity(username, toggleAction(todo), todo)
+	}
+	s.publish(EventToggled, username, ids...)
+	return len(changed), nil
+}
+
+// ExportOptions selects which todos an export includes
+type ExportOptions struct {
+	IncludeCompleted bool   // Include completed todos as well as active ones
+	Tag              string // Only include todos with this tag, ignoring case; empty includes all
+}
+
+// DefaultExportOptions export every todo
+var DefaultExportOptions = ExportOptions{IncludeCompleted: true}
+
+// includes reports whether the todo is selected by the options
+func (o ExportOptions) includes(todo *Todo) bool {
+	if todo.Completed && !o.IncludeCompleted {
+		return false
+	}
+	return o.Tag == "" || todo.HasTag(o.Tag)
+}
+
+// ExportJSON returns all todos for the specified user as a JSON array sorted
+// by ID, with timestamps in the store's time zone
+func (s *Store) ExportJSON(username string) ([]byte, error) {
+	return s.ExportJSONFiltered(username, DefaultExportOptions)
+}
+
+// ExportJSONFiltered is like ExportJSON but only exports the todos selected
+// by opts
+func (s *Store) ExportJSONFiltered(username string, opts ExportOptions) ([]byte, error) {
+	todos, err := s.listAll(username)
+	if err != nil {
 		return nil, err
 	}
 
-	return todo, nil
+	s.RLock()
+	defer s.RUnlock()
+
+	loc := s.zone()
+	exported := make([]*Todo, 0, len(todos))
+	for _, todo := range todos {
+		if opts.includes(todo) {
+			exported = append(exported, todo.In(loc))
+		}
+	}
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize todos: %v", err)
+	}
+	return data, nil
+}
+
+// ImportJSON loads todos from a JSON array as produced by ExportJSON.
+// With merge, imported todos are appended under newly assigned IDs;
+// otherwise they replace the user's existing todos, keeping their IDs.
+// Either way the imported todos keep their relative display order.
+func (s *Store) ImportJSON(username string, data []byte, merge bool) error {
+	var parsed []*Todo
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("failed to parse todos: %v", err)
+	}
+	imported := make([]*Todo, 0, len(parsed))
+	for _, todo := range parsed {
+		if todo != nil {
+			imported = append(imported, todo)
+		}
+	}
+	SortByPosition(imported)
+	return s.importTodos(username, imported, merge)
+}
+
+// importTodos adds imported, in order, to the user's todos or replaces them
+// with it, as described for ImportJSON
+func (s *Store) importTodos(username string, imported []*Todo, merge bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	userTodos, err := s.loadUserTodos(username)
+	if err != nil {
+		return err
+	}
+
+	// Keep the previous state so a failed save can be undone
+	prevTodos := userTodos.Todos
+	prevNextID := userTodos.NextID
+	prevCreatedCount := userTodos.CreatedCount
+	prevCompletedCount := userTodos.CompletedCount
+	userTodos.Todos = make(map[int]*Todo, len(prevTodos)+len(imported))
+	if merge {
+		for id, todo := range prevTodos {
+			userTodos.Todos[id] = todo
+		}
+	} else {
+		userTodos.NextID = 1
+	}
+	position := nextPosition(userTodos)
+
+	if !merge {
+		for _, todo := range imported {
+			if _, taken := userTodos.Todos[todo.ID]; todo.ID > 0 && !taken {
+				userTodos.Todos[todo.ID] = todo
+				if todo.ID >= userTodos.NextID {
+					userTodos.NextID = todo.ID + 1
+				}
+			}
+		}
+	}
+
+	ids := make(map[int]int, len(imported)) // exported ID -> imported ID
+	for _, todo := range imported {
+		if todo.TextUpdatedAt.IsZero() {
+			todo.TextUpdatedAt = todo.CreatedAt
+		}
+		exportedID := todo.ID
+		if merge || userTodos.Todos[todo.ID] != todo {
+			// Merged todos, and ones with missing or clashing IDs, get fresh IDs
+			todo.ID = userTodos.NextID
+			userTodos.Todos[todo.ID] = todo
+			userTodos.NextID++
+		}
+		if _, ok := ids[exportedID]; !ok {
+			ids[exportedID] = todo.ID
+		}
+		todo.Position = position
+		position++
+	}
+
+	// Point blockers at the imported todos' new IDs, dropping any that
+	// weren't part of the import
+	for _, todo := range imported {
+		var blockers []int
+		for _, blocker := range todo.BlockedBy {
+			if id, ok := ids[blocker]; ok && id != todo.ID {
+				blockers = append(blockers, id)
+			}
+		}
+		todo.BlockedBy = blockers
+	}
+	if merge {
+		userTodos.CreatedCount += len(imported)
+	} else {
+		seedCounters(userTodos)
+	}
+
+	// Save to disk, undoing the import if that fails
+	if err := s.saveTodos(username); err != nil {
+		userTodos.Todos = prevTodos
+		userTodos.NextID = prevNextID
+		userTodos.CreatedCount = prevCreatedCount
+		userTodos.CompletedCount = prevCompletedCount
+		return err
+	}
+
+	if !merge {
+		for id := range prevTodos {
+			s.publish(EventDeleted, username, id)
+		}
+	}
+	for _, todo := range imported {
+		s.publish(EventCreated, username, todo.ID)
+	}
+	return nil
 }
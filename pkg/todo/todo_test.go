@@ -21,12 +21,14 @@ The tests are designed to verify:
 package todo
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -62,6 +64,23 @@ func setupTestStore(t *testing.T) (*Store, string) {
 	return store, tempDir
 }
 
+// fakeClock is a manually advanced clock for tests that compare timestamps
+type fakeClock struct {
+	now time.Time
+}
+
+// useFakeClock makes the store read time from a fake clock starting now
+func useFakeClock(store *Store) *fakeClock {
+	clock := &fakeClock{now: time.Now()}
+	store.clock = func() time.Time { return clock.now }
+	return clock
+}
+
+// Advance moves the clock forward by d
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
 // cleanupTestStore removes the temporary directory.
 // This should be called after tests, typically in a defer statement.
 func cleanupTestStore(tempDir string) {
@@ -533,6 +552,7 @@ func TestAddWithMock(t *testing.T) {
 // - An empty list is returned for a new user
 // - All added todos are returned in the list
 // - The correct number of todos is returned
+// - Todos are sorted by ID
 func TestList(t *testing.T) {
 	store, tempDir := setupTestStore(t)
 	defer cleanupTestStore(tempDir)
@@ -558,6 +578,20 @@ func TestList(t *testing.T) {
 	if len(todos) != 3 {
 		t.Errorf("List() returned %d todos; want 3", len(todos))
 	}
+	for i, todo := range todos {
+		if todo.ID != i+1 {
+			t.Errorf("List()[%d].ID = %d; want %d", i, todo.ID, i+1)
+		}
+	}
+
+	// The unordered variant returns the same todos
+	unordered, err := store.ListUnordered(testUsername)
+	if err != nil {
+		t.Fatalf("ListUnordered() error = %v", err)
+	}
+	if len(unordered) != 3 {
+		t.Errorf("ListUnordered() returned %d todos; want 3", len(unordered))
+	}
 }
 
 // TestGet tests getting a todo by ID.
@@ -607,9 +641,10 @@ func TestUpdate(t *testing.T) {
 	}
 
 	// Add and update a todo
+	clock := useFakeClock(store)
 	todo, _ := store.Add(testUsername, "Original text")
 	originalUpdatedAt := todo.UpdatedAt
-	time.Sleep(time.Millisecond) // Ensure time difference
+	clock.Advance(time.Millisecond)
 
 	updated, err := store.Update(testUsername, todo.ID, "Updated text")
 	if err != nil {
@@ -627,23 +662,27 @@ func TestUpdate(t *testing.T) {
 // It verifies:
 // - Deleting a non-existent todo returns an error
 // - Deleting an existing todo removes it from the store
+// - The removed todo is returned
 // - Getting a deleted todo returns an error
 func TestDelete(t *testing.T) {
 	store, tempDir := setupTestStore(t)
 	defer cleanupTestStore(tempDir)
 
 	// Test deleting non-existent todo
-	err := store.Delete(testUsername, 1)
+	_, err := store.Delete(testUsername, 1)
 	if err == nil {
 		t.Error("Delete() non-existent todo; want error")
 	}
 
 	// Add and delete a todo
 	todo, _ := store.Add(testUsername, "Test todo")
-	err = store.Delete(testUsername, todo.ID)
+	deleted, err := store.Delete(testUsername, todo.ID)
 	if err != nil {
 		t.Fatalf("Delete() error = %v", err)
 	}
+	if deleted.ID != todo.ID || deleted.Text != "Test todo" {
+		t.Errorf("Delete() = %+v; want the removed todo", deleted)
+	}
 
 	// Verify todo was deleted
 	_, err = store.Get(testUsername, todo.ID)
@@ -669,9 +708,10 @@ func TestToggleComplete(t *testing.T) {
 	}
 
 	// Add and toggle a todo
+	clock := useFakeClock(store)
 	todo, _ := store.Add(testUsername, "Test todo")
 	originalUpdatedAt := todo.UpdatedAt
-	time.Sleep(time.Millisecond) // Ensure time difference
+	clock.Advance(time.Millisecond)
 
 	toggled, err := store.ToggleComplete(testUsername, todo.ID)
 	if err != nil {
@@ -861,7 +901,7 @@ func TestSimpleEndToEnd(t *testing.T) {
 	}
 
 	// Delete the todo
-	err = store.Delete(username, todo.ID)
+	_, err = store.Delete(username, todo.ID)
 	if err != nil {
 		t.Fatalf("Delete() error = %v", err)
 	}
@@ -1009,7 +1049,7 @@ func TestDeleteNonExistentTodo(t *testing.T) {
 	defer cleanupTestStore(tempDir)
 
 	// Try to delete non-existent todo
-	err := store.Delete(testUsername, 999)
+	_, err := store.Delete(testUsername, 999)
 	if err == nil {
 		t.Fatal("Delete() did not return error for non-existent todo")
 	}
@@ -1033,6 +1073,37 @@ func TestToggleCompleteNonExistentTodo(t *testing.T) {
 	}
 }
 
+// TestInvalidID verifies that IDs below 1 fail with ErrInvalidID rather
+// than a not found error, for every operation taking an ID
+func TestInvalidID(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	added, _ := store.Add(testUsername, "Test todo")
+
+	for _, id := range []int{0, -1} {
+		ops := map[string]func() error{
+			"Get":            func() error { _, err := store.Get(testUsername, id); return err },
+			"Update":         func() error { _, err := store.Update(testUsername, id, "Text"); return err },
+			"Delete":         func() error { _, err := store.Delete(testUsername, id); return err },
+			"ToggleComplete": func() error { _, err := store.ToggleComplete(testUsername, id); return err },
+			"SetNotes":       func() error { _, err := store.SetNotes(testUsername, id, "Notes"); return err },
+			"Patch":          func() error { _, err := store.Patch(testUsername, id, TodoPatch{}); return err },
+			"IsBlocked":      func() error { _, err := store.IsBlocked(testUsername, id); return err },
+			"Move":           func() error { return store.Move(testUsername, id, 1) },
+			"Snooze":         func() error { return store.Snooze(testUsername, id, time.Time{}) },
+			"SetBlockedBy":   func() error { return store.SetBlockedBy(testUsername, id, nil) },
+			"SetBlockedBy blocker": func() error {
+				return store.SetBlockedBy(testUsername, added.ID, []int{id})
+			},
+		}
+		for name, op := range ops {
+			if err := op(); err != ErrInvalidID {
+				t.Errorf("%s() with ID %d error = %v; want ErrInvalidID", name, id, err)
+			}
+		}
+	}
+}
+
 // TestNonExistentUserTodos verifies that operations with a non-existent user work correctly
 func TestNonExistentUserTodos(t *testing.T) {
 	store, tempDir := setupTestStore(t)
@@ -1265,6 +1336,7 @@ func TestToggleCompleteCycle(t *testing.T) {
 func TestUpdateTimestamps(t *testing.T) {
 	store, tempDir := setupTestStore(t)
 	defer cleanupTestStore(tempDir)
+	clock := useFakeClock(store)
 
 	// Add a todo
 	todo, err := store.Add(testUsername, "Timestamp test")
@@ -1274,9 +1346,11 @@ func TestUpdateTimestamps(t *testing.T) {
 
 	createdAt := todo.CreatedAt
 	updatedAt := todo.UpdatedAt
+	if !todo.TextUpdatedAt.Equal(createdAt) {
+		t.Errorf("TextUpdatedAt = %v; want CreatedAt %v", todo.TextUpdatedAt, createdAt)
+	}
 
-	// Sleep to ensure time difference
-	time.Sleep(10 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
 
 	// Update the todo
 	todo, err = store.Update(testUsername, todo.ID, "Updated text")
@@ -1289,13 +1363,16 @@ func TestUpdateTimestamps(t *testing.T) {
 		t.Errorf("CreatedAt changed after update: %v -> %v", createdAt, todo.CreatedAt)
 	}
 
-	// UpdatedAt should change
+	// UpdatedAt and TextUpdatedAt should change
 	if todo.UpdatedAt.Equal(updatedAt) {
 		t.Error("UpdatedAt did not change after update")
 	}
+	if !todo.TextUpdatedAt.Equal(todo.UpdatedAt) {
+		t.Errorf("TextUpdatedAt = %v after update; want %v", todo.TextUpdatedAt, todo.UpdatedAt)
+	}
+	textUpdatedAt := todo.TextUpdatedAt
 
-	// Sleep again
-	time.Sleep(10 * time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
 
 	// Toggle complete
 	todo, err = store.ToggleComplete(testUsername, todo.ID)
@@ -1308,10 +1385,13 @@ func TestUpdateTimestamps(t *testing.T) {
 		t.Errorf("CreatedAt changed after toggle: %v -> %v", createdAt, todo.CreatedAt)
 	}
 
-	// UpdatedAt should change again
+	// UpdatedAt should change again, but not TextUpdatedAt
 	if todo.UpdatedAt.Equal(updatedAt) {
 		t.Error("UpdatedAt did not change after toggle")
 	}
+	if !todo.TextUpdatedAt.Equal(textUpdatedAt) {
+		t.Errorf("TextUpdatedAt changed after toggle: %v -> %v", textUpdatedAt, todo.TextUpdatedAt)
+	}
 }
 
 // TestNewStoreDirectoryError verifies that an error is returned when creating the data directory fails
@@ -1476,7 +1556,7 @@ func TestErrorCases(t *testing.T) {
 		t.Fatalf("Failed to change file permissions: %v", err)
 	}
 
-	err = store.Delete(testUsername, todo.ID)
+	_, err = store.Delete(testUsername, todo.ID)
 
 	// Verify behavior
 	if err == nil {
@@ -1506,3 +1586,2234 @@ func TestErrorCases(t *testing.T) {
 	// Restore permissions
 	os.Chmod(todosPath, 0600)
 }
+
+// TestClassifyDue verifies due-date classification, including boundary cases
+func TestClassifyDue(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		due := now.Add(d)
+		return &due
+	}
+
+	tests := []struct {
+		name string
+		todo *Todo
+		want DueStatus
+	}{
+		{"nil todo", nil, DueNone},
+		{"no due date", &Todo{}, DueNone},
+		{"completed overdue", &Todo{Completed: true, DueAt: at(-time.Hour)}, DueNone},
+		{"past due", &Todo{DueAt: at(-time.Minute)}, DueOverdue},
+		{"exactly now", &Todo{DueAt: at(0)}, DueOverdue},
+		{"just after now", &Todo{DueAt: at(time.Nanosecond)}, DueSoon},
+		{"exactly one window ahead", &Todo{DueAt: at(DueSoonWindow)}, DueSoon},
+		{"beyond window", &Todo{DueAt: at(DueSoonWindow + time.Nanosecond)}, DueLater},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyDue(tt.todo, now); got != tt.want {
+				t.Errorf("ClassifyDue() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSetDueDate verifies that due dates can be set, persisted and cleared
+func TestSetDueDate(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Due date test")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	due := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	if _, err := store.SetDueDate(testUsername, todo.ID, &due); err != nil {
+		t.Fatalf("SetDueDate() error = %v", err)
+	}
+
+	// Reload from disk to verify persistence
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	loaded, err := store2.Get(testUsername, todo.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if loaded.DueAt == nil || !loaded.DueAt.Equal(due) {
+		t.Errorf("DueAt = %v; want %v", loaded.DueAt, due)
+	}
+
+	// Clear the due date
+	cleared, err := store.SetDueDate(testUsername, todo.ID, nil)
+	if err != nil {
+		t.Fatalf("SetDueDate(nil) error = %v", err)
+	}
+	if cleared.DueAt != nil {
+		t.Errorf("DueAt = %v after clearing; want nil", cleared.DueAt)
+	}
+
+	// Non-existent todo
+	if _, err := store.SetDueDate(testUsername, 999, &due); err == nil {
+		t.Error("SetDueDate() did not return error for non-existent todo")
+	}
+}
+
+// TestSetPriority verifies that a todo's priority can be changed
+func TestSetPriority(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Priority test")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if todo.Priority != 0 {
+		t.Errorf("new todo Priority = %d; want 0", todo.Priority)
+	}
+
+	todo, err = store.SetPriority(testUsername, todo.ID, 2)
+	if err != nil {
+		t.Fatalf("SetPriority() error = %v", err)
+	}
+	if todo.Priority != 2 {
+		t.Errorf("Priority = %d; want 2", todo.Priority)
+	}
+
+	if _, err := store.SetPriority(testUsername, 999, 1); err == nil {
+		t.Error("SetPriority() did not return error for non-existent todo")
+	}
+}
+
+// TestExportImportJSON verifies JSON export and both import modes
+func TestExportImportJSON(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	for _, text := range []string{"First", "Second", "Third"} {
+		if _, err := store.Add(testUsername, text); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if _, err := store.ToggleComplete(testUsername, 2); err != nil {
+		t.Fatalf("ToggleComplete() error = %v", err)
+	}
+
+	data, err := store.ExportJSON(testUsername)
+	if err != nil {
+		t.Fatalf("ExportJSON() error = %v", err)
+	}
+
+	var exported []*Todo
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	if len(exported) != 3 {
+		t.Fatalf("exported %d todos; want 3", len(exported))
+	}
+	for i, todo := range exported {
+		if todo.ID != i+1 {
+			t.Errorf("exported[%d].ID = %d; want %d", i, todo.ID, i+1)
+		}
+	}
+
+	// Replace into another user keeps IDs and completion
+	if err := store.ImportJSON(testUsername2, data, false); err != nil {
+		t.Fatalf("ImportJSON(replace) error = %v", err)
+	}
+	todo, err := store.Get(testUsername2, 2)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if todo.Text != "Second" || !todo.Completed {
+		t.Errorf("imported todo = %+v; want completed %q", todo, "Second")
+	}
+
+	// Merge appends with fresh IDs
+	if err := store.ImportJSON(testUsername2, data, true); err != nil {
+		t.Fatalf("ImportJSON(merge) error = %v", err)
+	}
+	todos, err := store.List(testUsername2)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(todos) != 6 {
+		t.Errorf("got %d todos after merge; want 6", len(todos))
+	}
+	if added, err := store.Add(testUsername2, "After merge"); err != nil || added.ID != 7 {
+		t.Errorf("Add() after merge = %v, %v; want ID 7", added, err)
+	}
+
+	// Invalid JSON is rejected
+	if err := store.ImportJSON(testUsername2, []byte("not json"), false); err == nil {
+		t.Error("ImportJSON() did not return error for invalid JSON")
+	}
+}
+
+// TestAddRateLimit verifies the per-user todo creation rate limit
+func TestAddRateLimit(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	clock := useFakeClock(store)
+	store.SetAddRate(1, 2)
+
+	// The burst is allowed
+	for i := 0; i < 2; i++ {
+		if _, err := store.Add(testUsername, fmt.Sprintf("Burst %d", i)); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	// The next one exceeds the limit
+	if _, err := store.Add(testUsername, "Too fast"); err != ErrRateLimited {
+		t.Errorf("Add() error = %v; want ErrRateLimited", err)
+	}
+
+	// Other users have their own bucket
+	if _, err := store.Add(testUsername2, "Other user"); err != nil {
+		t.Errorf("Add() for other user error = %v", err)
+	}
+
+	// A token is refilled after a minute, and an add that isn't saved
+	// doesn't use it up
+	clock.Advance(time.Minute)
+	store.SetReadOnly(true)
+	if _, err := store.Add(testUsername, "Not saved"); err != ErrReadOnly {
+		t.Errorf("Add() while read-only error = %v; want ErrReadOnly", err)
+	}
+	store.SetReadOnly(false)
+	if _, err := store.Add(testUsername, "After waiting"); err != nil {
+		t.Errorf("Add() after refill error = %v", err)
+	}
+
+	// Removing the limit allows unlimited adds again
+	store.SetAddRate(0, 0)
+	for i := 0; i < 5; i++ {
+		if _, err := store.Add(testUsername, "Unlimited"); err != nil {
+			t.Fatalf("Add() without limit error = %v", err)
+		}
+	}
+}
+
+// TestSaveFailureRollback verifies that mutations are undone in memory when
+// saving fails, e.g. because the disk is full
+func TestSaveFailureRollback(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Original")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	onDisk, err := os.ReadFile(filepath.Join(tempDir, "todos", testUsername+".json"))
+	if err != nil {
+		t.Fatalf("Failed to read todos file: %v", err)
+	}
+
+	// Inject a backend that always fails
+	store.writeFile = func(string, []byte, os.FileMode) error {
+		return fmt.Errorf("no space left on device")
+	}
+
+	if _, err := store.Add(testUsername, "Lost"); err == nil {
+		t.Error("Add() did not return error when save failed")
+	}
+	if _, err := store.Update(testUsername, todo.ID, "Changed"); err == nil {
+		t.Error("Update() did not return error when save failed")
+	}
+	if _, err := store.ToggleComplete(testUsername, todo.ID); err == nil {
+		t.Error("ToggleComplete() did not return error when save failed")
+	}
+	if _, err := store.SetPriority(testUsername, todo.ID, 3); err == nil {
+		t.Error("SetPriority() did not return error when save failed")
+	}
+	if _, err := store.Delete(testUsername, todo.ID); err == nil {
+		t.Error("Delete() did not return error when save failed")
+	}
+	if err := store.ImportJSON(testUsername, []byte(`[{"text":"Imported"}]`), true); err == nil {
+		t.Error("ImportJSON() did not return error when save failed")
+	}
+
+	// Memory is unchanged
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(todos) != 1 {
+		t.Fatalf("List() returned %d todos; want 1", len(todos))
+	}
+	got := todos[0]
+	if got.Text != "Original" || got.Completed || got.Priority != 0 {
+		t.Errorf("todo = %+v; want unchanged original", got)
+	}
+	if !got.UpdatedAt.Equal(todo.UpdatedAt) {
+		t.Errorf("UpdatedAt changed: %v -> %v", todo.UpdatedAt, got.UpdatedAt)
+	}
+
+	// Disk is unchanged and no temporary file is left behind
+	data, err := os.ReadFile(filepath.Join(tempDir, "todos", testUsername+".json"))
+	if err != nil {
+		t.Fatalf("Failed to read todos file: %v", err)
+	}
+	if string(data) != string(onDisk) {
+		t.Error("Todos file changed despite failed saves")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "todos", testUsername+".json.tmp")); !os.IsNotExist(err) {
+		t.Error("Temporary file left behind after failed save")
+	}
+
+	// Once the backend recovers, the next ID continues where it left off
+	store.writeFile = nil
+	added, err := store.Add(testUsername, "Recovered")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if added.ID != 2 {
+		t.Errorf("Add() ID = %d; want 2", added.ID)
+	}
+}
+
+// todoTexts returns the texts of the given todos in display order
+func todoTexts(todos []*Todo) []string {
+	SortByPosition(todos)
+	texts := make([]string, len(todos))
+	for i, todo := range todos {
+		texts[i] = todo.Text
+	}
+	return texts
+}
+
+// TestMoveAndAddMany verifies reordering and batch adds
+func TestMoveAndAddMany(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	added, err := store.AddMany(testUsername, []string{"A", "B", "C"})
+	if err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+	if len(added) != 3 || added[2].ID != 3 {
+		t.Fatalf("AddMany() = %v; want 3 todos with IDs 1..3", added)
+	}
+
+	// Move C to the top, then A down past the end (clamped)
+	if err := store.Move(testUsername, 3, -5); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if err := store.Move(testUsername, 1, 10); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if err := store.Move(testUsername, 99, 1); err == nil {
+		t.Error("Move() did not return error for non-existent todo")
+	}
+
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if got := strings.Join(todoTexts(todos), ","); got != "C,B,A" {
+		t.Errorf("display order = %s; want C,B,A", got)
+	}
+
+	// New todos go to the end
+	if _, err := store.Add(testUsername, "D"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	todos, _ = store.List(testUsername)
+	if got := strings.Join(todoTexts(todos), ","); got != "C,B,A,D" {
+		t.Errorf("display order = %s; want C,B,A,D", got)
+	}
+}
+
+// TestImportPreservesOrder verifies that an export/import round-trip keeps the display order
+func TestImportPreservesOrder(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, err := store.AddMany(testUsername, []string{"First", "Second", "Third", "Fourth"}); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+	// Arrange: Fourth, Second, First, Third
+	if err := store.Move(testUsername, 4, -3); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if err := store.Move(testUsername, 1, 1); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	todos, _ := store.List(testUsername)
+	want := strings.Join(todoTexts(todos), ",")
+	if want != "Fourth,Second,First,Third" {
+		t.Fatalf("arranged order = %s; want Fourth,Second,First,Third", want)
+	}
+
+	data, err := store.ExportJSON(testUsername)
+	if err != nil {
+		t.Fatalf("ExportJSON() error = %v", err)
+	}
+
+	for _, merge := range []bool{true, false} {
+		target := fmt.Sprintf("import-merge-%v", merge)
+		if err := store.ImportJSON(target, data, merge); err != nil {
+			t.Fatalf("ImportJSON(merge=%v) error = %v", merge, err)
+		}
+		imported, err := store.List(target)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if got := strings.Join(todoTexts(imported), ","); got != want {
+			t.Errorf("imported order (merge=%v) = %s; want %s", merge, got, want)
+		}
+	}
+}
+
+// TestSetTagsAndNotes verifies tag normalization and notes persistence
+func TestSetTagsAndNotes(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Tagged")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	todo, err = store.SetTags(testUsername, todo.ID, []string{" work ", "", "Work", "home"})
+	if err != nil {
+		t.Fatalf("SetTags() error = %v", err)
+	}
+	if got := strings.Join(todo.Tags, ","); got != "work,home" {
+		t.Errorf("Tags = %s; want work,home", got)
+	}
+	if !todo.HasTag("HOME") || todo.HasTag("garden") {
+		t.Error("HasTag() returned wrong result")
+	}
+
+	if _, err := store.SetNotes(testUsername, todo.ID, "Call Bob first"); err != nil {
+		t.Fatalf("SetNotes() error = %v", err)
+	}
+
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	loaded, err := store2.Get(testUsername, todo.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(loaded.Tags) != 2 || loaded.Notes != "Call Bob first" {
+		t.Errorf("loaded todo = %+v; want tags and notes persisted", loaded)
+	}
+
+	if _, err := store.SetTags(testUsername, 999, nil); err == nil {
+		t.Error("SetTags() did not return error for non-existent todo")
+	}
+	if _, err := store.SetNotes(testUsername, 999, ""); err == nil {
+		t.Error("SetNotes() did not return error for non-existent todo")
+	}
+}
+
+// TestSearch verifies matching against text, tags and notes
+func TestSearch(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.Add(testUsername, "Write WORK report")   // 1: text
+	store.Add(testUsername, "Buy milk")            // 2: tag
+	store.Add(testUsername, "Fix bike")            // 3: notes
+	store.Add(testUsername, "Unrelated")           // 4: no match
+	store.Add(testUsername, "Homework assignment") // 5: text
+	store.SetTags(testUsername, 2, []string{"Work"})
+	store.SetNotes(testUsername, 3, "needed for work commute")
+
+	ids := func(todos []*Todo) string {
+		parts := make([]string, len(todos))
+		for i, todo := range todos {
+			parts[i] = fmt.Sprint(todo.ID)
+		}
+		return strings.Join(parts, ",")
+	}
+
+	tests := []struct {
+		name   string
+		fields SearchField
+		want   string
+	}{
+		{"text only", SearchText, "1,5"},
+		{"tags only", SearchTags, "2"},
+		{"notes only", SearchNotes, "3"},
+		{"text and tags", SearchText | SearchTags, "1,2,5"},
+		{"all fields", SearchAllFields, "1,2,3,5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.SearchAll(testUsername, "work", tt.fields)
+			if err != nil {
+				t.Fatalf("SearchAll() error = %v", err)
+			}
+			if ids(got) != tt.want {
+				t.Errorf("SearchAll() IDs = %s; want %s", ids(got), tt.want)
+			}
+		})
+	}
+
+	got, err := store.Search(testUsername, "WORK")
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if ids(got) != "1,5" {
+		t.Errorf("Search() IDs = %s; want 1,5", ids(got))
+	}
+
+	got, err = store.Search(nonExistentUser, "anything")
+	if err != nil || len(got) != 0 {
+		t.Errorf("Search() for unknown user = %v, %v; want empty", got, err)
+	}
+}
+
+// TestToggleByTag verifies bulk completion by tag
+func TestToggleByTag(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"A", "B", "C"})
+	store.SetTags(testUsername, 1, []string{"project"})
+	store.SetTags(testUsername, 2, []string{"Project", "other"})
+	store.ToggleComplete(testUsername, 2)
+
+	// No todos have the tag
+	n, err := store.ToggleByTag(testUsername, "missing", true)
+	if err != nil || n != 0 {
+		t.Errorf("ToggleByTag(missing) = %d, %v; want 0, nil", n, err)
+	}
+
+	// Only the incomplete tagged todo changes
+	n, err = store.ToggleByTag(testUsername, "project", true)
+	if err != nil || n != 1 {
+		t.Errorf("ToggleByTag(project, true) = %d, %v; want 1, nil", n, err)
+	}
+
+	// Failed saves undo everything
+	store.writeFile = func(string, []byte, os.FileMode) error {
+		return fmt.Errorf("disk full")
+	}
+	if _, err := store.ToggleByTag(testUsername, "project", false); err == nil {
+		t.Error("ToggleByTag() did not return error when save failed")
+	}
+	store.writeFile = nil
+
+	for id, want := range map[int]bool{1: true, 2: true, 3: false} {
+		todo, err := store.Get(testUsername, id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if todo.Completed != want {
+			t.Errorf("todo %d Completed = %v; want %v", id, todo.Completed, want)
+		}
+	}
+
+	n, err = store.ToggleByTag(testUsername, "PROJECT", false)
+	if err != nil || n != 2 {
+		t.Errorf("ToggleByTag(PROJECT, false) = %d, %v; want 2, nil", n, err)
+	}
+}
+
+// TestTodoCopies verifies that todos handed out by the store share nothing
+// with the stored ones, and that todos completed together don't share a
+// completion time
+func TestTodoCopies(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"A", "B", "C"})
+	due := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	store.SetDueDate(testUsername, 1, &due)
+	store.SetTags(testUsername, 1, []string{"work"})
+	store.SetTags(testUsername, 2, []string{"work"})
+	if err := store.SetBlockedBy(testUsername, 1, []int{3}); err != nil {
+		t.Fatalf("SetBlockedBy() error = %v", err)
+	}
+	if _, err := store.ToggleByTag(testUsername, "work", true); err != nil {
+		t.Fatalf("ToggleByTag() error = %v", err)
+	}
+
+	got, err := store.Get(testUsername, 1)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	*got.DueAt = got.DueAt.Add(time.Hour)
+	*got.CompletedAt = time.Time{}
+	got.Tags[0] = "changed"
+	got.BlockedBy[0] = 99
+
+	stored, _ := store.Get(testUsername, 1)
+	if !stored.DueAt.Equal(due) || stored.CompletedAt.IsZero() ||
+		stored.Tags[0] != "work" || stored.BlockedBy[0] != 3 {
+		t.Errorf("stored todo = %+v; want it unchanged by editing a copy", stored)
+	}
+
+	store.RLock()
+	defer store.RUnlock()
+	todos := store.userTodos[testUsername].Todos
+	if todos[1].CompletedAt == nil || todos[1].CompletedAt == todos[2].CompletedAt {
+		t.Error("todos completed by ToggleByTag share a completion time")
+	}
+}
+
+// TestStats verifies live counts and lifetime counters
+func TestStats(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"A", "B"})
+	store.Add(testUsername, "C")
+	store.ToggleComplete(testUsername, 1)
+	store.ToggleComplete(testUsername, 1) // Uncompleting doesn't decrease the counter
+	store.ToggleComplete(testUsername, 2)
+	store.Delete(testUsername, 2)
+
+	stats, err := store.Stats(testUsername)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	want := Stats{Total: 2, Completed: 0, Pending: 2, TotalCreated: 3, TotalCompleted: 2}
+	if stats != want {
+		t.Errorf("Stats() = %+v; want %+v", stats, want)
+	}
+
+	// Counters persist
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if stats, _ := store2.Stats(testUsername); stats != want {
+		t.Errorf("Stats() after reload = %+v; want %+v", stats, want)
+	}
+
+	// Files written before the counters existed are seeded from their contents
+	legacy := `{"todos":{"4":{"id":4,"text":"Old","completed":true}},"next_id":5}`
+	if err := os.WriteFile(filepath.Join(tempDir, "todos", "legacy.json"), []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+	stats, err = store2.Stats("legacy")
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.TotalCreated != 4 || stats.TotalCompleted != 1 {
+		t.Errorf("legacy Stats() = %+v; want TotalCreated 4, TotalCompleted 1", stats)
+	}
+}
+
+// TestSharding verifies that the sharded layout stores files under a hash
+// prefix directory and that switching layouts migrates existing files
+func TestSharding(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	flatPath := filepath.Join(tempDir, "todos", testUsername+".json")
+	shardPath := filepath.Join(tempDir, "todos", shardFor(testUsername), testUsername+".json")
+
+	// Flat layout is the default
+	if _, err := store.Add(testUsername, "Flat"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := os.Stat(flatPath); err != nil {
+		t.Fatalf("flat file missing: %v", err)
+	}
+
+	// Enabling sharding moves the existing file
+	if err := store.SetSharded(true); err != nil {
+		t.Fatalf("SetSharded(true) error = %v", err)
+	}
+	if _, err := os.Stat(flatPath); !os.IsNotExist(err) {
+		t.Errorf("flat file still present after migration")
+	}
+	if _, err := os.Stat(shardPath); err != nil {
+		t.Fatalf("sharded file missing: %v", err)
+	}
+
+	// New users are written to their shard and reloaded from it
+	if _, err := store.Add("other", "Sharded"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if err := store2.SetSharded(true); err != nil {
+		t.Fatalf("SetSharded(true) error = %v", err)
+	}
+	for _, username := range []string{testUsername, "other"} {
+		todos, err := store2.List(username)
+		if err != nil || len(todos) != 1 {
+			t.Errorf("List(%s) = %v, %v; want 1 todo", username, todos, err)
+		}
+	}
+
+	// Switching back restores the flat layout
+	if err := store2.SetSharded(false); err != nil {
+		t.Fatalf("SetSharded(false) error = %v", err)
+	}
+	if _, err := os.Stat(flatPath); err != nil {
+		t.Errorf("flat file missing after switching back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(shardPath)); !os.IsNotExist(err) {
+		t.Errorf("empty shard directory was not removed")
+	}
+}
+
+// TestConcurrentFirstLoad verifies that concurrent first accesses to a user
+// share a single cached copy of their todos
+func TestConcurrentFirstLoad(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, err := store.Add(testUsername, "Persisted"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	const readers = 16
+	results := make(chan *UserTodos, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userTodos, err := store2.getUserTodos(testUsername)
+			if err != nil {
+				t.Errorf("getUserTodos() error = %v", err)
+			}
+			results <- userTodos
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	first := <-results
+	for userTodos := range results {
+		if userTodos != first {
+			t.Fatal("getUserTodos() returned different copies for concurrent first loads")
+		}
+	}
+	if len(first.Todos) != 1 {
+		t.Errorf("loaded %d todos; want 1", len(first.Todos))
+	}
+}
+
+// BenchmarkListParallel measures List throughput with many concurrent
+// readers of already cached users
+func BenchmarkListParallel(b *testing.B) {
+	tempDir, err := os.MkdirTemp("", "todoissh-bench")
+	if err != nil {
+		b.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	store, err := NewStore(tempDir)
+	if err != nil {
+		b.Fatalf("NewStore() error = %v", err)
+	}
+	texts := make([]string, 50)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("Todo %d", i)
+	}
+	if _, err := store.AddMany(testUsername, texts); err != nil {
+		b.Fatalf("AddMany() error = %v", err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := store.List(testUsername); err != nil {
+				b.Errorf("List() error = %v", err)
+			}
+		}
+	})
+}
+
+// TestDeleteUser verifies that all of a user's todos are removed, on disk too
+func TestDeleteUser(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, err := store.Add(testUsername, "Gone"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := store.DeleteUser(testUsername); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "todos", testUsername+".json")); !os.IsNotExist(err) {
+		t.Error("todos file still exists after DeleteUser()")
+	}
+	if todos, _ := store.List(testUsername); len(todos) != 0 {
+		t.Errorf("List() returned %d todos after DeleteUser(); want 0", len(todos))
+	}
+
+	// Deleting a user without todos is not an error
+	if err := store.DeleteUser("nobody"); err != nil {
+		t.Errorf("DeleteUser() error = %v for user without todos", err)
+	}
+}
+
+// TestDeletionArchive verifies that DeleteUser keeps a copy of the todos
+// file that can be loaded again, and deletes nothing if it can't
+func TestDeletionArchive(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	archiveDir := filepath.Join(tempDir, "deleted")
+	store.SetDeletionArchiveDir(archiveDir)
+
+	store.AddMany(testUsername, []string{"Keep me", "And me"})
+	if err := store.DeleteUser(testUsername); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	copies, _ := filepath.Glob(filepath.Join(archiveDir, testUsername, "todos-*.json"))
+	if len(copies) != 1 {
+		t.Fatalf("kept %d copies; want 1", len(copies))
+	}
+
+	// Moving the copy back restores the todos
+	os.Rename(copies[0], filepath.Join(tempDir, "todos", testUsername+".json"))
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if todos, _ := reloaded.List(testUsername); len(todos) != 2 || todos[0].Text != "Keep me" {
+		t.Errorf("List() after restoring = %v; want both todos back", todos)
+	}
+
+	// Without a copy nothing is deleted
+	reloaded.SetDeletionArchiveDir(filepath.Join(tempDir, "todos", testUsername+".json", "not-a-dir"))
+	if err := reloaded.DeleteUser(testUsername); err == nil {
+		t.Error("DeleteUser() succeeded without keeping a copy")
+	}
+	if !reloaded.HasTodoFile(testUsername) {
+		t.Error("todos file removed although no copy was kept")
+	}
+}
+
+// TestDuplicates verifies finding and merging todos with the same text
+func TestDuplicates(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"Buy milk", "Call mom", "  buy MILK ", "Walk dog", "call mom", "Buy milk"})
+	store.ToggleComplete(testUsername, 5)
+
+	groups, err := store.FindDuplicates(testUsername)
+	if err != nil {
+		t.Fatalf("FindDuplicates() error = %v", err)
+	}
+	if fmt.Sprint(groups) != "[[1 3 6] [2 5]]" {
+		t.Errorf("FindDuplicates() = %v; want [[1 3 6] [2 5]]", groups)
+	}
+
+	removed, err := store.MergeDuplicates(testUsername)
+	if err != nil {
+		t.Fatalf("MergeDuplicates() error = %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("MergeDuplicates() removed %d; want 3", removed)
+	}
+
+	todos, _ := store.List(testUsername)
+	var ids []int
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	if fmt.Sprint(ids) != "[1 2 4]" {
+		t.Errorf("remaining IDs = %v; want [1 2 4]", ids)
+	}
+	if kept, _ := store.Get(testUsername, 2); !kept.Completed {
+		t.Error("kept todo was not completed although a duplicate was")
+	}
+
+	if removed, err := store.MergeDuplicates(testUsername); err != nil || removed != 0 {
+		t.Errorf("second MergeDuplicates() = %d, %v; want 0, nil", removed, err)
+	}
+}
+
+// TestTimestampPrecision verifies that timestamps are truncated when a precision is set
+func TestTimestampPrecision(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	// A todo saved at full precision before the option was turned on
+	old, err := store.Add(testUsername, "Old todo")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	store.SetTimestampPrecision(time.Second)
+
+	todo, err := store.Add(testUsername, "Coarse todo")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if todo.CreatedAt.Nanosecond() != 0 || todo.UpdatedAt.Nanosecond() != 0 {
+		t.Errorf("Add() timestamps = %v, %v; want whole seconds", todo.CreatedAt, todo.UpdatedAt)
+	}
+	if todo, err = store.Update(testUsername, todo.ID, "Updated"); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if todo.UpdatedAt.Nanosecond() != 0 {
+		t.Errorf("Update() UpdatedAt = %v; want whole seconds", todo.UpdatedAt)
+	}
+	if todo, err = store.ToggleComplete(testUsername, todo.ID); err != nil {
+		t.Fatalf("ToggleComplete() error = %v", err)
+	}
+	if todo.UpdatedAt.Nanosecond() != 0 {
+		t.Errorf("ToggleComplete() UpdatedAt = %v; want whole seconds", todo.UpdatedAt)
+	}
+
+	// Existing high-precision timestamps load unchanged
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	got, err := reloaded.Get(testUsername, old.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(old.CreatedAt) {
+		t.Errorf("reloaded CreatedAt = %v; want %v", got.CreatedAt, old.CreatedAt)
+	}
+}
+
+// TestCancelledContext verifies that the context variants stop once the context is done
+func TestCancelledContext(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Keep me")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.AddCtx(ctx, testUsername, "Never added"); err != context.Canceled {
+		t.Errorf("AddCtx() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := store.ListCtx(ctx, testUsername); err != context.Canceled {
+		t.Errorf("ListCtx() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := store.GetCtx(ctx, testUsername, todo.ID); err != context.Canceled {
+		t.Errorf("GetCtx() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := store.UpdateCtx(ctx, testUsername, todo.ID, "Changed"); err != context.Canceled {
+		t.Errorf("UpdateCtx() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := store.ToggleCompleteCtx(ctx, testUsername, todo.ID); err != context.Canceled {
+		t.Errorf("ToggleCompleteCtx() error = %v; want %v", err, context.Canceled)
+	}
+	if _, err := store.DeleteCtx(ctx, testUsername, todo.ID); err != context.Canceled {
+		t.Errorf("DeleteCtx() error = %v; want %v", err, context.Canceled)
+	}
+
+	// Nothing changed, in memory or on disk
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	todos, err := reloaded.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(todos) != 1 || todos[0].Text != "Keep me" || todos[0].Completed {
+		t.Errorf("todos after cancelled calls = %+v; want only the unchanged original", todos)
+	}
+}
+
+// TestArchiveUser verifies that archiving moves a user's file aside and
+// leaves them with an empty list
+func TestArchiveUser(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if store.HasTodoFile(testUsername) {
+		t.Error("HasTodoFile() = true before any todo was added")
+	}
+	if _, err := store.Add(testUsername, "Private todo"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !store.HasTodoFile(testUsername) {
+		t.Error("HasTodoFile() = false after adding a todo")
+	}
+
+	archived, err := store.ArchiveUser(testUsername)
+	if err != nil {
+		t.Fatalf("ArchiveUser() error = %v", err)
+	}
+	if store.HasTodoFile(testUsername) {
+		t.Error("HasTodoFile() = true after archiving")
+	}
+	if data, err := os.ReadFile(archived); err != nil || !strings.Contains(string(data), "Private todo") {
+		t.Errorf("archived file %s does not hold the todos: %v", archived, err)
+	}
+	if todos, _ := store.List(testUsername); len(todos) != 0 {
+		t.Errorf("List() after archiving = %d todos; want 0", len(todos))
+	}
+
+	if _, err := store.ArchiveUser(testUsername2); err == nil {
+		t.Error("ArchiveUser() without a file succeeded; want error")
+	}
+}
+
+// TestPathTraversal verifies that usernames which would name a file outside
+// the todos directory are refused rather than read, written or moved
+func TestPathTraversal(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	usersPath := filepath.Join(tempDir, "users.json")
+	if err := os.WriteFile(usersPath, []byte("{}"), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+
+	for _, name := range []string{"../users", "..", ".hidden", "a/b", `a\b`, ""} {
+		if store.HasTodoFile(name) {
+			t.Errorf("HasTodoFile(%q) = true; want false", name)
+		}
+		if _, err := store.ArchiveUser(name); err != ErrInvalidUsername {
+			t.Errorf("ArchiveUser(%q) error = %v; want ErrInvalidUsername", name, err)
+		}
+		if _, err := store.Add(name, "Escaped"); err != ErrInvalidUsername {
+			t.Errorf("Add(%q) error = %v; want ErrInvalidUsername", name, err)
+		}
+		if err := store.DeleteUser(name); err != ErrInvalidUsername {
+			t.Errorf("DeleteUser(%q) error = %v; want ErrInvalidUsername", name, err)
+		}
+	}
+
+	if data, err := os.ReadFile(usersPath); err != nil || string(data) != "{}" {
+		t.Errorf("users file = %q, %v; want it untouched", data, err)
+	}
+}
+
+// TestFileFormat verifies that todos are saved as an array sorted by ID and
+// that files in the legacy map form still load and are converted on save
+func TestFileFormat(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	texts := make([]string, 12)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("Todo %d", i+1)
+	}
+	if _, err := store.AddMany(testUsername, texts); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "todos", testUsername+".json"))
+	if err != nil {
+		t.Fatalf("Failed to read todos file: %v", err)
+	}
+	var saved struct {
+		Todos []*Todo `json:"todos"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("todos are not saved as an array: %v", err)
+	}
+	for i, todo := range saved.Todos {
+		if todo.ID != i+1 {
+			t.Fatalf("saved todo %d has ID %d; want IDs in order", i, todo.ID)
+		}
+	}
+
+	// Legacy files keyed by ID
+	legacyPath := filepath.Join(tempDir, "todos", "legacy.json")
+	legacy := `{"todos":{"10":{"id":10,"text":"Ten"},"2":{"id":2,"text":"Two"}},"next_id":11}`
+	if err := os.WriteFile(legacyPath, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+	if _, err := store.Update("legacy", 2, "Two, updated"); err != nil {
+		t.Fatalf("Update() on legacy file error = %v", err)
+	}
+	data, err = os.ReadFile(legacyPath)
+	if err != nil {
+		t.Fatalf("Failed to read legacy file: %v", err)
+	}
+	saved.Todos = nil
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("legacy file was not converted to an array: %v", err)
+	}
+	if len(saved.Todos) != 2 || saved.Todos[0].Text != "Two, updated" || saved.Todos[1].ID != 10 {
+		t.Errorf("converted legacy todos = %+v; want Two then Ten", saved.Todos)
+	}
+}
+
+// TestPatch verifies that Patch changes only the fields it is given, in a
+// single save that is undone as a whole if it fails
+func TestPatch(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"Buy milk", "Walk dog"})
+	store.SetNotes(testUsername, 1, "Oat")
+	due := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	store.SetDueDate(testUsername, 1, &due)
+
+	text, completed, priority := "Buy bread", true, 2
+	tags := []string{" shop ", "Shop", "food"}
+	todo, err := store.Patch(testUsername, 1, TodoPatch{
+		Text:      &text,
+		Completed: &completed,
+		Priority:  &priority,
+		Tags:      &tags,
+	})
+	if err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if todo.Text != text || !todo.Completed || todo.CompletedAt == nil || todo.Priority != 2 {
+		t.Errorf("Patch() = %+v; want text, completion and priority changed", todo)
+	}
+	if len(todo.Tags) != 2 || todo.Tags[0] != "shop" || todo.Tags[1] != "food" {
+		t.Errorf("Patch() tags = %q; want [shop food]", todo.Tags)
+	}
+	if todo.Notes != "Oat" || todo.DueAt == nil || !todo.DueAt.Equal(due) {
+		t.Errorf("Patch() = %+v; want notes and due date untouched", todo)
+	}
+	if stats, _ := store.Stats(testUsername); stats.TotalCompleted != 1 {
+		t.Errorf("TotalCompleted = %d; want 1", stats.TotalCompleted)
+	}
+
+	// Clearing the due date
+	if todo, err = store.Patch(testUsername, 1, TodoPatch{ClearDue: true}); err != nil || todo.DueAt != nil {
+		t.Errorf("Patch() clearing due date = %+v, %v; want no due date", todo, err)
+	}
+
+	// A failed save leaves everything as it was
+	store.writeFile = func(string, []byte, os.FileMode) error {
+		return fmt.Errorf("disk full")
+	}
+	text, completed = "Lost", false
+	if _, err := store.Patch(testUsername, 1, TodoPatch{Text: &text, Completed: &completed}); err == nil {
+		t.Error("Patch() with a failing save succeeded")
+	}
+	store.writeFile = nil
+	if todo, _ := store.Get(testUsername, 1); todo.Text != "Buy bread" || !todo.Completed {
+		t.Errorf("todo after failed Patch() = %+v; want it unchanged", todo)
+	}
+
+	// Blocked todos can't be completed, even alongside other changes
+	store.SetBlockedBy(testUsername, 2, []int{1})
+	store.ToggleComplete(testUsername, 1)
+	store.SetEnforceBlockers(true)
+	text, completed = "Walk the dog", true
+	if _, err := store.Patch(testUsername, 2, TodoPatch{Text: &text, Completed: &completed}); err != ErrBlocked {
+		t.Errorf("Patch() completing a blocked todo error = %v; want ErrBlocked", err)
+	}
+	if todo, _ := store.Get(testUsername, 2); todo.Text != "Walk dog" {
+		t.Errorf("blocked todo text = %q; want it unchanged", todo.Text)
+	}
+
+	if _, err := store.Patch(testUsername, 99, TodoPatch{Text: &text}); err == nil {
+		t.Error("Patch() of a missing todo succeeded")
+	}
+}
+
+// TestListByStatus verifies that completed and pending todos are listed
+// apart in ID order, leaving out archived ones
+func TestListByStatus(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	for _, completed := range []bool{true, false} {
+		todos, err := store.ListByStatus(testUsername, completed)
+		if err != nil || todos == nil || len(todos) != 0 {
+			t.Errorf("ListByStatus(%v) with no todos = %v, %v; want an empty list", completed, todos, err)
+		}
+	}
+
+	clock := useFakeClock(store)
+	store.AddMany(testUsername, []string{"Pending", "Done", "Also done", "Archived", "Also pending"})
+	store.ToggleComplete(testUsername, 4)
+	clock.Advance(2 * time.Hour)
+	store.ToggleComplete(testUsername, 2)
+	store.ToggleComplete(testUsername, 3)
+	store.SetAutoArchiveAfter(time.Hour)
+	if n, err := store.AutoArchive(testUsername); err != nil || n != 1 {
+		t.Fatalf("AutoArchive() = %d, %v; want 1, nil", n, err)
+	}
+
+	ids := func(completed bool) string {
+		todos, err := store.ListByStatus(testUsername, completed)
+		if err != nil {
+			t.Fatalf("ListByStatus(%v) error = %v", completed, err)
+		}
+		var ids []int
+		for _, todo := range todos {
+			ids = append(ids, todo.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+	if got := ids(true); got != "[2 3]" {
+		t.Errorf("ListByStatus(true) IDs = %s; want [2 3]", got)
+	}
+	if got := ids(false); got != "[1 5]" {
+		t.Errorf("ListByStatus(false) IDs = %s; want [1 5]", got)
+	}
+}
+
+// TestSnooze verifies that snoozed todos leave the list until their time
+// passes, show up in ListSnoozed meanwhile, and are still exported
+func TestSnooze(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	clock := useFakeClock(store)
+
+	store.AddMany(testUsername, []string{"First", "Later", "Much later"})
+	if err := store.Snooze(testUsername, 2, clock.now.Add(time.Hour)); err != nil {
+		t.Fatalf("Snooze() error = %v", err)
+	}
+	store.Snooze(testUsername, 3, clock.now.Add(48*time.Hour))
+	if err := store.Snooze(testUsername, 99, clock.now.Add(time.Hour)); err == nil {
+		t.Error("Snooze() of a missing todo succeeded")
+	}
+
+	ids := func(todos []*Todo, err error) string {
+		if err != nil {
+			t.Fatalf("listing error = %v", err)
+		}
+		var ids []int
+		for _, todo := range todos {
+			ids = append(ids, todo.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+	if got := ids(store.List(testUsername)); got != "[1]" {
+		t.Errorf("List() IDs = %s; want [1]", got)
+	}
+	if got := ids(store.ListSnoozed(testUsername)); got != "[2 3]" {
+		t.Errorf("ListSnoozed() IDs = %s; want [2 3]", got)
+	}
+	var exported []*Todo
+	data, _ := store.ExportJSON(testUsername)
+	if err := json.Unmarshal(data, &exported); err != nil || len(exported) != 3 {
+		t.Errorf("ExportJSON() has %d todos, %v; want all 3", len(exported), err)
+	}
+
+	// Todos come back once their time passes, or when woken
+	clock.Advance(2 * time.Hour)
+	if got := ids(store.List(testUsername)); got != "[1 2]" {
+		t.Errorf("List() IDs after an hour = %s; want [1 2]", got)
+	}
+	if err := store.Snooze(testUsername, 3, time.Time{}); err != nil {
+		t.Fatalf("Snooze() to wake error = %v", err)
+	}
+	if got := ids(store.ListSnoozed(testUsername)); got != "[]" {
+		t.Errorf("ListSnoozed() IDs after waking = %s; want none", got)
+	}
+}
+
+// TestListToday verifies which todos are in the today view, using the
+// configured time zone to decide where days start
+func TestListToday(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	clock := useFakeClock(store)
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	store.SetLocation(loc)
+
+	at := func(day, hour int) *time.Time {
+		tm := time.Date(2025, 5, day, hour, 0, 0, 0, loc)
+		return &tm
+	}
+
+	// Created yesterday (local time)
+	clock.now = time.Date(2025, 4, 30, 12, 0, 0, 0, loc)
+	store.AddMany(testUsername, []string{"No due date", "Due today", "Overdue", "Overdue but done", "Due tomorrow"})
+	store.SetDueDate(testUsername, 2, at(1, 18))
+	yesterday := time.Date(2025, 4, 30, 9, 0, 0, 0, loc)
+	store.SetDueDate(testUsername, 3, &yesterday)
+	store.SetDueDate(testUsername, 4, &yesterday)
+	store.ToggleComplete(testUsername, 4)
+	store.SetDueDate(testUsername, 5, at(2, 9))
+
+	// Created today: 01:00 local is still the previous day in UTC
+	clock.now = *at(1, 1)
+	store.Add(testUsername, "Just after midnight")
+	clock.now = *at(1, 12)
+	store.Add(testUsername, "Created and done today")
+	store.ToggleComplete(testUsername, 7)
+
+	todos, err := store.ListToday(testUsername)
+	if err != nil {
+		t.Fatalf("ListToday() error = %v", err)
+	}
+	var ids []int
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	if fmt.Sprint(ids) != "[2 3 6 7]" {
+		t.Errorf("ListToday() IDs = %v; want [2 3 6 7]", ids)
+	}
+}
+
+// TestTimeZones verifies that timestamps are stored in UTC, exported in the
+// configured time zone, and that files with local offsets still load
+func TestTimeZones(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	store.SetLocation(time.FixedZone("UTC+2", 2*60*60))
+
+	todo, err := store.Add(testUsername, "Stored in UTC")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if todo.CreatedAt.Location() != time.UTC || todo.UpdatedAt.Location() != time.UTC {
+		t.Errorf("Add() timestamps = %v, %v; want UTC", todo.CreatedAt, todo.UpdatedAt)
+	}
+	due := time.Date(2025, 5, 1, 12, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+	if todo, err = store.SetDueDate(testUsername, todo.ID, &due); err != nil {
+		t.Fatalf("SetDueDate() error = %v", err)
+	}
+	if todo.DueAt.Location() != time.UTC || !todo.DueAt.Equal(due) {
+		t.Errorf("SetDueDate() due = %v; want %v in UTC", todo.DueAt, due)
+	}
+
+	data, err := store.ExportJSON(testUsername)
+	if err != nil {
+		t.Fatalf("ExportJSON() error = %v", err)
+	}
+	if !strings.Contains(string(data), `"due_at": "2025-05-01T19:00:00+02:00"`) {
+		t.Errorf("ExportJSON() did not use the configured time zone:\n%s", data)
+	}
+	if todo.DueAt.Location() != time.UTC {
+		t.Error("ExportJSON() changed the stored todo's time zone")
+	}
+
+	// Files written with local offsets
+	legacy := `{"todos":[{"id":1,"text":"Local","created_at":"2024-01-02T03:04:05+09:00","updated_at":"2024-01-02T03:04:05+09:00"}],"next_id":2}`
+	if err := os.WriteFile(filepath.Join(tempDir, "todos", "local.json"), []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write todos file: %v", err)
+	}
+	loaded, err := store.Get("local", 1)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if want := time.Date(2024, 1, 1, 18, 4, 5, 0, time.UTC); !loaded.CreatedAt.Equal(want) {
+		t.Errorf("loaded CreatedAt = %v; want %v", loaded.CreatedAt, want)
+	}
+}
+
+// TestExportJSONFiltered verifies exporting only active todos or those with a tag
+func TestExportJSONFiltered(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"Active work", "Done work", "Active home"})
+	store.SetTags(testUsername, 1, []string{"work"})
+	store.SetTags(testUsername, 2, []string{"Work"})
+	store.SetTags(testUsername, 3, []string{"home"})
+	store.ToggleComplete(testUsername, 2)
+
+	exportIDs := func(opts ExportOptions) string {
+		data, err := store.ExportJSONFiltered(testUsername, opts)
+		if err != nil {
+			t.Fatalf("ExportJSONFiltered() error = %v", err)
+		}
+		var exported []*Todo
+		if err := json.Unmarshal(data, &exported); err != nil {
+			t.Fatalf("Failed to parse export: %v", err)
+		}
+		var ids []int
+		for _, todo := range exported {
+			ids = append(ids, todo.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	tests := []struct {
+		opts ExportOptions
+		want string
+	}{
+		{DefaultExportOptions, "[1 2 3]"},
+		{ExportOptions{}, "[1 3]"},
+		{ExportOptions{IncludeCompleted: true, Tag: "WORK"}, "[1 2]"},
+		{ExportOptions{Tag: "work"}, "[1]"},
+		{ExportOptions{Tag: "none"}, "[]"},
+	}
+	for _, tt := range tests {
+		if got := exportIDs(tt.opts); got != tt.want {
+			t.Errorf("ExportJSONFiltered(%+v) IDs = %s; want %s", tt.opts, got, tt.want)
+		}
+	}
+}
+
+// TestQuota verifies that saves growing a user's file past the quota are
+// refused and rolled back, while shrinking saves still work
+func TestQuota(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if usage, err := store.UserDiskUsage(testUsername); err != nil || usage != 0 {
+		t.Errorf("UserDiskUsage() before any todo = %d, %v; want 0, nil", usage, err)
+	}
+	if _, err := store.AddMany(testUsername, []string{"First", "Second"}); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+	usage, err := store.UserDiskUsage(testUsername)
+	if err != nil || usage == 0 {
+		t.Fatalf("UserDiskUsage() = %d, %v; want the file size", usage, err)
+	}
+
+	store.SetQuota(usage)
+	if _, err := store.Add(testUsername, "Over the limit"); err != ErrQuotaExceeded {
+		t.Errorf("Add() error = %v; want ErrQuotaExceeded", err)
+	}
+	if _, err := store.SetNotes(testUsername, 1, strings.Repeat("x", 100)); err != ErrQuotaExceeded {
+		t.Errorf("SetNotes() error = %v; want ErrQuotaExceeded", err)
+	}
+	if todos, _ := store.List(testUsername); len(todos) != 2 || todos[0].Notes != "" {
+		t.Errorf("todos after refused saves = %+v; want the two original todos", todos)
+	}
+	if after, _ := store.UserDiskUsage(testUsername); after != usage {
+		t.Errorf("UserDiskUsage() after refused saves = %d; want %d", after, usage)
+	}
+
+	// Deleting frees space, even for a user already over a lowered quota
+	store.SetQuota(10)
+	if _, err := store.Delete(testUsername, 2); err != nil {
+		t.Errorf("Delete() over quota error = %v; want nil", err)
+	}
+
+	store.SetQuota(0)
+	if _, err := store.Add(testUsername, "Unlimited again"); err != nil {
+		t.Errorf("Add() without quota error = %v", err)
+	}
+}
+
+// TestReadOnly verifies that maintenance mode rejects and undoes every change
+// while reads keep working, and that turning it off restores writes
+func TestReadOnly(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, err := store.AddMany(testUsername, []string{"First", "Second"}); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+
+	store.SetReadOnly(true)
+	if !store.ReadOnly() {
+		t.Fatal("ReadOnly() = false after SetReadOnly(true)")
+	}
+	if _, err := store.Add(testUsername, "Third"); err != ErrReadOnly {
+		t.Errorf("Add() error = %v; want ErrReadOnly", err)
+	}
+	if _, err := store.Update(testUsername, 1, "Changed"); err != ErrReadOnly {
+		t.Errorf("Update() error = %v; want ErrReadOnly", err)
+	}
+	if _, err := store.ToggleComplete(testUsername, 1); err != ErrReadOnly {
+		t.Errorf("ToggleComplete() error = %v; want ErrReadOnly", err)
+	}
+	if _, err := store.Delete(testUsername, 2); err != ErrReadOnly {
+		t.Errorf("Delete() error = %v; want ErrReadOnly", err)
+	}
+
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(todos) != 2 || todos[0].Text != "First" || todos[0].Completed {
+		t.Errorf("List() during maintenance = %+v; want the unchanged todos", todos)
+	}
+
+	store.SetReadOnly(false)
+	if _, err := store.Add(testUsername, "Third"); err != nil {
+		t.Errorf("Add() after maintenance error = %v", err)
+	}
+}
+
+// TestAutoArchive verifies that only todos completed longer ago than the
+// configured period are archived, and that the archive survives a reload
+func TestAutoArchive(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	clock := useFakeClock(store)
+
+	if _, err := store.AddMany(testUsername, []string{"Old", "Recent", "Active"}); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+	store.ToggleComplete(testUsername, 1)
+	clock.Advance(48 * time.Hour)
+	todo, err := store.ToggleComplete(testUsername, 2)
+	if err != nil {
+		t.Fatalf("ToggleComplete() error = %v", err)
+	}
+	if todo.CompletedAt == nil || !todo.CompletedAt.Equal(todo.UpdatedAt) {
+		t.Errorf("CompletedAt = %v; want the completion time", todo.CompletedAt)
+	}
+
+	// Disabled by default
+	if n, err := store.AutoArchive(testUsername); err != nil || n != 0 {
+		t.Errorf("AutoArchive() while disabled = %d, %v; want 0, nil", n, err)
+	}
+
+	store.SetAutoArchiveAfter(24 * time.Hour)
+	n, err := store.AutoArchive(testUsername)
+	if err != nil || n != 1 {
+		t.Fatalf("AutoArchive() = %d, %v; want 1, nil", n, err)
+	}
+	todos, _ := store.List(testUsername)
+	if len(todos) != 2 || todos[0].Text != "Recent" || todos[1].Text != "Active" {
+		t.Errorf("List() after archiving = %+v; want Recent and Active", todos)
+	}
+
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	archived, err := reloaded.ListArchived(testUsername)
+	if err != nil || len(archived) != 1 || archived[0].Text != "Old" {
+		t.Errorf("ListArchived() after reload = %+v, %v; want Old", archived, err)
+	}
+
+	// Reopening a todo clears its completion time
+	todo, _ = store.ToggleComplete(testUsername, 2)
+	if todo.CompletedAt != nil {
+		t.Errorf("CompletedAt = %v after reopening; want nil", todo.CompletedAt)
+	}
+}
+
+// TestConcurrentToggle verifies that toggles of the same todo from several
+// sessions are never lost, in memory or on disk, while others read the list
+func TestConcurrentToggle(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, err := store.Add(testUsername, "Shared"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	const sessions, toggles = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < toggles; j++ {
+				if _, err := store.ToggleComplete(testUsername, 1); err != nil {
+					t.Errorf("ToggleComplete() error = %v", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < toggles; j++ {
+				todos, err := store.List(testUsername)
+				if err != nil || len(todos) != 1 {
+					t.Errorf("List() = %v, %v; want one todo", todos, err)
+					continue
+				}
+				_ = todos[0].Completed && todos[0].CompletedAt == nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	// An even number of toggles leaves the todo active, with every
+	// completion counted
+	want := sessions*toggles%2 == 1
+	todo, err := store.Get(testUsername, 1)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if todo.Completed != want {
+		t.Errorf("Completed = %v; want %v", todo.Completed, want)
+	}
+	stats, _ := store.Stats(testUsername)
+	if wantCount := (sessions*toggles + 1) / 2; stats.TotalCompleted != wantCount {
+		t.Errorf("TotalCompleted = %d; want %d", stats.TotalCompleted, wantCount)
+	}
+
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	saved, err := reloaded.Get(testUsername, 1)
+	if err != nil {
+		t.Fatalf("Get() after reload error = %v", err)
+	}
+	if saved.Completed != todo.Completed || !saved.UpdatedAt.Equal(todo.UpdatedAt) {
+		t.Errorf("saved todo = %+v; want %+v", saved, todo)
+	}
+}
+
+// TestExportPlain verifies the plain text layout, that control characters
+// can't break it, and that an empty list exports nothing
+func TestExportPlain(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if text, err := store.ExportPlain(testUsername); err != nil || text != "" {
+		t.Errorf("ExportPlain() of empty list = %q, %v; want empty", text, err)
+	}
+
+	store.AddMany(testUsername, []string{"Buy milk", "Call mom"})
+	store.Update(testUsername, 2, "Call\nmom \x1b[31mnow")
+	store.ToggleComplete(testUsername, 1)
+	store.Move(testUsername, 2, -1)
+
+	text, err := store.ExportPlain(testUsername)
+	if err != nil {
+		t.Fatalf("ExportPlain() error = %v", err)
+	}
+	want := "[ ]\t2\tCall mom  [31mnow\n[x]\t1\tBuy milk\n"
+	if text != want {
+		t.Errorf("ExportPlain() = %q; want %q", text, want)
+	}
+}
+
+// TestSetBlockedBy verifies that blockers must exist, that cycles of any
+// length are refused, and that a todo is blocked only by incomplete todos
+func TestSetBlockedBy(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"Buy paint", "Paint fence", "Admire fence"})
+
+	if err := store.SetBlockedBy(testUsername, 2, []int{1, 1}); err != nil {
+		t.Fatalf("SetBlockedBy() error = %v", err)
+	}
+	if err := store.SetBlockedBy(testUsername, 3, []int{2}); err != nil {
+		t.Fatalf("SetBlockedBy() error = %v", err)
+	}
+	if todo, _ := store.Get(testUsername, 2); len(todo.BlockedBy) != 1 || todo.BlockedBy[0] != 1 {
+		t.Errorf("BlockedBy = %v; want [1]", todo.BlockedBy)
+	}
+
+	for _, tc := range []struct {
+		id       int
+		blockers []int
+	}{
+		{1, []int{1}},    // Itself
+		{1, []int{2}},    // Directly
+		{1, []int{3}},    // Through todo 2
+		{2, []int{1, 3}}, // One bad blocker among good ones
+	} {
+		if err := store.SetBlockedBy(testUsername, tc.id, tc.blockers); err != ErrDependencyCycle {
+			t.Errorf("SetBlockedBy(%d, %v) error = %v; want ErrDependencyCycle", tc.id, tc.blockers, err)
+		}
+	}
+	if err := store.SetBlockedBy(testUsername, 1, []int{42}); err == nil {
+		t.Error("SetBlockedBy() with a missing blocker succeeded")
+	}
+	if todo, _ := store.Get(testUsername, 1); len(todo.BlockedBy) != 0 {
+		t.Errorf("BlockedBy after failures = %v; want none", todo.BlockedBy)
+	}
+
+	if blocked, _ := store.IsBlocked(testUsername, 2); !blocked {
+		t.Error("IsBlocked(2) = false; want true while todo 1 is incomplete")
+	}
+	store.ToggleComplete(testUsername, 1)
+	if blocked, _ := store.IsBlocked(testUsername, 2); blocked {
+		t.Error("IsBlocked(2) = true; want false once todo 1 is complete")
+	}
+	store.Delete(testUsername, 2)
+	if blocked, _ := store.IsBlocked(testUsername, 3); blocked {
+		t.Error("IsBlocked(3) = true; want false once its blocker is deleted")
+	}
+
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if todo, _ := reloaded.Get(testUsername, 3); len(todo.BlockedBy) != 1 || todo.BlockedBy[0] != 2 {
+		t.Errorf("BlockedBy after reload = %v; want [2]", todo.BlockedBy)
+	}
+}
+
+// TestEnforceBlockers verifies that blocked todos can't be completed while
+// blockers are enforced, alone or by tag
+func TestEnforceBlockers(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"Buy paint", "Paint fence"})
+	store.SetTags(testUsername, 1, []string{"house"})
+	store.SetTags(testUsername, 2, []string{"house"})
+	if err := store.SetBlockedBy(testUsername, 2, []int{1}); err != nil {
+		t.Fatalf("SetBlockedBy() error = %v", err)
+	}
+
+	// Not enforced by default
+	if _, err := store.ToggleComplete(testUsername, 2); err != nil {
+		t.Fatalf("ToggleComplete() error = %v", err)
+	}
+	store.ToggleComplete(testUsername, 2)
+
+	store.SetEnforceBlockers(true)
+	if _, err := store.ToggleComplete(testUsername, 2); err != ErrBlocked {
+		t.Errorf("ToggleComplete() of blocked todo error = %v; want ErrBlocked", err)
+	}
+	if n, err := store.ToggleByTag(testUsername, "house", true); err != nil || n != 1 {
+		t.Errorf("ToggleByTag() = %d, %v; want 1 (only the blocker)", n, err)
+	}
+	if _, err := store.ToggleComplete(testUsername, 2); err != nil {
+		t.Errorf("ToggleComplete() once unblocked error = %v", err)
+	}
+}
+
+// TestImportBlockedBy verifies that imported blockers follow their todos
+// to new IDs
+func TestImportBlockedBy(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.Add(testUsername, "Existing")
+	data := []byte(`[{"id": 1, "text": "Buy paint"}, {"id": 2, "text": "Paint fence", "blocked_by": [1, 7]}]`)
+	if err := store.ImportJSON(testUsername, data, true); err != nil {
+		t.Fatalf("ImportJSON() error = %v", err)
+	}
+	todo, err := store.Get(testUsername, 3)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(todo.BlockedBy) != 1 || todo.BlockedBy[0] != 2 {
+		t.Errorf("BlockedBy = %v; want [2]", todo.BlockedBy)
+	}
+}
+
+// TestStoredStats verifies that users can be counted from disk without
+// being cached, and that TotalTodos covers every file
+func TestStoredStats(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"One", "Two"})
+	store.ToggleComplete(testUsername, 1)
+	store.Add("other", "Three")
+
+	fresh, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	stats, err := fresh.StoredStats(testUsername)
+	if err != nil {
+		t.Fatalf("StoredStats() error = %v", err)
+	}
+	if stats.Total != 2 || stats.Completed != 1 {
+		t.Errorf("StoredStats() = %+v; want 1 of 2 completed", stats)
+	}
+	if _, cached := fresh.userTodos[testUsername]; cached {
+		t.Error("StoredStats() cached the user")
+	}
+	if stats, err := fresh.StoredStats("nobody"); err != nil || stats.Total != 0 {
+		t.Errorf("StoredStats() for user without file = %+v, %v; want empty", stats, err)
+	}
+
+	if total, err := fresh.TotalTodos(); err != nil || total != 3 {
+		t.Errorf("TotalTodos() = %d, %v; want 3", total, err)
+	}
+	if err := fresh.SetSharded(true); err != nil {
+		t.Fatalf("SetSharded() error = %v", err)
+	}
+	if total, err := fresh.TotalTodos(); err != nil || total != 3 {
+		t.Errorf("TotalTodos() when sharded = %d, %v; want 3", total, err)
+	}
+}
+
+// TestSetTodosDir verifies that todos are saved, loaded, sharded and backed
+// up under a custom directory, and that names leaving it are refused
+func TestSetTodosDir(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		if err := store.SetTodosDir(name); err == nil {
+			t.Errorf("SetTodosDir(%q) succeeded; want an error", name)
+		}
+	}
+
+	if err := store.SetTodosDir("lists"); err != nil {
+		t.Fatalf("SetTodosDir() error = %v", err)
+	}
+	store.Add(testUsername, "Buy milk")
+	if _, err := os.Stat(filepath.Join(tempDir, "lists", testUsername+".json")); err != nil {
+		t.Errorf("todos file not in custom directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "todos", testUsername+".json")); !os.IsNotExist(err) {
+		t.Errorf("todos file written to default directory: %v", err)
+	}
+
+	if err := store.SetSharded(true); err != nil {
+		t.Fatalf("SetSharded() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "lists", shardFor(testUsername), testUsername+".json")); err != nil {
+		t.Errorf("todos file not sharded in custom directory: %v", err)
+	}
+	backupDir := filepath.Join(tempDir, "backup")
+	if err := store.Backup(backupDir); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, "lists", shardFor(testUsername), testUsername+".json")); err != nil {
+		t.Errorf("backup missing todos file: %v", err)
+	}
+
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	reloaded.SetTodosDir("lists")
+	reloaded.SetSharded(true)
+	if todos, _ := reloaded.List(testUsername); len(todos) != 1 {
+		t.Errorf("List() after reload = %d todos; want 1", len(todos))
+	}
+}
+
+// TestSubscribe verifies that every subscriber hears about saved changes,
+// that failed changes and slow subscribers don't hold up the store, and
+// that unsubscribing closes the channel
+func TestSubscribe(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	first, unsubscribeFirst := store.Subscribe()
+	second, unsubscribeSecond := store.Subscribe()
+	defer unsubscribeSecond()
+
+	store.Add(testUsername, "Buy milk")
+	store.Update(testUsername, 1, "Buy oat milk")
+	store.ToggleComplete(testUsername, 1)
+	store.SetReadOnly(true)
+	store.Delete(testUsername, 1) // Fails, so nothing is sent
+	store.SetReadOnly(false)
+	store.Delete(testUsername, 1)
+
+	want := []Event{
+		{EventCreated, testUsername, 1},
+		{EventUpdated, testUsername, 1},
+		{EventToggled, testUsername, 1},
+		{EventDeleted, testUsername, 1},
+	}
+	for name, ch := range map[string]<-chan Event{"first": first, "second": second} {
+		for i, w := range want {
+			select {
+			case got := <-ch:
+				if got != w {
+					t.Errorf("%s subscriber event %d = %+v; want %+v", name, i, got, w)
+				}
+			default:
+				t.Fatalf("%s subscriber missing event %d (%+v)", name, i, w)
+			}
+		}
+		select {
+		case got := <-ch:
+			t.Errorf("%s subscriber got extra event %+v", name, got)
+		default:
+		}
+	}
+
+	// Nobody reads the first channel; the store must not wait for it
+	texts := make([]string, subscriberBuffer*2)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("Todo %d", i)
+	}
+	if _, err := store.AddMany(testUsername, texts); err != nil {
+		t.Fatalf("AddMany() error = %v", err)
+	}
+	if len(first) != subscriberBuffer {
+		t.Errorf("buffered events = %d; want %d", len(first), subscriberBuffer)
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst()
+	for range first {
+	}
+	store.Add(testUsername, "After unsubscribing")
+}
+
+// TestSchemaVersion verifies that unversioned files are migrated and
+// rewritten when loaded, and that files from a newer version are refused
+func TestSchemaVersion(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	readVersion := func(path string) int {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read todos file: %v", err)
+		}
+		var saved struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatalf("Failed to parse todos file: %v", err)
+		}
+		return saved.SchemaVersion
+	}
+
+	store.Add(testUsername, "Versioned")
+	if v := readVersion(filepath.Join(tempDir, "todos", testUsername+".json")); v != CurrentSchemaVersion {
+		t.Errorf("saved schema version = %d; want %d", v, CurrentSchemaVersion)
+	}
+
+	// Unversioned files are upgraded as soon as they are read
+	legacyPath := filepath.Join(tempDir, "todos", "legacy.json")
+	legacy := `{"todos":{"3":{"id":3,"text":"Old","completed":true,"created_at":"2024-01-02T03:04:05Z","updated_at":"2024-02-01T00:00:00Z"}},"next_id":4}`
+	if err := os.WriteFile(legacyPath, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+	if todo, err := store.Get("legacy", 3); err != nil || todo.TextUpdatedAt.IsZero() || !todo.TextUpdatedAt.Equal(todo.CreatedAt) {
+		t.Errorf("legacy Get() = %+v, %v; want TextUpdatedAt defaulting to CreatedAt", todo, err)
+	}
+	stats, err := store.Stats("legacy")
+	if err != nil {
+		t.Fatalf("Stats() on legacy file error = %v", err)
+	}
+	if stats.TotalCreated != 3 || stats.TotalCompleted != 1 {
+		t.Errorf("legacy Stats() = %+v; want TotalCreated 3, TotalCompleted 1", stats)
+	}
+	if v := readVersion(legacyPath); v != CurrentSchemaVersion {
+		t.Errorf("legacy file schema version after loading = %d; want %d", v, CurrentSchemaVersion)
+	}
+
+	// Files from the future are left alone
+	futurePath := filepath.Join(tempDir, "todos", "future.json")
+	future := fmt.Sprintf(`{"schema_version":%d,"todos":[],"next_id":1}`, CurrentSchemaVersion+1)
+	if err := os.WriteFile(futurePath, []byte(future), 0600); err != nil {
+		t.Fatalf("Failed to write future file: %v", err)
+	}
+	if _, err := store.List("future"); err == nil || !strings.Contains(err.Error(), "schema version") {
+		t.Errorf("List() on a newer file error = %v; want a schema version error", err)
+	}
+	if _, err := store.StoredStats("future"); err == nil {
+		t.Error("StoredStats() on a newer file succeeded; want error")
+	}
+	if _, err := store.Add("future", "Overwrite"); err == nil {
+		t.Error("Add() on a newer file succeeded; want error")
+	}
+	if data, _ := os.ReadFile(futurePath); string(data) != future {
+		t.Errorf("newer file was rewritten as %s", data)
+	}
+}
+
+// TestImportMarkdown verifies that checklist items become todos in order,
+// with other lines ignored, and that merging keeps the existing todos
+func TestImportMarkdown(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.Add(testUsername, "Existing")
+	md := "# Groceries\r\n" +
+		"\r\n" +
+		"- [ ] Buy milk\r\n" +
+		"  * [x]   Call mom  \r\n" +
+		"    + [X] Nested\n" +
+		"- [ ]\n" +
+		"- [y] Not a checkbox\n" +
+		"-[ ] No space\n" +
+		"- [ ]Glued\n" +
+		"Some prose with - [ ] inside\n"
+	if err := store.ImportMarkdown(testUsername, md, true); err != nil {
+		t.Fatalf("ImportMarkdown() error = %v", err)
+	}
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []struct {
+		text      string
+		completed bool
+	}{{"Existing", false}, {"Buy milk", false}, {"Call mom", true}, {"Nested", true}}
+	if len(todos) != len(want) {
+		t.Fatalf("List() after merging = %d todos; want %d", len(todos), len(want))
+	}
+	for i, w := range want {
+		if todos[i].Text != w.text || todos[i].Completed != w.completed {
+			t.Errorf("todo %d = %q, completed %v; want %q, completed %v", i, todos[i].Text, todos[i].Completed, w.text, w.completed)
+		}
+	}
+	if todos[2].CompletedAt == nil || todos[1].CreatedAt.IsZero() {
+		t.Errorf("imported todos lack timestamps: %+v", todos[1:])
+	}
+
+	// Replacing drops the existing todos and numbers the new ones from 1
+	if err := store.ImportMarkdown(testUsername, "- [ ] Only\n", false); err != nil {
+		t.Fatalf("ImportMarkdown() error = %v", err)
+	}
+	todos, _ = store.List(testUsername)
+	if len(todos) != 1 || todos[0].Text != "Only" || todos[0].ID != 1 {
+		t.Errorf("List() after replacing = %+v; want only todo 1", todos)
+	}
+}
+
+// TestWasEdited verifies that text edits after creation are reported, but
+// completing a todo or changing its other details is not
+func TestWasEdited(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	clock := useFakeClock(store)
+
+	store.AddMany(testUsername, []string{"Untouched", "Edited", "Completed", "Tagged"})
+	clock.Advance(time.Minute)
+	store.Update(testUsername, 2, "Edited, really")
+	store.ToggleComplete(testUsername, 3)
+	store.ToggleComplete(testUsername, 3)
+	store.SetTags(testUsername, 4, []string{"home"})
+
+	for id, want := range map[int]bool{1: false, 2: true, 3: false, 4: false} {
+		todo, err := store.Get(testUsername, id)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got := todo.WasEdited(); got != want {
+			t.Errorf("todo %d WasEdited() = %v; want %v", id, got, want)
+		}
+	}
+}
+
+func TestCompactIDs(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.AddMany(testUsername, []string{"One", "Two", "Three", "Four", "Five", "Six"})
+	store.SetBlockedBy(testUsername, 6, []int{2, 4})
+	store.Move(testUsername, 6, -5)
+	store.Delete(testUsername, 1)
+	store.Delete(testUsername, 2)
+	store.Delete(testUsername, 5)
+
+	ids, err := store.CompactIDs(testUsername)
+	if err != nil {
+		t.Fatalf("CompactIDs() error = %v", err)
+	}
+	if want := map[int]int{3: 1, 4: 2, 6: 3}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("CompactIDs() = %v; want %v", ids, want)
+	}
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	SortByPosition(todos)
+	var got []string
+	for _, todo := range todos {
+		got = append(got, fmt.Sprintf("%d:%s%v", todo.ID, todo.Text, todo.BlockedBy))
+	}
+	// Six keeps its place at the top, and its deleted blocker is dropped
+	want := "[3:Six[2] 1:Three[] 2:Four[]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("after CompactIDs, todos = %v; want %s", got, want)
+	}
+
+	// Activity follows the new IDs, and deleted todos lose theirs
+	activity, _ := store.RecentActivity(testUsername, 3)
+	got = nil
+	for _, entry := range activity {
+		got = append(got, fmt.Sprintf("%s %d:%s", entry.Action, entry.ID, entry.Text))
+	}
+	if want := "[deleted 0:Five deleted 0:Two deleted 0:One]"; fmt.Sprint(got) != want {
+		t.Errorf("activity after CompactIDs = %v; want %s", got, want)
+	}
+	activity, _ = store.RecentActivity(testUsername, 9)
+	if entry := activity[len(activity)-1]; entry.ID != 0 || entry.Text != "One" {
+		t.Errorf("oldest activity after CompactIDs = %+v; want One without an ID", entry)
+	}
+	if entry := activity[3]; entry.ID != 3 || entry.Text != "Six" {
+		t.Errorf("activity for Six after CompactIDs = %+v; want ID 3", entry)
+	}
+
+	added, err := store.Add(testUsername, "Seven")
+	if err != nil || added.ID != 4 {
+		t.Errorf("Add() after CompactIDs = %v, %v; want ID 4", added, err)
+	}
+
+	// Live sessions could hold the old IDs
+	_, unsubscribe := store.Subscribe()
+	if _, err := store.CompactIDs(testUsername); err != ErrIDsReferenced {
+		t.Errorf("CompactIDs() with a subscriber error = %v; want ErrIDsReferenced", err)
+	}
+	unsubscribe()
+
+	// Archived todos keep their IDs, which new ones could then reuse
+	clock := useFakeClock(store)
+	store.ToggleComplete(testUsername, 1)
+	clock.Advance(2 * time.Hour)
+	store.SetAutoArchiveAfter(time.Hour)
+	if n, err := store.AutoArchive(testUsername); err != nil || n != 1 {
+		t.Fatalf("AutoArchive() = %d, %v; want 1, nil", n, err)
+	}
+	if _, err := store.CompactIDs(testUsername); err != ErrIDsReferenced {
+		t.Errorf("CompactIDs() with archived todos error = %v; want ErrIDsReferenced", err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	other, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	store.Add(testUsername, "Mine")
+	if _, err := other.Add(testUsername, "Theirs"); err != nil {
+		t.Fatalf("Add() from another store error = %v", err)
+	}
+
+	if todos, _ := store.List(testUsername); len(todos) != 1 {
+		t.Errorf("List() before Invalidate() = %d todos; want the cached 1", len(todos))
+	}
+	store.Invalidate(testUsername)
+	todos, err := store.List(testUsername)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(todos) != 2 || todos[0].Text != "Mine" || todos[1].Text != "Theirs" {
+		t.Errorf("List() after Invalidate() = %d todos; want Mine and Theirs", len(todos))
+	}
+
+	// Adding for a user who isn't cached keeps what is on disk
+	store.Invalidate(testUsername)
+	if added, err := store.Add(testUsername, "More"); err != nil || added.ID != 3 {
+		t.Fatalf("Add() after Invalidate() = %v, %v; want ID 3", added, err)
+	}
+	if todos, _ := other.List(testUsername); len(todos) != 2 {
+		t.Errorf("other store lists %d todos; want its cached 2", len(todos))
+	}
+}
+
+// TestInvalidateDuringChanges verifies that a change racing with Invalidate
+// is applied to the todos that end up cached and saved, not to a dropped copy
+func TestInvalidateDuringChanges(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	todo, err := store.Add(testUsername, "Start")
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					store.Invalidate(testUsername)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 500; i++ {
+		text := fmt.Sprintf("Update %d", i)
+		if _, err := store.Update(testUsername, todo.ID, text); err != nil {
+			t.Errorf("Update() error = %v", err)
+			continue
+		}
+		if got, err := store.Get(testUsername, todo.ID); err != nil || got.Text != text {
+			t.Errorf("Get() after Update(%q) = %v, %v; want the update kept", text, got, err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRecentActivity(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if entries, err := store.RecentActivity(testUsername, 10); err != nil || len(entries) != 0 {
+		t.Errorf("RecentActivity() with no activity = %v, %v; want none", entries, err)
+	}
+
+	store.Add(testUsername, "Milk")
+	store.AddMany(testUsername, []string{"Bread", "Eggs"})
+	store.ToggleComplete(testUsername, 1)
+	store.ToggleComplete(testUsername, 1)
+	completed := true
+	store.Patch(testUsername, 2, TodoPatch{Completed: &completed})
+	store.Delete(testUsername, 3)
+	store.Update(testUsername, 1, "Oat milk") // Edits aren't listed
+
+	entries, err := store.RecentActivity(testUsername, 5)
+	if err != nil {
+		t.Fatalf("RecentActivity() error = %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, fmt.Sprintf("%s %d %s", entry.Action, entry.ID, entry.Text))
+	}
+	want := "[deleted 3 Eggs completed 2 Bread reopened 1 Milk completed 1 Milk created 3 Eggs]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("RecentActivity(5) = %v; want %s", got, want)
+	}
+
+	// Only the latest entries are kept
+	for i := 0; i < activityLimit; i++ {
+		store.ToggleComplete(testUsername, 1)
+	}
+	if entries, _ := store.RecentActivity(testUsername, 1000); len(entries) != activityLimit {
+		t.Errorf("RecentActivity() after many changes = %d entries; want %d", len(entries), activityLimit)
+	}
+
+	store.DeleteUser(testUsername)
+	if entries, _ := store.RecentActivity(testUsername, 10); len(entries) != 0 {
+		t.Errorf("RecentActivity() after DeleteUser() = %d entries; want none", len(entries))
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if err := store.FlushAll(); err != nil {
+		t.Errorf("FlushAll() with nothing loaded error = %v", err)
+	}
+
+	// A legacy file loaded while read-only can't be upgraded right away
+	legacyPath := filepath.Join(tempDir, "todos", "legacy.json")
+	legacy := `{"todos":{"1":{"id":1,"text":"Old","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}},"next_id":2}`
+	if err := os.WriteFile(legacyPath, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+	store.Add(testUsername, "Saved right away")
+	store.SetReadOnly(true)
+	if _, err := store.List("legacy"); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if n := store.PendingWrites(); n != 1 {
+		t.Errorf("PendingWrites() = %d; want 1", n)
+	}
+	if err := store.FlushAll(); err == nil || !strings.Contains(err.Error(), "legacy") {
+		t.Errorf("FlushAll() while read-only error = %v; want one naming legacy", err)
+	}
+	if data, _ := os.ReadFile(legacyPath); string(data) != legacy {
+		t.Errorf("legacy file was rewritten while read-only as %s", data)
+	}
+
+	store.SetReadOnly(false)
+	if err := store.FlushAll(); err != nil {
+		t.Fatalf("FlushAll() error = %v", err)
+	}
+	if data, _ := os.ReadFile(legacyPath); !strings.Contains(string(data), "schema_version") {
+		t.Errorf("legacy file after FlushAll() = %s; want it upgraded", data)
+	}
+	if n := store.PendingWrites(); n != 0 {
+		t.Errorf("PendingWrites() after FlushAll() = %d; want 0", n)
+	}
+
+	// Nothing is left to flush, so another stop in read-only mode is fine
+	store.SetReadOnly(true)
+	if err := store.FlushAll(); err != nil {
+		t.Errorf("second FlushAll() error = %v; want nothing to save", err)
+	}
+}
@@ -0,0 +1,80 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"todoissh/pkg/todo"
+)
+
+// activityShown is how many of the user's latest actions the activity
+// screen asks the store for
+const activityShown = 100
+
+// activityHeaderLines is how many rows the title, rule, help and blank line
+// take at the top of the activity screen
+const activityHeaderLines = 4
+
+// displayActivityScreen lists what the user recently did to their todos,
+// newest first, from the scroll offset down
+func (t *TerminalUI) displayActivityScreen() {
+	t.write(fmt.Sprintf(t.strings.ActivityTitleFormat, t.username) + "\r\n")
+	t.write(strings.Repeat("─", t.width) + "\r\n")
+	t.write(t.strings.ActivityHelp + "\r\n")
+	t.write("\r\n")
+
+	entries, err := t.todoStore.RecentActivity(t.username, activityShown)
+	if err != nil {
+		t.write(fmt.Sprintf(t.strings.LoadErrorFormat, err) + "\r\n")
+		return
+	}
+	if len(entries) == 0 {
+		t.writeLine(t.strings.NoActivity)
+		t.hideCursor()
+		return
+	}
+
+	visible := t.activityRows()
+	t.activityOffset = min(t.activityOffset, max(len(entries)-visible, 0))
+	loc := t.todoStore.Location()
+	for _, entry := range entries[t.activityOffset:min(t.activityOffset+visible, len(entries))] {
+		line := fmt.Sprintf(t.strings.ActivityEntryFormat, entry.At.In(loc).Format(dueFormat), t.activityLabel(entry.Action), entry.Text)
+		t.write(truncate(line, t.width) + "\r\n")
+	}
+	t.hideCursor()
+}
+
+// activityRows is how many entries fit below the header, keeping the last
+// row free
+func (t *TerminalUI) activityRows() int {
+	return max(t.height-activityHeaderLines-1, 1)
+}
+
+// activityLabel names an action for the activity screen
+func (t *TerminalUI) activityLabel(action todo.ActivityAction) string {
+	switch action {
+	case todo.ActivityCreated:
+		return t.strings.ActivityCreated
+	case todo.ActivityCompleted:
+		return t.strings.ActivityCompleted
+	case todo.ActivityReopened:
+		return t.strings.ActivityReopened
+	case todo.ActivityDeleted:
+		return t.strings.ActivityDeleted
+	}
+	return string(action)
+}
+
+// handleActivityKey scrolls the activity screen, or leaves it
+func (t *TerminalUI) handleActivityKey(key string) {
+	switch key {
+	case "\x1b[A": // Up arrow
+		if t.activityOffset > 0 {
+			t.activityOffset--
+		}
+	case "\x1b[B": // Down arrow; clamped when drawn
+		t.activityOffset++
+	case "\x1b", "l": // Escape, or the key that opened it
+		t.mode = ModeNormal
+	}
+}
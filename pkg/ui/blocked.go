@@ -0,0 +1,46 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"todoissh/pkg/todo"
+)
+
+// formatIDs lists todo IDs the way they are typed into the blockers input
+func formatIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// setBlockers sets the todos the selected todo waits on from a list of IDs
+// separated by commas or spaces. An empty list clears them. The todo is the
+// one selected when the input opened, even if the list has changed since.
+func (t *TerminalUI) setBlockers(text string) {
+	if t.targetID == 0 {
+		return
+	}
+	var ids []int
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			t.status = fmt.Sprintf(t.strings.BlockersFailedFormat, fmt.Sprintf("%q is not a todo ID", field))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	err := t.todoStore.SetBlockedBy(t.username, t.targetID, ids)
+	switch {
+	case err == nil:
+	case errors.Is(err, todo.ErrReadOnly):
+		t.status = t.strings.Maintenance
+	default:
+		t.status = fmt.Sprintf(t.strings.BlockersFailedFormat, err)
+	}
+}
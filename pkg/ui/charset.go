@@ -0,0 +1,60 @@
+package ui
+
+import "strings"
+
+// asciiReplacer swaps the non-ASCII symbols drawn by the UI for ASCII ones
+// of the same width, for terminals that can't show UTF-8
+var asciiReplacer = strings.NewReplacer(
+	"✓", "x",
+	"─", "-",
+	"…", "~",
+	"•", "|",
+	"↑", "^",
+	"↓", "v",
+	"←", "<",
+	"→", ">",
+)
+
+// asciiTerms are terminal types assumed to lack UTF-8 when the client
+// doesn't send a locale
+var asciiTerms = map[string]bool{
+	"dumb":  true,
+	"ansi":  true,
+	"vt100": true,
+	"vt102": true,
+	"vt220": true,
+}
+
+// SetASCII forces ASCII-only output when enabled, whatever the client
+// reports about its terminal
+func (t *TerminalUI) SetASCII(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.forceASCII = enabled
+	t.updateCharset()
+}
+
+// updateCharset decides whether output must be ASCII-only: when forced,
+// when the client's locale names a charset other than UTF-8, or when it
+// sends no locale and its terminal type predates UTF-8.
+// We assume the caller already has the lock.
+func (t *TerminalUI) updateCharset() {
+	t.ascii.Store(t.forceASCII || !t.clientUTF8())
+}
+
+// clientUTF8 reports whether the client's terminal can be expected to show
+// UTF-8. We assume the caller already has the lock.
+func (t *TerminalUI) clientUTF8() bool {
+	// The first locale variable set wins, as in the C library
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := strings.ToLower(t.env[name]); value != "" {
+			return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+		}
+	}
+
+	term := t.env["TERM"]
+	if term == "" {
+		term = t.term
+	}
+	return !asciiTerms[term]
+}
@@ -0,0 +1,47 @@
+package ui
+
+// maxEnvValueLength bounds the value of a captured environment variable
+const maxEnvValueLength = 256
+
+// acceptedEnv lists the environment variables clients may forward. Others
+// are ignored, since the UI has no use for them.
+var acceptedEnv = map[string]bool{
+	"LANG":      true,
+	"LC_ALL":    true,
+	"LC_CTYPE":  true,
+	"TERM":      true,
+	"COLORTERM": true,
+	"NO_COLOR":  true,
+}
+
+// setEnv records a variable forwarded by the client if it is one the UI
+// understands
+func (t *TerminalUI) setEnv(name, value string) {
+	if !acceptedEnv[name] || len(value) > maxEnvValueLength {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.env == nil {
+		t.env = make(map[string]string)
+	}
+	t.env[name] = value
+	t.updateCharset()
+}
+
+// setTerm records the terminal type sent with the pty request
+func (t *TerminalUI) setTerm(term string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.term = term
+	t.updateCharset()
+}
+
+// Env returns an environment variable forwarded by the client, or an empty
+// string if it was not sent or not accepted
+func (t *TerminalUI) Env(name string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.env[name]
+}
@@ -0,0 +1,105 @@
+package ui
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"todoissh/pkg/user"
+)
+
+// Exit statuses of commands run over exec, so scripts can branch on them
+const (
+	ExitOK     = 0 // The command succeeded
+	ExitFailed = 1 // The command was refused or failed
+	ExitUsage  = 2 // Unknown command or missing input
+)
+
+// handleExec runs the command of an exec request, writing its output to
+// the channel and ending with its exit status instead of starting the UI
+func (t *TerminalUI) handleExec(req *ssh.Request) {
+	command, ok := parseExecRequest(req.Payload)
+	if !ok {
+		req.Reply(false, nil)
+		return
+	}
+	req.Reply(true, nil)
+
+	status := t.runCommand(command, t.channel, t.channel, t.channel.Stderr())
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(status))
+	t.channel.SendRequest("exit-status", false, payload)
+}
+
+// runCommand runs a command given over exec and returns its exit status
+func (t *TerminalUI) runCommand(command string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		fmt.Fprintln(stderr, "No command given; available: register")
+		return ExitUsage
+	}
+	switch fields[0] {
+	case "register":
+		return t.execRegister(stdin, stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q; available: register\n", fields[0])
+		return ExitUsage
+	}
+}
+
+// execRegister registers the connecting user with the password on the
+// first line of stdin. The password policy, invite-only registration and
+// reserved names apply as they do in the UI.
+func (t *TerminalUI) execRegister(stdin io.Reader, stdout, stderr io.Writer) int {
+	if !t.isRegistering {
+		fmt.Fprintf(stderr, "Registration failed: %v\n", user.ErrUserExists)
+		return ExitFailed
+	}
+
+	// Read one line, no longer than typed input may be
+	reader := bufio.NewReader(io.LimitReader(stdin, int64(t.maxInput)+2))
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(stderr, "Failed to read password: %v\n", err)
+		return ExitFailed
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		fmt.Fprintln(stderr, "No password given; write it to standard input")
+		return ExitUsage
+	}
+	if len(password) > t.maxInput {
+		fmt.Fprintf(stderr, "Password is longer than %d characters\n", t.maxInput)
+		return ExitUsage
+	}
+
+	// Starting and confirming at once applies the same checks as the UI
+	if err := t.userStore.StartRegistration(t.username, password); err != nil {
+		fmt.Fprintf(stderr, "Registration failed: %v\n", err)
+		return ExitFailed
+	}
+	if err := t.userStore.ConfirmRegistration(t.username, password); err != nil {
+		t.userStore.CancelRegistration(t.username)
+		fmt.Fprintf(stderr, "Registration failed: %v\n", err)
+		return ExitFailed
+	}
+	t.isRegistering = false
+	fmt.Fprintf(stdout, "Registered %s\n", t.username)
+	return ExitOK
+}
+
+// parseExecRequest extracts the command from an exec payload (RFC 4254
+// section 6.5)
+func parseExecRequest(payload []byte) (command string, ok bool) {
+	var exec struct {
+		Command string
+	}
+	if err := ssh.Unmarshal(payload, &exec); err != nil {
+		return "", false
+	}
+	return exec.Command, true
+}
@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"todoissh/pkg/todo"
+)
+
+// displayFocusScreen shows only the selected todo, with its full text,
+// notes and details centered on screen
+func (t *TerminalUI) displayFocusScreen() {
+	todos, err := t.loadTodos()
+	if err != nil {
+		t.write(fmt.Sprintf(t.strings.LoadErrorFormat, err) + "\r\n")
+		return
+	}
+	t.todos = todos
+	if len(t.todos) == 0 {
+		t.mode = ModeNormal
+		t.writeLine(t.strings.EmptyList)
+		return
+	}
+	t.selected = min(t.selected, len(t.todos)-1)
+	item := t.todos[t.selected]
+
+	width := max(t.width-2*detailIndent, 1)
+	var lines []string
+	lines = append(lines, fmt.Sprintf(t.strings.FocusPositionFormat, t.selected+1, len(t.todos)), "")
+	lines = append(lines, wrap(item.Text, width)...)
+	if item.Notes != "" {
+		lines = append(lines, "")
+		for _, paragraph := range strings.Split(item.Notes, "\n") {
+			lines = append(lines, wrap(paragraph, width)...)
+		}
+	}
+	lines = append(lines, "")
+	lines = append(lines, t.focusDetails(item)...)
+
+	// Center the block, keeping the last line free for the help
+	top := max((t.height-1-len(lines))/2, 0) + 1
+	for i, line := range lines {
+		if top+i >= t.height {
+			break
+		}
+		line = truncate(line, t.width)
+		t.moveTo(top+i, (t.width-utf8.RuneCountInString(line))/2+1)
+		t.write(line)
+	}
+	t.moveTo(t.height, 1)
+	t.write(truncate(t.strings.FocusHelp, t.width))
+	t.hideCursor()
+}
+
+// focusDetails describes the state, priority, due date, tags and age of a
+// todo, one per line, leaving out what isn't set
+func (t *TerminalUI) focusDetails(item *todo.Todo) []string {
+	loc := t.todoStore.Location()
+	status := t.strings.FocusActive
+	if item.Completed {
+		status = t.strings.FocusCompleted
+	}
+	details := []string{status}
+	if item.Priority != 0 {
+		details = append(details, fmt.Sprintf(t.strings.FocusPriorityFormat, item.Priority))
+	}
+	if item.DueAt != nil {
+		details = append(details, fmt.Sprintf(t.strings.FocusDueFormat, item.DueAt.In(loc).Format(dueFormat)))
+	}
+	if len(item.Tags) > 0 {
+		details = append(details, fmt.Sprintf(t.strings.FocusTagsFormat, strings.Join(item.Tags, ", ")))
+	}
+	return append(details, fmt.Sprintf(t.strings.FocusCreatedFormat, item.CreatedAt.In(loc).Format(dueFormat)))
+}
+
+// handleFocusKey moves between todos on the focus screen, or leaves it
+func (t *TerminalUI) handleFocusKey(key string) {
+	switch key {
+	case "\x1b[D": // Left arrow
+		if t.selected > 0 {
+			t.selected--
+		}
+	case "\x1b[C": // Right arrow
+		if t.selected < len(t.todos)-1 {
+			t.selected++
+		}
+	case "\x1b", ".": // Escape, or the key that opened it
+		t.mode = ModeNormal
+	}
+}
@@ -0,0 +1,121 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"todoissh/pkg/todo"
+)
+
+// DefaultRowTemplate renders a todo row the way the UI always has
+const DefaultRowTemplate = "{{.Prefix}}{{.Status}} {{.Index}}. {{.Text}}"
+
+// dueFormat is the layout used for the .Due template field
+const dueFormat = "2006-01-02 15:04"
+
+// detailIndent is how far the full text of a truncated todo is indented
+const detailIndent = 4
+
+// defaultRowTemplate is the parsed form of DefaultRowTemplate
+var defaultRowTemplate = template.Must(template.New("row").Parse(DefaultRowTemplate))
+
+// Row holds the fields available to a row template
+type Row struct {
+	Prefix   string // Selection marker, "> " or "  "
+	Status   string // Completion checkbox
+	Index    int    // 1-based position in the list, or the ID when IDs are shown
+	ID       int    // Stored todo ID
+	Text     string
+	Priority int
+	Due      string // Formatted due date, empty when unset
+	Tags     string // Comma-separated tags
+	Edited   bool   // Changed since it was created; see todo.Todo.WasEdited
+}
+
+// newRow builds the template fields for a todo, showing its due date in loc
+func newRow(item *todo.Todo, index int, prefix, status string, loc *time.Location) Row {
+	row := Row{
+		Prefix:   prefix,
+		Status:   status,
+		Index:    index,
+		ID:       item.ID,
+		Text:     item.Text,
+		Priority: item.Priority,
+		Tags:     strings.Join(item.Tags, ","),
+		Edited:   item.WasEdited(),
+	}
+	if item.DueAt != nil {
+		row.Due = item.DueAt.In(loc).Format(dueFormat)
+	}
+	return row
+}
+
+// SetRowTemplate sets the text/template used to render each todo row.
+// An invalid template is rejected and the default is used instead.
+func (t *TerminalUI) SetRowTemplate(text string) error {
+	tmpl, err := template.New("row").Option("missingkey=error").Parse(text)
+	if err == nil {
+		// Execute against a sample row to catch unknown fields up front
+		err = tmpl.Execute(&strings.Builder{}, Row{})
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		t.rowTemplate = defaultRowTemplate
+		return fmt.Errorf("invalid row template: %v", err)
+	}
+	t.rowTemplate = tmpl
+	return nil
+}
+
+// renderRow formats a row with the configured template, falling back to
+// the default if rendering fails
+func (t *TerminalUI) renderRow(row Row) string {
+	var b strings.Builder
+	if err := t.rowTemplate.Execute(&b, row); err == nil {
+		return b.String()
+	}
+	b.Reset()
+	defaultRowTemplate.Execute(&b, row)
+	return b.String()
+}
+
+// truncate shortens s to at most width runes, ending it with an ellipsis
+// when anything was cut
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// wrap splits s into lines of at most width runes, breaking at spaces
+// where possible
+func wrap(s string, width int) []string {
+	width = max(width, 1)
+	var lines []string
+	runes := []rune(s)
+	for len(runes) > width {
+		cut := width
+		for i := width; i > 0; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, string(runes[:cut]))
+		runes = runes[cut:]
+		for len(runes) > 0 && runes[0] == ' ' {
+			runes = runes[1:]
+		}
+	}
+	return append(lines, string(runes))
+}
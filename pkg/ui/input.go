@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Errors returned by readByte when a session limit is reached
+var (
+	errIdleTimeout    = errors.New("idle timeout")
+	errSessionExpired = errors.New("session expired")
+)
+
+// inputEvent is a single byte read from the channel, or the read error
+type inputEvent struct {
+	b   byte
+	err error
+}
+
+// SetIdleTimeout ends the session after d without any keypress. Zero disables it.
+func (t *TerminalUI) SetIdleTimeout(d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.idleTimeout = d
+}
+
+// SetMaxSessionDuration ends the session d after it starts, regardless of
+// activity. Zero disables it. This composes with the idle timeout: whichever
+// fires first ends the session.
+func (t *TerminalUI) SetMaxSessionDuration(d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.maxDuration = d
+}
+
+// SetTimeoutMessages sets the messages shown when the idle timeout or the
+// maximum session duration ends the session
+func (t *TerminalUI) SetTimeoutMessages(idle, expired string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.strings.IdleLogout = idle
+	t.strings.SessionExpired = expired
+}
+
+// startInput starts reading the channel in the background so the input loop
+// can also wait on session timers. The reader stops once the session is done.
+func (t *TerminalUI) startInput() {
+	t.input = make(chan inputEvent)
+	t.done = make(chan struct{})
+	if t.maxDuration > 0 {
+		t.expired = time.After(t.maxDuration)
+	}
+
+	go func() {
+		send := func(ev inputEvent) bool {
+			select {
+			case t.input <- ev:
+				return true
+			case <-t.done:
+				return false
+			}
+		}
+		var buf [1]byte
+		for {
+			n, err := t.channel.Read(buf[:])
+			// A byte read along with an error still counts, so it goes
+			// first and the error follows on its own
+			if n > 0 && !send(inputEvent{b: buf[0]}) {
+				return
+			}
+			if err != nil {
+				send(inputEvent{err: err})
+				return
+			}
+		}
+	}()
+}
+
+// stopInput releases the background reader started by startInput
+func (t *TerminalUI) stopInput() {
+	if t.done != nil {
+		close(t.done)
+	}
+}
+
+// readByte returns the next byte typed by the user, or an error when the
+// channel fails or a session limit is reached first
+func (t *TerminalUI) readByte() (byte, error) {
+	// Once the channel has failed every later read fails the same way
+	if t.inputErr != nil {
+		return 0, t.inputErr
+	}
+	// Nobody can see the session once output fails, so end it as if the
+	// client had disconnected
+	if t.writeFailed.Load() {
+		return 0, io.EOF
+	}
+
+	var idle <-chan time.Time
+	if t.idleTimeout > 0 {
+		timer := time.NewTimer(t.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	select {
+	case ev := <-t.input:
+		t.inputErr = ev.err
+		return ev.b, ev.err
+	case <-idle:
+		return 0, errIdleTimeout
+	case <-t.expired:
+		return 0, errSessionExpired
+	case <-t.interrupt:
+		return 3, nil // Handled like Ctrl+C
+	case <-t.writeClosed:
+		return 0, io.EOF
+	}
+}
+
+// readByteWithin returns the next byte if one arrives within d. When none
+// does, or the channel fails, ok is false and any error is left for the
+// next readByte.
+func (t *TerminalUI) readByteWithin(d time.Duration) (b byte, ok bool) {
+	if t.inputErr != nil {
+		return 0, false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case ev := <-t.input:
+		t.inputErr = ev.err
+		return ev.b, ev.err == nil
+	case <-timer.C:
+		return 0, false
+	}
+}
@@ -0,0 +1,322 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"todoissh/pkg/todo"
+)
+
+// Action names something the user can do from the todo list with a key
+type Action string
+
+const (
+	ActionUp        Action = "up"
+	ActionDown      Action = "down"
+	ActionFirst     Action = "first"
+	ActionLast      Action = "last"
+	ActionToggle    Action = "toggle"
+	ActionEdit      Action = "edit"
+	ActionNew       Action = "new"
+	ActionAddMany   Action = "add_many"
+	ActionDelete    Action = "delete"
+	ActionMoveUp    Action = "move_up"
+	ActionMoveDown  Action = "move_down"
+	ActionSearch    Action = "search"
+	ActionBlockedBy Action = "blocked_by"
+	ActionToday     Action = "today"
+	ActionShowIDs   Action = "show_ids"
+	ActionTheme     Action = "theme"
+	ActionKeys      Action = "keys"
+	ActionFocus     Action = "focus"
+	ActionSnooze    Action = "snooze"
+	ActionSnoozed   Action = "snoozed"
+	ActionRefresh   Action = "refresh"
+	ActionActivity  Action = "activity"
+	ActionSave      Action = "save"
+)
+
+// Keymap binds list actions to the keys that trigger them. A key is a
+// single character, such as "j" or "\t", or a whole escape sequence, such
+// as "\x1b[A" for the up arrow. Ctrl+C always exits and can't be bound.
+type Keymap map[Action][]string
+
+// DefaultKeymap returns the standard bindings, as listed in the help line
+func DefaultKeymap() Keymap {
+	return Keymap{
+		ActionUp:        {"\x1b[A"},
+		ActionDown:      {"\x1b[B"},
+		ActionFirst:     {"g", "\x1b[H", "\x1b[1~"},
+		ActionLast:      {"G", "\x1b[F", "\x1b[4~"},
+		ActionToggle:    {" "},
+		ActionEdit:      {"\r"},
+		ActionNew:       {"\t"},
+		ActionAddMany:   {"a"},
+		ActionDelete:    {"\x1b[3~"},
+		ActionMoveUp:    {"-"},
+		ActionMoveDown:  {"+"},
+		ActionSearch:    {"/"},
+		ActionBlockedBy: {"b"},
+		ActionToday:     {"t"},
+		ActionShowIDs:   {"i"},
+		ActionTheme:     {"c"},
+		ActionKeys:      {"k"},
+		ActionFocus:     {"."},
+		ActionSnooze:    {"s"},
+		ActionSnoozed:   {"z"},
+		ActionRefresh:   {"\x12"}, // Ctrl+R
+		ActionActivity:  {"l"},
+		ActionSave:      {"w"},
+	}
+}
+
+// defaultBindings is the lookup form of DefaultKeymap
+var defaultBindings = func() map[string]Action {
+	bindings, err := keyBindings(DefaultKeymap())
+	if err != nil {
+		panic(err)
+	}
+	return bindings
+}()
+
+// keyBindings inverts a keymap for lookups by key, failing if it binds an
+// unknown action or a key that can't be typed or is bound twice
+func keyBindings(keymap Keymap) (map[string]Action, error) {
+	known := DefaultKeymap()
+	actions := make([]Action, 0, len(keymap))
+	for action := range keymap {
+		actions = append(actions, action)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+
+	bindings := make(map[string]Action)
+	for _, action := range actions {
+		if _, ok := known[action]; !ok {
+			return nil, fmt.Errorf("unknown action %q", action)
+		}
+		for _, key := range keymap[action] {
+			switch {
+			case key == "":
+				return nil, fmt.Errorf("empty key for %s", action)
+			case key == "\x03":
+				return nil, fmt.Errorf("Ctrl+C can't be bound, it always exits")
+			case len(key) > 1 && key[0] != 27:
+				return nil, fmt.Errorf("key %q for %s is not a single character or escape sequence", key, action)
+			}
+			if other, taken := bindings[key]; taken && other != action {
+				return nil, fmt.Errorf("key %q is bound to both %s and %s", key, other, action)
+			}
+			bindings[key] = action
+		}
+	}
+	return bindings, nil
+}
+
+// SetKeymap replaces the key bindings of the todo list. Actions the keymap
+// leaves out have no key. A keymap with conflicting or invalid bindings is
+// rejected and the current one is kept.
+func (t *TerminalUI) SetKeymap(keymap Keymap) error {
+	bindings, err := keyBindings(keymap)
+	if err != nil {
+		return fmt.Errorf("invalid keymap: %v", err)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.bindings = bindings
+	return nil
+}
+
+// readKey completes the key that starts with first, reading the rest of an
+// escape sequence if it is one
+func (t *TerminalUI) readKey(first byte) (string, error) {
+	if first != 27 {
+		return string(first), nil
+	}
+	// Escape on its own is a key too, told apart from the start of a
+	// sequence by nothing following it right away
+	next, ok := t.readByteWithin(escapeWait)
+	if !ok {
+		return "\x1b", nil
+	}
+	key := []byte{27, next}
+	// Only CSI (ESC [) and SS3 (ESC O) sequences go on; anything else is
+	// Escape followed by that key, e.g. Alt+x
+	if next != '[' && next != 'O' {
+		return string(key), nil
+	}
+	b, err := t.readByte()
+	if err != nil {
+		return "", err
+	}
+	key = append(key, b)
+	if next == 'O' {
+		return string(key), nil
+	}
+	// Parameters such as the 3 of Delete (ESC [ 3 ~) run until a final
+	// byte in the range @ to ~
+	for last := key[2]; last < 0x40 || last > 0x7e; {
+		if len(key) >= maxKeyLength {
+			break
+		}
+		b, err := t.readByte()
+		if err != nil {
+			return "", err
+		}
+		key = append(key, b)
+		last = b
+	}
+	return string(key), nil
+}
+
+// escapeWait is how long readKey waits after an Escape for the rest of an
+// escape sequence before taking it as the Escape key on its own
+var escapeWait = 50 * time.Millisecond
+
+// maxKeyLength caps how much of a malformed escape sequence is read as a
+// single key
+const maxKeyLength = 16
+
+// runAction does what a key bound in the list asks for
+func (t *TerminalUI) runAction(action Action) {
+	hasTodos := t.selected < len(t.todos)
+	switch action {
+	case ActionUp:
+		if t.selected > 0 {
+			t.selected--
+		}
+	case ActionDown:
+		if t.selected < len(t.todos)-1 {
+			t.selected++
+		}
+	case ActionFirst:
+		t.jumpToStart()
+	case ActionLast:
+		t.jumpToEnd()
+	case ActionToggle:
+		if !hasTodos {
+			return
+		}
+		// Use the actual ID from the selected todo
+		_, err := t.todoStore.ToggleComplete(t.username, t.todos[t.selected].ID)
+		if errors.Is(err, todo.ErrBlocked) {
+			t.status = t.strings.StillBlocked
+		} else if errors.Is(err, todo.ErrReadOnly) {
+			t.status = t.strings.Maintenance
+		} else if err != nil {
+			log.Printf("Error toggling todo: %v", err)
+		}
+	case ActionEdit:
+		if !hasTodos {
+			return
+		}
+		t.mode = ModeInput
+		t.targetID = t.todos[t.selected].ID
+		t.inputText = t.todos[t.selected].Text
+		// Just show "Edit todo:" instead of showing the ID
+		t.inputLabel = t.strings.EditTodoLabel
+		t.inputAction = inputEdit
+		t.cursorPos = len(t.inputText)
+	case ActionNew:
+		t.beginAdd()
+	case ActionAddMany:
+		t.beginAdd()
+		t.inputLabel = t.strings.NewTodosLabel
+		t.inputAction = inputAddMany
+	case ActionDelete:
+		if !hasTodos {
+			return
+		}
+		deleted, err := t.todoStore.Delete(t.username, t.todos[t.selected].ID)
+		switch {
+		case errors.Is(err, todo.ErrReadOnly):
+			t.status = t.strings.Maintenance
+			return
+		case err != nil:
+			log.Printf("Error deleting todo: %v", err)
+		default:
+			t.status = fmt.Sprintf(t.strings.DeletedFormat, deleted.Text)
+		}
+		if t.selected >= len(t.todos)-1 {
+			t.selected = max(0, len(t.todos)-2)
+		}
+	case ActionMoveUp, ActionMoveDown:
+		if !hasTodos {
+			return
+		}
+		offset := 1
+		if action == ActionMoveUp {
+			offset = -1
+		}
+		err := t.todoStore.Move(t.username, t.todos[t.selected].ID, offset)
+		switch {
+		case errors.Is(err, todo.ErrReadOnly):
+			t.status = t.strings.Maintenance
+		case err != nil:
+			log.Printf("Error moving todo: %v", err)
+		default:
+			t.selected = min(max(t.selected+offset, 0), len(t.todos)-1)
+		}
+	case ActionSearch:
+		t.mode = ModeInput
+		t.inputLabel = t.strings.SearchLabel
+		t.inputAction = inputSearch
+		t.inputText = t.filter
+		t.cursorPos = len(t.inputText)
+	case ActionBlockedBy:
+		if !hasTodos {
+			return
+		}
+		t.mode = ModeInput
+		t.inputLabel = t.strings.BlockedByLabel
+		t.inputAction = inputBlockers
+		t.targetID = t.todos[t.selected].ID
+		t.inputText = formatIDs(t.todos[t.selected].BlockedBy)
+		t.cursorPos = len(t.inputText)
+	case ActionToday:
+		t.today = !t.today
+		t.snoozed = false
+		t.selected = 0
+	case ActionSnoozed:
+		t.snoozed = !t.snoozed
+		t.today = false
+		t.selected = 0
+	case ActionSnooze:
+		if !hasTodos {
+			return
+		}
+		t.mode = ModeInput
+		t.inputLabel = t.strings.SnoozeLabel
+		t.inputAction = inputSnooze
+		t.targetID = t.todos[t.selected].ID
+		t.inputText = ""
+		t.cursorPos = 0
+	case ActionShowIDs:
+		t.toggleShowIDs()
+	case ActionTheme:
+		t.mode = ModeInput
+		t.inputLabel = fmt.Sprintf(t.strings.ThemeLabelFormat, strings.Join(ThemeNames(), ", "))
+		t.inputAction = inputTheme
+		t.inputText = ""
+		t.cursorPos = 0
+	case ActionKeys:
+		t.mode = ModeKeys
+		t.keySelected = 0
+	case ActionFocus:
+		if hasTodos {
+			t.mode = ModeFocus
+		}
+	case ActionActivity:
+		t.mode = ModeActivity
+		t.activityOffset = 0
+	case ActionRefresh:
+		// The list is reloaded as it is redrawn
+		t.todoStore.Invalidate(t.username)
+		t.status = t.strings.Refreshed
+	case ActionSave:
+		t.saveNow()
+	}
+}
@@ -0,0 +1,84 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// displayKeysScreen lists the user's authorized public keys and whether
+// two-factor authentication is on
+func (t *TerminalUI) displayKeysScreen() {
+	t.write(fmt.Sprintf(t.strings.KeysTitleFormat, t.username) + "\r\n")
+	t.write(strings.Repeat("─", t.width) + "\r\n")
+
+	if t.mode == ModeInput && t.inputAction == inputTOTP {
+		t.write(t.strings.TOTPEnrollHelp + "\r\n")
+	} else if t.mode == ModeInput {
+		t.write(t.strings.KeyInputHelp + "\r\n")
+	} else {
+		t.write(t.strings.KeysHelp + "\r\n")
+	}
+	t.write("\r\n")
+
+	keys := t.userStore.AuthorizedKeys(t.username)
+	if len(keys) == 0 {
+		t.write(t.strings.NoKeys + "\r\n")
+	}
+	t.keySelected = min(t.keySelected, max(0, len(keys)-1))
+	for i, key := range keys {
+		prefix := "  "
+		if i == t.keySelected && t.mode == ModeKeys {
+			prefix = "> "
+		}
+		comment := ""
+		if fields := strings.Fields(key.Line); len(fields) > 2 {
+			comment = " " + strings.Join(fields[2:], " ")
+		}
+		t.write(fmt.Sprintf("%s%s%s\r\n", prefix, key.Fingerprint, comment))
+	}
+
+	t.write("\r\n")
+	if t.userStore.HasTOTP(t.username) {
+		t.write(t.strings.TOTPOn + "\r\n")
+	} else {
+		t.write(t.strings.TOTPOff + "\r\n")
+	}
+	if t.mode == ModeInput && t.inputAction == inputTOTP {
+		t.write(fmt.Sprintf(t.strings.TOTPEnrollFormat, t.totpSecret) + "\r\n")
+	}
+
+	if t.status != "" {
+		t.write("\r\n" + t.status + "\r\n")
+		t.status = ""
+	}
+}
+
+// keysInput reports whether the input field belongs to the key manager
+func (t *TerminalUI) keysInput() bool {
+	return t.inputAction == inputKey || t.inputAction == inputTOTP
+}
+
+// addKey authorizes a pasted public key for the current user
+func (t *TerminalUI) addKey(line string) {
+	if line == "" {
+		return
+	}
+	if err := t.userStore.AddAuthorizedKey(t.username, line); err != nil {
+		t.status = fmt.Sprintf(t.strings.KeyAddFailedFormat, err)
+		return
+	}
+	t.status = t.strings.KeyAdded
+}
+
+// removeSelectedKey revokes the key selected in the list
+func (t *TerminalUI) removeSelectedKey() {
+	keys := t.userStore.AuthorizedKeys(t.username)
+	if t.keySelected >= len(keys) {
+		return
+	}
+	if err := t.userStore.RemoveAuthorizedKey(t.username, keys[t.keySelected].Fingerprint); err != nil {
+		t.status = fmt.Sprintf(t.strings.KeyRemoveFailedFormat, err)
+		return
+	}
+	t.status = t.strings.KeyRemoved
+}
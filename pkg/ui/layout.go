@@ -0,0 +1,51 @@
+package ui
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// InputLayout controls how the input field is drawn at the bottom of the
+// screen
+type InputLayout struct {
+	Separator string // Character drawn across the screen above the input; empty for none
+	Row       int    // Screen row of the input, counting up from 1 for the last row
+}
+
+// DefaultInputLayout draws a line above the input, keeping the last row
+// of the screen free
+var DefaultInputLayout = InputLayout{Separator: "─", Row: 2}
+
+// Validate checks that the separator is a single printable character and
+// that the row is on screen
+func (l InputLayout) Validate() error {
+	if l.Separator != "" {
+		r, size := utf8.DecodeRuneInString(l.Separator)
+		if size != len(l.Separator) || !unicode.IsPrint(r) {
+			return fmt.Errorf("separator %q is not a single printable character", l.Separator)
+		}
+	}
+	if l.Row < 1 {
+		return fmt.Errorf("input row %d is below the screen; the last row is 1", l.Row)
+	}
+	return nil
+}
+
+// SetInputLayout changes how the input field is drawn. An invalid layout
+// is rejected and the current one is kept.
+func (t *TerminalUI) SetInputLayout(layout InputLayout) error {
+	if err := layout.Validate(); err != nil {
+		return fmt.Errorf("invalid input layout: %v", err)
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inputLayout = layout
+	return nil
+}
+
+// inputRow returns the screen row of the input field, kept on screen
+// however short it is
+func (t *TerminalUI) inputRow() int {
+	return min(max(t.height-t.inputLayout.Row+1, 1), t.height)
+}
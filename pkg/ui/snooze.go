@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoissh/pkg/todo"
+)
+
+// parseSnooze reads how long to snooze for, as a Go duration such as 90m
+// or 2h, or a whole number of days such as 3d
+func parseSnooze(text string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(text, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(text)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%q is not a duration like 2h or 3d", text)
+	}
+	return d, nil
+}
+
+// snoozeSelected hides the selected todo for the duration typed, or wakes
+// it if nothing was typed. The todo is the one selected when the input
+// opened, even if the list has changed since.
+func (t *TerminalUI) snoozeSelected(text string) {
+	if t.targetID == 0 {
+		return
+	}
+	text = strings.TrimSpace(text)
+	var until time.Time
+	if text != "" {
+		d, err := parseSnooze(text)
+		if err != nil {
+			t.status = fmt.Sprintf(t.strings.SnoozeFailedFormat, err)
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	err := t.todoStore.Snooze(t.username, t.targetID, until)
+	switch {
+	case err == nil && until.IsZero():
+		t.status = t.strings.Woken
+	case err == nil:
+		t.status = fmt.Sprintf(t.strings.SnoozedFormat, until.In(t.todoStore.Location()).Format(dueFormat))
+	case errors.Is(err, todo.ErrReadOnly):
+		t.status = t.strings.Maintenance
+	default:
+		t.status = fmt.Sprintf(t.strings.SnoozeFailedFormat, err)
+	}
+}
@@ -0,0 +1,241 @@
+package ui
+
+// Strings holds every user-facing message shown by the terminal UI, so the
+// interface can be localized without touching its logic. Fields ending in
+// "Format" are fmt format strings; their arguments are noted alongside.
+type Strings struct {
+	// List screen
+	ListTitleFormat      string // username
+	ListStatsFormat      string // completed, total, completed all-time
+	ListHelp             string
+	InputHelp            string
+	EmptyList            string
+	SearchSummaryFormat  string // query, number of matches
+	TodaySummaryFormat   string // number of todos
+	LoadErrorFormat      string // error
+	RedrawPaused         string
+	NewTodoLabel         string
+	NewTodosLabel        string
+	EditTodoLabel        string
+	SearchLabel          string
+	BlockedByLabel       string
+	RateLimited          string
+	QuotaExceeded        string
+	Maintenance          string
+	DeletedFormat        string // todo text
+	ShowingIDs           string
+	ShowingPositions     string
+	NewBadge             string
+	BlockedBadge         string
+	StillBlocked         string
+	BlockersFailedFormat string // error
+	ThemeLabelFormat     string // theme names, comma separated
+	ThemeSetFormat       string // theme name
+	ThemeFailedFormat    string // error
+	SnoozeLabel          string
+	SnoozedFormat        string // time the todo reappears
+	SnoozeFailedFormat   string // error
+	Woken                string
+	SnoozedSummaryFormat string // number of todos
+	SnoozedUntilFormat   string // time the todo reappears
+	Refreshed            string
+	PendingWritesFormat  string // number of users with unsaved changes
+	Saved                string
+	NothingToSave        string
+	SaveFailed           string
+
+	// Public key screen
+	KeysTitleFormat       string // username
+	KeysHelp              string
+	KeyInputHelp          string
+	NoKeys                string
+	KeyLabel              string
+	KeyAdded              string
+	KeyRemoved            string
+	KeyAddFailedFormat    string // error
+	KeyRemoveFailedFormat string // error
+
+	// Focus screen
+	FocusHelp           string
+	FocusPositionFormat string // position, number of todos
+	FocusActive         string
+	FocusCompleted      string
+	FocusPriorityFormat string // priority
+	FocusDueFormat      string // due date
+	FocusTagsFormat     string // tags, comma separated
+	FocusCreatedFormat  string // creation date
+
+	// Activity screen
+	ActivityTitleFormat string // username
+	ActivityHelp        string
+	NoActivity          string
+	ActivityEntryFormat string // time, action, todo text
+	ActivityCreated     string
+	ActivityCompleted   string
+	ActivityReopened    string
+	ActivityDeleted     string
+
+	// Two-factor authentication
+	TOTPTitle              string
+	TOTPPrompt             string
+	TOTPLabel              string
+	TOTPInvalid            string
+	TOTPTooManyAttempts    string
+	TOTPOn                 string
+	TOTPOff                string
+	TOTPEnrollFormat       string // base32 secret
+	TOTPEnrollHelp         string
+	TOTPEnabled            string
+	TOTPNotEnabled         string
+	TOTPDisabled           string
+	TOTPChangeFailedFormat string // error
+
+	// Registration
+	Welcome                  string
+	RegisterGreetingFormat   string // username
+	SetPasswordPrompt        string
+	PasswordRuleFormat       string // requirements, comma separated
+	PasswordLabel            string
+	ConfirmPasswordLabel     string
+	PasswordRejectedFormat   string // error listing unmet requirements
+	PasswordMismatch         string
+	RegistrationFailedFormat string // error
+	RegistrationBusy         string
+	RegistrationSuccess      string
+	RegistrationCancelled    string
+	RegistrationExpired      string
+	ResumeRegistrationFormat string // username
+	ResumePrompt             string
+
+	// Session end
+	Goodbye        string
+	IdleLogout     string
+	SessionExpired string
+
+	// Shown instead of any screen that doesn't fit
+	TerminalTooSmallFormat string // minimum width, minimum height
+}
+
+// DefaultStrings are the English messages used unless SetStrings is called
+var DefaultStrings = Strings{
+	ListTitleFormat:      "Todo List - User: %s",
+	ListStatsFormat:      " (%d/%d done • %d completed all-time)",
+	ListHelp:             "Commands: ↑/↓: Navigate • Space: Toggle • Enter: Edit • Tab: New • a: Add several • Delete: Remove • -/+: Move • /: Search • b: Blocked by • t: Today • s: Snooze • z: Snoozed • i: IDs • c: Theme • k: Keys • .: Focus • l: Activity • w: Save • Ctrl+R: Reload • Ctrl+C: Exit",
+	InputHelp:            "Commands: ←/→: Move cursor • Enter: Save • Tab: Cancel • Ctrl+C: Exit",
+	EmptyList:            "No todos yet. Press Tab to add one.",
+	SearchSummaryFormat:  "Search: %s (%d found, / then Enter to clear)",
+	TodaySummaryFormat:   "Today: due, overdue or created today (%d found, t to show all)",
+	LoadErrorFormat:      "Error loading todos: %v",
+	RedrawPaused:         "Your todos can't be loaded right now. Press any key to try again, or Ctrl+C to exit.",
+	NewTodoLabel:         "New todo: ",
+	NewTodosLabel:        "Next todo (empty or Esc to finish): ",
+	EditTodoLabel:        "Edit todo: ",
+	SearchLabel:          "Search: ",
+	BlockedByLabel:       "Blocked by IDs (empty for none): ",
+	RateLimited:          "Slow down! You're adding todos too quickly.",
+	QuotaExceeded:        "Your todo list is full. Delete some todos to make room.",
+	Maintenance:          "Maintenance mode: your todos are read-only for now.",
+	DeletedFormat:        "Deleted: %s",
+	ShowingIDs:           "Showing todo IDs.",
+	ShowingPositions:     "Showing list positions.",
+	NewBadge:             "NEW",
+	BlockedBadge:         "BLOCKED",
+	StillBlocked:         "This todo is waiting on others. Complete them first.",
+	BlockersFailedFormat: "Could not set blockers: %v",
+	ThemeLabelFormat:     "Theme (%s): ",
+	ThemeSetFormat:       "Using the %s theme.",
+	ThemeFailedFormat:    "Could not change theme: %v",
+	SnoozeLabel:          "Snooze for, e.g. 2h or 3d (empty to wake): ",
+	SnoozedFormat:        "Snoozed until %s.",
+	SnoozeFailedFormat:   "Could not snooze: %v",
+	Woken:                "Todo is back in the list.",
+	SnoozedSummaryFormat: "Snoozed: hidden until their time comes (%d found, z to show all)",
+	SnoozedUntilFormat:   "(until %s)",
+	Refreshed:            "Reloaded from disk.",
+	PendingWritesFormat:  "Unsaved changes for %d user(s); w to save now",
+	Saved:                "Saved.",
+	NothingToSave:        "Everything is already saved.",
+	SaveFailed:           "Could not save; changes are kept in memory and retried on the next save.",
+
+	KeysTitleFormat:       "Public Keys - User: %s",
+	KeysHelp:              "Commands: ↑/↓: Navigate • a: Add • Delete: Remove • t: Two-factor • Tab: Back • Ctrl+C: Exit",
+	KeyInputHelp:          "Commands: Paste an authorized_keys line • Enter: Save • Tab: Cancel • Ctrl+C: Exit",
+	NoKeys:                "No public keys yet. Press a to add one for passwordless login.",
+	KeyLabel:              "Public key: ",
+	KeyAdded:              "Key added.",
+	KeyRemoved:            "Key removed.",
+	KeyAddFailedFormat:    "Could not add key: %v",
+	KeyRemoveFailedFormat: "Could not remove key: %v",
+
+	FocusHelp:           "Commands: ←/→: Previous/next todo • Esc: Back to list • Ctrl+C: Exit",
+	FocusPositionFormat: "Todo %d of %d",
+	FocusActive:         "Not done yet",
+	FocusCompleted:      "Completed",
+	FocusPriorityFormat: "Priority: %d",
+	FocusDueFormat:      "Due: %s",
+	FocusTagsFormat:     "Tags: %s",
+	FocusCreatedFormat:  "Created: %s",
+
+	ActivityTitleFormat: "Recent Activity - User: %s",
+	ActivityHelp:        "Commands: ↑/↓: Scroll • Esc: Back to list • Ctrl+C: Exit",
+	NoActivity:          "Nothing yet. Changes you make from now on are listed here.",
+	ActivityEntryFormat: "%s  %-9s  %s",
+	ActivityCreated:     "Added",
+	ActivityCompleted:   "Completed",
+	ActivityReopened:    "Reopened",
+	ActivityDeleted:     "Deleted",
+
+	TOTPTitle:              "Two-factor authentication",
+	TOTPPrompt:             "Enter the 6-digit code from your authenticator app.",
+	TOTPLabel:              "Code: ",
+	TOTPInvalid:            "Invalid code, try again.",
+	TOTPTooManyAttempts:    "Too many invalid codes. Goodbye!",
+	TOTPOn:                 "Two-factor authentication: on (t to turn off)",
+	TOTPOff:                "Two-factor authentication: off (t to turn on)",
+	TOTPEnrollFormat:       "Add this secret to your authenticator app, then enter the code it shows: %s",
+	TOTPEnrollHelp:         "Commands: Enter: Turn on two-factor • Tab: Cancel • Ctrl+C: Exit",
+	TOTPEnabled:            "Two-factor enabled.",
+	TOTPNotEnabled:         "Invalid code; two-factor is still off.",
+	TOTPDisabled:           "Two-factor disabled.",
+	TOTPChangeFailedFormat: "Could not change two-factor setting: %v",
+
+	Welcome:                  "Welcome to TodoiSSH!",
+	RegisterGreetingFormat:   "Hello, %s! You need to complete registration.",
+	SetPasswordPrompt:        "Please set a password for your account.",
+	PasswordRuleFormat:       "Password must %s.",
+	PasswordLabel:            "Password: ",
+	ConfirmPasswordLabel:     "Confirm password: ",
+	PasswordRejectedFormat:   "Password rejected: %v. Press any key to continue.",
+	PasswordMismatch:         "Passwords do not match. Press any key to start over.",
+	RegistrationFailedFormat: "Registration failed: %v. Press any key to exit.",
+	RegistrationBusy:         "The server is busy signing up other users. Press any key, then Enter to try again.",
+	RegistrationSuccess:      "Registration successful! Press any key to continue.",
+	RegistrationCancelled:    "Registration cancelled. Goodbye!",
+	RegistrationExpired:      "Your registration expired. Press any key to start over.",
+	ResumeRegistrationFormat: "Welcome back, %s! Your registration is not finished yet.",
+	ResumePrompt:             "Confirm the password you chose to finish, or press Ctrl+C to cancel it.",
+
+	Goodbye:        "Goodbye!",
+	IdleLogout:     "Logged out after a period of inactivity.",
+	SessionExpired: "Session expired.",
+
+	TerminalTooSmallFormat: "Terminal too small, resize to at least %dx%d",
+}
+
+// SetGreetings sets the message shown on the registration screen and the
+// one shown when the session ends. An empty message is not shown at all.
+func (t *TerminalUI) SetGreetings(welcome, goodbye string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.strings.Welcome = welcome
+	t.strings.Goodbye = goodbye
+}
+
+// SetStrings replaces the messages shown by the UI. Start from
+// DefaultStrings and override the fields you need, since empty fields are
+// shown as empty.
+func (t *TerminalUI) SetStrings(strings Strings) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.strings = strings
+}
@@ -1,12 +1,18 @@
 package ui
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"todoissh/pkg/todo"
 	"todoissh/pkg/user"
@@ -21,35 +27,127 @@ const (
 	ModeNormal UIMode = iota
 	ModeInput
 	ModeRegister
+	ModeKeys
+	ModeTOTP
+	ModeFocus
+	ModeActivity
+)
+
+// inputAction identifies what the text in the input field is for
+type inputAction int
+
+const (
+	inputAdd      inputAction = iota // New todo
+	inputAddMany                     // New todos, one after another until cancelled
+	inputEdit                        // Edit the selected todo
+	inputKey                         // Add a public key
+	inputTOTP                        // Confirm a new two-factor secret
+	inputSearch                      // Filter the list
+	inputBlockers                    // Set what the selected todo is blocked by
+	inputTheme                       // Pick a theme by name
+	inputSnooze                      // Snooze the selected todo
+)
+
+// DefaultMaxInputLength is the default cap on the length of typed input
+const DefaultMaxInputLength = 1024
+
+// maxRefreshFailures is how many times in a row the todo list may fail to
+// load before the UI stops redrawing it
+const maxRefreshFailures = 3
+
+// quitSignals are the signals that end the session like Ctrl+C
+var quitSignals = map[string]bool{
+	string(ssh.SIGINT):  true,
+	string(ssh.SIGTERM): true,
+	string(ssh.SIGHUP):  true,
+	string(ssh.SIGQUIT): true,
+}
+
+// Limits applied when parsing terminal size requests
+const (
+	maxTermNameLength = 256
+	maxTermDimension  = 10000
+)
+
+// Smallest terminal size the screens are laid out for. Smaller terminals
+// are laid out as this size but only shown TerminalTooSmallFormat.
+const (
+	minTermWidth  = 20
+	minTermHeight = 5
 )
 
 // TerminalUI represents a terminal user interface
 type TerminalUI struct {
-	channel       ssh.Channel
-	width         int
-	height        int
-	mutex         sync.Mutex
-	todos         []*todo.Todo
-	selected      int
-	mode          UIMode
-	inputText     string
-	inputLabel    string
-	cursorPos     int
-	todoStore     *todo.Store
-	userStore     *user.Store
-	username      string
-	isRegistering bool
-	registerStep  int
-	password      string
+	channel        ssh.Channel
+	width          int
+	height         int
+	tooSmall       bool // reported size is below minTermWidth x minTermHeight
+	mutex          sync.Mutex
+	todos          []*todo.Todo
+	selected       int
+	mode           UIMode
+	inputText      string
+	inputLabel     string
+	inputAction    inputAction
+	cursorPos      int
+	todoStore      todo.TodoStore
+	userStore      user.UserStore
+	username       string
+	isRegistering  bool
+	registerStep   int
+	password       string
+	resuming       bool // Registration resumed from an earlier session
+	totpAttempts   int
+	totpSecret     string // Shown while a new secret waits for its first code
+	theme          Theme
+	colors         bool
+	maxInput       int
+	rowTemplate    *template.Template
+	bindings       map[string]Action // Keys of the list; see SetKeymap
+	inputLayout    InputLayout       // Where and how the input field is drawn
+	showIDs        bool              // Number rows by todo ID instead of position
+	env            map[string]string // Variables forwarded by the client
+	term           string            // Terminal type from the pty request
+	forceASCII     bool              // Always replace non-ASCII symbols
+	ascii          atomic.Bool       // Replace non-ASCII symbols in output
+	newSince       time.Time         // Todos created between these times get a NEW badge
+	newUntil       time.Time
+	status         string // One-off message shown on the next refresh
+	keySelected    int    // Selected entry in the public key list
+	activityOffset int    // Entries scrolled past on the activity screen
+	listOffset     int    // Todos scrolled past at the top of the list
+	filter         string // Active search query; empty shows all todos
+	today          bool   // Only show todos due or created today
+	snoozed        bool   // Only show snoozed todos
+	autoAdd        bool   // Start adding a todo when the list is first shown empty
+	restoreID      int    // Select this todo once the list is loaded; 0 keeps the top
+	targetID       int    // Todo an open edit, blocked-by or snooze input applies to
+	interrupted    bool   // Ended by Ctrl+C, reported to the client as SIGINT
+
+	refreshFailures int // Consecutive failures to load the todo list
+
+	// Input reading and session limits
+	input       chan inputEvent
+	inputErr    error
+	done        chan struct{}
+	expired     <-chan time.Time
+	interrupt   chan struct{} // Signalled by the client to end the session
+	writeFailed atomic.Bool   // Set once output can't be written; the session then ends
+	writeClosed chan struct{} // Closed when writeFailed is set
+	closeWrite  sync.Once
+	idleTimeout time.Duration
+	maxDuration time.Duration
+
+	strings Strings
 }
 
 // NewTerminalUI creates a new terminal UI instance
-func NewTerminalUI(channel ssh.Channel, todoStore *todo.Store, userStore *user.Store, username string, isNewUser bool) *TerminalUI {
+func NewTerminalUI(channel ssh.Channel, todoStore todo.TodoStore, userStore user.UserStore, username string, isNewUser bool) *TerminalUI {
 	ui := &TerminalUI{
 		channel:       channel,
 		selected:      0,
 		mode:          ModeNormal,
-		inputLabel:    "New todo: ",
+		inputLabel:    DefaultStrings.NewTodoLabel,
 		width:         80,
 		height:        24,
 		cursorPos:     0,
@@ -58,11 +156,31 @@ func NewTerminalUI(channel ssh.Channel, todoStore *todo.Store, userStore *user.S
 		username:      username,
 		isRegistering: isNewUser,
 		registerStep:  0,
+		theme:         DefaultTheme,
+		colors:        true,
+		maxInput:      DefaultMaxInputLength,
+		rowTemplate:   defaultRowTemplate,
+		bindings:      defaultBindings,
+		inputLayout:   DefaultInputLayout,
+		interrupt:     make(chan struct{}, 1),
+		writeClosed:   make(chan struct{}),
+
+		strings: DefaultStrings,
 	}
 
-	// If this is a new user, start in registration mode
+	// If this is a new user, start in registration mode, resuming at the
+	// confirmation step if they already chose a password
 	if isNewUser {
 		ui.mode = ModeRegister
+		if _, pending := userStore.PendingRegistration(username); pending {
+			ui.registerStep = 1
+			ui.resuming = true
+		}
+	} else if userStore.HasTOTP(username) {
+		// Enrolled users must enter a code before seeing their todos
+		ui.mode = ModeTOTP
+	} else {
+		ui.markViewed()
 	}
 
 	return ui
@@ -70,59 +188,330 @@ func NewTerminalUI(channel ssh.Channel, todoStore *todo.Store, userStore *user.S
 
 // HandleChannel handles the SSH channel and requests
 func (t *TerminalUI) HandleChannel(requests <-chan *ssh.Request) {
+	t.HandleChannelContext(context.Background(), requests)
+}
+
+// HandleChannelContext handles the SSH channel and requests until the
+// session ends or ctx is cancelled, in which case the channel is closed
+// to unblock the input loop.
+func (t *TerminalUI) HandleChannelContext(ctx context.Context, requests <-chan *ssh.Request) {
 	defer t.channel.Close()
 
-	// Initialize terminal
-	t.write("\x1b[?1049h") // Use alternate screen buffer
-	t.write("\x1b[?7l")    // Disable line wrapping
-	defer func() {
-		t.write("\x1b[?25h")                                            // Show cursor
-		t.write("\x1b[?7h")                                             // Enable line wrapping
-		t.write("\x1b[?1049l")                                          // Restore main screen
-		t.write("Goodbye!\r\n")                                         // Always show goodbye message
-		t.channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0}) // Send exit code 0
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.channel.Close()
+		case <-done:
+		}
 	}()
 
 	for req := range requests {
-		switch req.Type {
-		case "shell":
-			if len(req.Payload) > 0 {
-				req.Reply(false, nil)
-				continue
+		if req.Type == "exec" {
+			// A command instead of the full-screen UI
+			t.handleExec(req)
+			return
+		}
+		if req.Type != "shell" {
+			t.handleRequest(req)
+			continue
+		}
+		if len(req.Payload) > 0 {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		// Initialize terminal
+		t.write("\x1b[?1049h") // Use alternate screen buffer
+		t.write("\x1b[?7l")    // Disable line wrapping
+
+		defer t.saveSelection() // Resume at the same todo next time
+		defer func() {
+			t.write("\x1b[?25h")           // Show cursor
+			t.write("\x1b[?7h")            // Enable line wrapping
+			t.write("\x1b[?1049l")         // Restore main screen
+			t.writeLine(t.strings.Goodbye) // Goodbye message, unless suppressed
+			if t.interrupted {
+				// Tell clients the session was interrupted, not quit
+				t.channel.SendRequest("exit-signal", false, exitSignalPayload("INT"))
+				return
 			}
-			req.Reply(true, nil)
-			t.refreshDisplay()
-			if err := t.handleInput(); err != nil {
-				if err != io.EOF {
-					log.Printf("Error handling input: %v", err)
-					t.channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1}) // Send exit code 1 for errors
-				}
+			t.channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0}) // Send exit code 0
+		}()
+
+		// Keep answering window changes and signals while the session runs
+		go func() {
+			for req := range requests {
+				t.handleRequest(req)
 			}
-			return
-		case "pty-req":
-			width, height := parsePtyRequest(req.Payload)
+		}()
+
+		t.refreshDisplay()
+		if err := t.handleInput(); err != nil {
+			if err != io.EOF {
+				log.Printf("Error handling input: %v", err)
+				t.channel.SendRequest("exit-status", false, []byte{0, 0, 0, 1}) // Send exit code 1 for errors
+			}
+		}
+		return
+	}
+}
+
+// handleRequest answers a channel request other than the one starting the shell
+func (t *TerminalUI) handleRequest(req *ssh.Request) {
+	switch req.Type {
+	case "pty-req":
+		term, width, height, ok := parsePtyRequest(req.Payload)
+		if ok {
 			t.setSize(width, height)
-			req.Reply(true, nil)
-		case "window-change":
-			width, height := parseWinchRequest(req.Payload)
+			t.setTerm(term)
+		}
+		req.Reply(ok, nil)
+	case "env":
+		name, value, ok := parseEnvRequest(req.Payload)
+		if ok {
+			t.setEnv(name, value)
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	case "window-change":
+		if width, height, ok := parseWinchRequest(req.Payload); ok {
 			t.setSize(width, height)
-		default:
-			if req.WantReply {
-				req.Reply(false, nil)
-			}
+		}
+	case "signal":
+		name, ok := parseSignalRequest(req.Payload)
+		if ok {
+			t.handleSignal(name)
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	default:
+		if req.WantReply {
+			req.Reply(false, nil)
 		}
 	}
 }
 
+// handleSignal acts on a signal sent by the client. Signals asking the
+// program to stop end the session as if Ctrl+C was pressed; others are
+// ignored, since there is no process to deliver them to.
+func (t *TerminalUI) handleSignal(name string) {
+	if !quitSignals[name] {
+		log.Printf("Ignoring signal %s from %s", name, t.username)
+		return
+	}
+	log.Printf("Received signal %s from %s, ending session", name, t.username)
+	select {
+	case t.interrupt <- struct{}{}:
+	default: // Already pending
+	}
+}
+
+// SetColors enables or disables colored output. When colors are off,
+// due-date highlighting falls back to textual markers.
+func (t *TerminalUI) SetColors(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.colors = enabled
+}
+
+// ApplyPreferences applies a user's saved preferences to the UI
+func (t *TerminalUI) ApplyPreferences(prefs user.Preferences) {
+	if prefs.Colors != nil {
+		t.SetColors(*prefs.Colors)
+	}
+	t.SetShowIDs(prefs.ShowIDs)
+	if prefs.Theme != "" {
+		if err := t.SetThemeByName(prefs.Theme); err != nil {
+			log.Printf("Ignoring theme for %s: %v", t.username, err)
+		}
+	}
+	if prefs.RowTemplate != "" {
+		if err := t.SetRowTemplate(prefs.RowTemplate); err != nil {
+			log.Printf("Ignoring row template for %s: %v", t.username, err)
+		}
+	}
+	t.mutex.Lock()
+	t.restoreID = prefs.SelectedID
+	t.mutex.Unlock()
+}
+
+// saveSelection remembers the selected todo in the user's preferences, so
+// the next session can start where this one left off
+func (t *TerminalUI) saveSelection() {
+	u := t.userStore.GetUser(t.username)
+	if u == nil || t.mode == ModeRegister || t.mode == ModeTOTP {
+		return
+	}
+	id := 0
+	if t.selected < len(t.todos) {
+		id = t.todos[t.selected].ID
+	}
+	if id == u.Preferences.SelectedID {
+		return
+	}
+	prefs := u.Preferences
+	prefs.SelectedID = id
+	if err := t.userStore.SetPreferences(t.username, prefs); err != nil {
+		log.Printf("Error saving selection for %s: %v", t.username, err)
+	}
+}
+
+// markViewed records that the user opened their list, so todos created
+// since their previous visit can be badged as new
+func (t *TerminalUI) markViewed() {
+	if t.userStore.GetUser(t.username) == nil {
+		return
+	}
+	now := time.Now()
+	prev, err := t.userStore.MarkViewed(t.username, now)
+	if err != nil {
+		log.Printf("Error recording last viewed time for %s: %v", t.username, err)
+		return
+	}
+	t.newSince, t.newUntil = prev, now
+}
+
+// SetShowIDs numbers rows by their stored todo ID instead of their position
+// in the list
+func (t *TerminalUI) SetShowIDs(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.showIDs = enabled
+}
+
+// toggleShowIDs switches between IDs and positions, saving the choice in
+// the user's preferences
+func (t *TerminalUI) toggleShowIDs() {
+	t.showIDs = !t.showIDs
+	if t.showIDs {
+		t.status = t.strings.ShowingIDs
+	} else {
+		t.status = t.strings.ShowingPositions
+	}
+
+	if u := t.userStore.GetUser(t.username); u != nil {
+		prefs := u.Preferences
+		prefs.ShowIDs = t.showIDs
+		if err := t.userStore.SetPreferences(t.username, prefs); err != nil {
+			log.Printf("Error saving preferences for %s: %v", t.username, err)
+		}
+	}
+}
+
+// SetEmptyListMessage sets the call to action shown instead of an empty
+// list. An empty message is not shown at all.
+func (t *TerminalUI) SetEmptyListMessage(message string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.strings.EmptyList = message
+}
+
+// SetAutoAdd makes the session open the new todo input right away when the
+// list is empty the first time it's shown, so new users can start typing
+func (t *TerminalUI) SetAutoAdd(enabled bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.autoAdd = enabled
+}
+
+// beginAdd switches to the input field for a new todo
+func (t *TerminalUI) beginAdd() {
+	t.mode = ModeInput
+	t.inputLabel = t.strings.NewTodoLabel
+	t.inputAction = inputAdd
+	t.inputText = ""
+	t.cursorPos = 0
+}
+
+// saveNow writes out changes that couldn't be saved when they were made,
+// reporting how it went in the status line
+func (t *TerminalUI) saveNow() {
+	switch {
+	case t.todoStore.PendingWrites() == 0:
+		t.status = t.strings.NothingToSave
+	case t.todoStore.ReadOnly():
+		t.status = t.strings.Maintenance
+	default:
+		if err := t.todoStore.FlushAll(); err != nil {
+			log.Printf("Error saving todos: %v", err)
+			t.status = t.strings.SaveFailed
+		} else {
+			t.status = t.strings.Saved
+		}
+	}
+}
+
+// addTodo adds a todo with the given text, reporting whether it was added.
+// Expected failures are explained in the status line.
+func (t *TerminalUI) addTodo(text string) bool {
+	_, err := t.todoStore.Add(t.username, text)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, todo.ErrRateLimited):
+		t.status = t.strings.RateLimited
+	case errors.Is(err, todo.ErrQuotaExceeded):
+		t.status = t.strings.QuotaExceeded
+	case errors.Is(err, todo.ErrReadOnly):
+		t.status = t.strings.Maintenance
+	default:
+		log.Printf("Error adding todo: %v", err)
+	}
+	return false
+}
+
+// SetMaxInputLength caps how many characters can be typed into the input
+// field, including registration passwords. Values below 1 are ignored.
+func (t *TerminalUI) SetMaxInputLength(n int) {
+	if n < 1 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.maxInput = n
+}
+
+// canInsert reports whether another character fits in the input field
+func (t *TerminalUI) canInsert() bool {
+	return len(t.inputText) < t.maxInput
+}
+
 func (t *TerminalUI) setSize(width, height int) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
-	t.width = width
-	t.height = height
+	t.tooSmall = width < minTermWidth || height < minTermHeight
+	t.width = max(width, minTermWidth)
+	t.height = max(height, minTermHeight)
 }
 
 func (t *TerminalUI) write(text string) {
-	t.channel.Write([]byte(text))
+	if t.writeFailed.Load() {
+		return
+	}
+	if t.ascii.Load() {
+		text = asciiReplacer.Replace(text)
+	}
+	if _, err := t.channel.Write([]byte(text)); err != nil {
+		t.closeWrite.Do(func() {
+			log.Printf("Ending session of %s: can't write to the client: %v", t.username, err)
+			t.writeFailed.Store(true)
+			close(t.writeClosed)
+		})
+	}
+}
+
+// writeLine writes a message followed by a line break, or nothing at all
+// if the message is empty
+func (t *TerminalUI) writeLine(message string) {
+	if message == "" {
+		return
+	}
+	t.write(message + "\r\n")
 }
 
 func (t *TerminalUI) clear() {
@@ -140,148 +529,434 @@ func (t *TerminalUI) hideCursor() {
 }
 
 func (t *TerminalUI) moveTo(row, col int) {
-	t.write(fmt.Sprintf("\x1b[%d;%dH", row, col))
+	t.write(fmt.Sprintf("\x1b[%d;%dH", max(row, 1), max(col, 1)))
 }
 
 func (t *TerminalUI) refreshDisplay() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	if t.listBroken() {
+		return
+	}
+
 	t.clear()
 	t.moveTo(1, 1)
 
+	if t.tooSmall {
+		t.write(fmt.Sprintf(t.strings.TerminalTooSmallFormat, minTermWidth, minTermHeight))
+		return
+	}
+
 	if t.mode == ModeRegister {
 		t.displayRegistrationScreen()
 		return
 	}
 
+	if t.mode == ModeTOTP {
+		t.displayTOTPScreen()
+		return
+	}
+
+	if t.mode == ModeFocus {
+		t.displayFocusScreen()
+		return
+	}
+
+	if t.mode == ModeActivity {
+		t.displayActivityScreen()
+		return
+	}
+
+	if t.mode == ModeKeys || (t.mode == ModeInput && t.keysInput()) {
+		t.displayKeysScreen()
+		t.drawInputField()
+		return
+	}
+
+	// Only offer to add right away the first time the list is shown
+	if t.autoAdd && t.mode == ModeNormal {
+		t.autoAdd = false
+		if stats, err := t.todoStore.Stats(t.username); err == nil && stats.Total == 0 {
+			t.beginAdd()
+		}
+	}
+
 	// Header
-	t.write(fmt.Sprintf("Todo List - User: %s\r\n", t.username))
+	header := fmt.Sprintf(t.strings.ListTitleFormat, t.username)
+	if stats, err := t.todoStore.Stats(t.username); err == nil && stats.TotalCreated > 0 {
+		header += fmt.Sprintf(t.strings.ListStatsFormat, stats.Completed, stats.Total, stats.TotalCompleted)
+	}
+	t.write(header + "\r\n")
 	t.write(strings.Repeat("─", t.width) + "\r\n")
 
 	// Only show commands in input mode
 	if t.mode == ModeInput {
-		t.write("Commands: ←/→: Move cursor • Enter: Save • Tab: Cancel • Ctrl+C: Exit\r\n")
+		t.write(t.strings.InputHelp + "\r\n")
 	} else {
-		t.write("Commands: ↑/↓: Navigate • Space: Toggle • Enter: Edit • Tab: New • Delete: Remove • Ctrl+C: Exit\r\n")
+		t.write(t.strings.ListHelp + "\r\n")
+	}
+	headerLines := 4 // Title, rule, help and the blank line below
+	if t.todoStore.ReadOnly() {
+		t.write(t.strings.Maintenance + "\r\n")
+		headerLines++
+	}
+	if n := t.todoStore.PendingWrites(); n > 0 {
+		t.write(fmt.Sprintf(t.strings.PendingWritesFormat, n) + "\r\n")
+		headerLines++
 	}
 	t.write("\r\n")
 
-	// Get and sort todos
-	todos, err := t.todoStore.List(t.username)
+	// Get todos in display order, matching the search if one is active
+	todos, err := t.loadTodos()
 	if err != nil {
-		t.write(fmt.Sprintf("Error loading todos: %v\r\n", err))
+		t.refreshFailures++
+		if t.refreshFailures >= maxRefreshFailures {
+			// Leave a single static error up instead of redrawing every time
+			log.Printf("Todos for %s failed to load %d times in a row, pausing redraws: %v", t.username, t.refreshFailures, err)
+			t.clear()
+			t.moveTo(1, 1)
+			t.write(fmt.Sprintf(t.strings.LoadErrorFormat, err) + "\r\n")
+			t.writeLine(t.strings.RedrawPaused)
+			return
+		}
+		t.write(fmt.Sprintf(t.strings.LoadErrorFormat, err) + "\r\n")
 		return
 	}
+	t.refreshFailures = 0
 	t.todos = todos
-	sort.Slice(t.todos, func(i, j int) bool {
-		return t.todos[i].ID < t.todos[j].ID
-	})
 
 	// Print todos
-	if len(t.todos) == 0 {
-		t.write("No todos yet. Press Tab to add one.\r\n")
+	if t.filter != "" {
+		t.write(fmt.Sprintf(t.strings.SearchSummaryFormat, t.filter, len(t.todos)) + "\r\n\r\n")
+		headerLines += 2
+	} else if t.snoozed {
+		t.write(fmt.Sprintf(t.strings.SnoozedSummaryFormat, len(t.todos)) + "\r\n\r\n")
+		headerLines += 2
+	} else if t.today {
+		t.write(fmt.Sprintf(t.strings.TodaySummaryFormat, len(t.todos)) + "\r\n\r\n")
+		headerLines += 2
+	}
+	if t.restoreID != 0 {
+		for i, item := range t.todos {
+			if item.ID == t.restoreID {
+				t.selected = i
+			}
+		}
+		t.restoreID = 0
+	}
+	t.selected = min(t.selected, max(0, len(t.todos)-1))
+	if len(t.todos) == 0 && t.filter == "" && !t.today && !t.snoozed {
+		t.writeLine(t.strings.EmptyList)
 	} else {
-		for i, todo := range t.todos {
+		now := time.Now()
+		loc := t.todoStore.Location()
+		visible := t.scrollList(headerLines)
+		for i := t.listOffset; i < min(t.listOffset+visible, len(t.todos)); i++ {
+			item := t.todos[i]
 			prefix := "  "
 			if i == t.selected && t.mode == ModeNormal {
 				prefix = "> "
 			}
 			status := "[ ]"
-			if todo.Completed {
+			if item.Completed {
 				status = "[✓]"
 			}
-			t.write(fmt.Sprintf("%s%s %d. %s\r\n", prefix, status, i+1, todo.Text))
+			index := i + 1
+			if t.showIDs {
+				index = item.ID
+			}
+			line := t.renderRow(newRow(item, index, prefix, status, loc))
+			if !t.newSince.IsZero() && item.CreatedAt.After(t.newSince) && item.CreatedAt.Before(t.newUntil) {
+				line += " " + t.strings.NewBadge
+			}
+			if t.snoozed && item.SnoozedUntil != nil {
+				line += " " + fmt.Sprintf(t.strings.SnoozedUntilFormat, item.SnoozedUntil.In(loc).Format(dueFormat))
+			}
+			if !item.Completed && len(item.BlockedBy) > 0 {
+				if blocked, err := t.todoStore.IsBlocked(t.username, item.ID); err == nil && blocked {
+					line += " " + t.strings.BlockedBadge
+				}
+			}
+			due := todo.ClassifyDue(item, now)
+			t.write(t.decorateDue(line, due) + "\r\n")
+
+			// Show the full text of a selected todo that didn't fit
+			width := t.width - utf8.RuneCountInString(t.dueMarker(due))
+			if prefix == "> " && utf8.RuneCountInString(line) > width {
+				for _, part := range wrap(item.Text, t.width-detailIndent) {
+					t.write(strings.Repeat(" ", detailIndent) + part + "\r\n")
+				}
+			}
 		}
 	}
 
-	// Input field
+	// One-off status message
+	if t.status != "" {
+		t.write("\r\n" + t.status + "\r\n")
+		t.status = ""
+	}
+
+	t.drawInputField()
+}
+
+// scrollList moves the list's scroll offset just enough to keep the
+// selected todo on screen, returning how many todos fit between the header
+// and the input field
+func (t *TerminalUI) scrollList(headerLines int) int {
+	visible := max(t.inputRow()-2-headerLines, 1)
+	if t.selected < t.listOffset {
+		t.listOffset = t.selected
+	}
+	if t.selected >= t.listOffset+visible {
+		t.listOffset = t.selected - visible + 1
+	}
+	t.listOffset = max(min(t.listOffset, len(t.todos)-visible), 0)
+	return visible
+}
+
+// jumpToStart selects the first entry of the current list, or moves the
+// cursor to the start of the line in input mode
+func (t *TerminalUI) jumpToStart() {
+	switch t.mode {
+	case ModeNormal:
+		t.selected = 0
+	case ModeKeys:
+		t.keySelected = 0
+	case ModeInput:
+		t.cursorPos = 0
+	}
+}
+
+// jumpToEnd selects the last entry of the current list, or moves the
+// cursor to the end of the line in input mode
+func (t *TerminalUI) jumpToEnd() {
+	switch t.mode {
+	case ModeNormal:
+		t.selected = max(0, len(t.todos)-1)
+	case ModeKeys:
+		t.keySelected = max(0, len(t.userStore.AuthorizedKeys(t.username))-1)
+	case ModeInput:
+		t.cursorPos = len(t.inputText)
+	}
+}
+
+// loadTodos returns the todos to list in display order, matching the
+// search or today view if one is active
+func (t *TerminalUI) loadTodos() ([]*todo.Todo, error) {
+	var todos []*todo.Todo
+	var err error
+	switch {
+	case t.filter != "":
+		todos, err = t.todoStore.SearchAll(t.username, t.filter, todo.SearchAllFields)
+	case t.snoozed:
+		todos, err = t.todoStore.ListSnoozed(t.username)
+	case t.today:
+		todos, err = t.todoStore.ListToday(t.username)
+	default:
+		todos, err = t.todoStore.List(t.username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	todo.SortByPosition(todos)
+	return todos, nil
+}
+
+// listBroken reports whether the todo list has failed to load too many
+// times in a row, in which case the static error already on screen is left
+// alone. Each call retries the load, so the list returns once the store
+// recovers. We assume the caller already has the lock.
+func (t *TerminalUI) listBroken() bool {
+	listing := t.mode == ModeNormal || (t.mode == ModeInput && !t.keysInput())
+	if t.refreshFailures < maxRefreshFailures || !listing {
+		return false
+	}
+	if _, err := t.loadTodos(); err != nil {
+		return true
+	}
+	t.refreshFailures = 0
+	return false
+}
+
+// drawInputField draws the input line near the bottom of the screen, as
+// the input layout says, in input mode, and hides the cursor otherwise
+func (t *TerminalUI) drawInputField() {
 	if t.mode == ModeInput {
-		t.moveTo(t.height-2, 1)
-		t.write(strings.Repeat("─", t.width) + "\r\n")
-		t.moveTo(t.height-1, 1)
+		row := t.inputRow()
+		if t.inputLayout.Separator != "" && row > 1 {
+			t.moveTo(row-1, 1)
+			t.write(strings.Repeat(t.inputLayout.Separator, t.width) + "\r\n")
+		}
+		t.moveTo(row, 1)
 		t.write(fmt.Sprintf("%s%s", t.inputLabel, t.inputText))
 		t.showCursor()
-		t.moveTo(t.height-1, len(t.inputLabel)+t.cursorPos+1)
+		t.moveTo(row, utf8.RuneCountInString(t.inputLabel)+t.cursorPos+1)
 	} else {
 		t.hideCursor()
 	}
 }
 
+// decorateDue highlights a todo line according to its due status, using
+// theme colors when enabled and a textual suffix otherwise. The line is
+// truncated so the result fits the terminal width.
+func (t *TerminalUI) decorateDue(line string, status todo.DueStatus) string {
+	var color string
+	switch status {
+	case todo.DueOverdue:
+		color = t.theme.Overdue
+	case todo.DueSoon:
+		color = t.theme.DueSoon
+	default:
+		return truncate(line, t.width)
+	}
+	if !t.colors {
+		marker := t.dueMarker(status)
+		return truncate(line, t.width-utf8.RuneCountInString(marker)) + marker
+	}
+	return color + truncate(line, t.width) + t.theme.Reset
+}
+
+// dueMarker returns the textual suffix marking a due status when colors
+// are disabled
+func (t *TerminalUI) dueMarker(status todo.DueStatus) string {
+	if t.colors {
+		return ""
+	}
+	switch status {
+	case todo.DueOverdue:
+		return " (!)"
+	case todo.DueSoon:
+		return " (soon)"
+	}
+	return ""
+}
+
 func (t *TerminalUI) displayRegistrationScreen() {
 	// Registration header
-	t.write("Welcome to TodoiSSH!\r\n")
+	t.writeLine(t.strings.Welcome)
 	t.write(strings.Repeat("─", t.width) + "\r\n\r\n")
 
-	t.write(fmt.Sprintf("Hello, %s! You need to complete registration.\r\n\r\n", t.username))
+	if t.resuming {
+		t.write(fmt.Sprintf(t.strings.ResumeRegistrationFormat, t.username) + "\r\n\r\n")
+	} else {
+		t.write(fmt.Sprintf(t.strings.RegisterGreetingFormat, t.username) + "\r\n\r\n")
+	}
 
 	switch t.registerStep {
 	case 0: // Set password
-		t.write("Please set a password for your account.\r\n")
-		t.write("Password must be at least 6 characters long.\r\n\r\n")
-		t.write("Password: ")
+		t.write(t.strings.SetPasswordPrompt + "\r\n")
+		if reqs := t.userStore.PasswordPolicy().Requirements(); len(reqs) > 0 {
+			t.write(fmt.Sprintf(t.strings.PasswordRuleFormat, strings.Join(reqs, ", ")) + "\r\n")
+		}
+		t.write("\r\n")
+		t.write(t.strings.PasswordLabel)
 		if len(t.inputText) > 0 {
 			t.write(strings.Repeat("*", len(t.inputText)))
 		}
-		t.showCursor()
-		t.moveTo(9, 10+len(t.inputText)) // Position cursor after password
+		t.showCursor() // Cursor is left right after the password
 	case 1: // Confirm password
-		t.write("Please set a password for your account.\r\n")
-		t.write("Password: " + strings.Repeat("*", len(t.password)) + "\r\n\r\n")
-		t.write("Confirm password: ")
+		if t.resuming {
+			t.write(t.strings.ResumePrompt + "\r\n\r\n")
+		} else {
+			t.write(t.strings.SetPasswordPrompt + "\r\n")
+			t.write(t.strings.PasswordLabel + strings.Repeat("*", len(t.password)) + "\r\n\r\n")
+		}
+		t.write(t.strings.ConfirmPasswordLabel)
 		if len(t.inputText) > 0 {
 			t.write(strings.Repeat("*", len(t.inputText)))
 		}
-		t.showCursor()
-		t.moveTo(10, 18+len(t.inputText)) // Position cursor after confirm password
+		t.showCursor() // Cursor is left right after the confirmation
 	}
 }
 
+// waitForKey waits for any key after a message, reading the whole escape
+// sequence of keys such as the arrows so none of it is taken as later
+// input. It reports false if the session ended instead.
+func (t *TerminalUI) waitForKey() bool {
+	b, err := t.readByte()
+	if err == nil {
+		_, err = t.readKey(b)
+	}
+	return err == nil
+}
+
+// handleRegistration completes the current registration step, reporting
+// whether the session should end
 func (t *TerminalUI) handleRegistration() bool {
 	switch t.registerStep {
 	case 0: // Set password
-		if len(t.inputText) < 6 {
+		// Remember the chosen password so registration can be resumed later
+		err := t.userStore.StartRegistration(t.username, t.inputText)
+		if errors.Is(err, user.ErrBusy) {
+			// Keep the password so Enter tries again
+			t.clear()
+			t.moveTo(1, 1)
+			t.write(t.strings.RegistrationBusy + "\r\n")
+			return !t.waitForKey()
+		}
+		var policyErr *user.PolicyError
+		if errors.As(err, &policyErr) {
 			t.clear()
 			t.moveTo(1, 1)
-			t.write("Password must be at least 6 characters long. Press any key to continue.\r\n")
-			var buf [1]byte
-			t.channel.Read(buf[:])
+			t.write(fmt.Sprintf(t.strings.PasswordRejectedFormat, policyErr) + "\r\n")
+			if !t.waitForKey() {
+				return true
+			}
 			t.inputText = ""
 			return false
 		}
+		if err != nil {
+			t.clear()
+			t.moveTo(1, 1)
+			t.write(fmt.Sprintf(t.strings.RegistrationFailedFormat, err) + "\r\n")
+			t.waitForKey()
+			return true // Exit
+		}
 		t.password = t.inputText
 		t.inputText = ""
 		t.registerStep = 1
 		return false
 	case 1: // Confirm password
-		if t.inputText != t.password {
+		err := t.userStore.ConfirmRegistration(t.username, t.inputText)
+		if errors.Is(err, user.ErrBusy) {
+			t.clear()
+			t.moveTo(1, 1)
+			t.write(t.strings.RegistrationBusy + "\r\n")
+			return !t.waitForKey()
+		}
+		if errors.Is(err, user.ErrPasswordMismatch) || errors.Is(err, user.ErrNoPendingRegistration) {
+			message := t.strings.PasswordMismatch
+			if errors.Is(err, user.ErrNoPendingRegistration) {
+				message = t.strings.RegistrationExpired
+			}
 			t.clear()
 			t.moveTo(1, 1)
-			t.write("Passwords do not match. Press any key to start over.\r\n")
-			var buf [1]byte
-			t.channel.Read(buf[:])
+			t.write(message + "\r\n")
+			if !t.waitForKey() {
+				return true
+			}
 			t.inputText = ""
+			t.password = ""
 			t.registerStep = 0
+			t.resuming = false
 			return false
 		}
-
-		// Register the user
-		err := t.userStore.Register(t.username, t.password)
 		if err != nil {
 			t.clear()
 			t.moveTo(1, 1)
-			t.write(fmt.Sprintf("Registration failed: %v. Press any key to exit.\r\n", err))
-			var buf [1]byte
-			t.channel.Read(buf[:])
+			t.write(fmt.Sprintf(t.strings.RegistrationFailedFormat, err) + "\r\n")
+			t.waitForKey()
 			return true // Exit
 		}
 
 		// Registration successful
 		t.clear()
 		t.moveTo(1, 1)
-		t.write("Registration successful! Press any key to continue.\r\n")
-		var buf [1]byte
-		t.channel.Read(buf[:])
+		t.write(t.strings.RegistrationSuccess + "\r\n")
+		if !t.waitForKey() {
+			return true
+		}
 		t.mode = ModeNormal
 		t.isRegistering = false
 		return false
@@ -290,38 +965,58 @@ func (t *TerminalUI) handleRegistration() bool {
 }
 
 func (t *TerminalUI) handleInput() error {
+	t.startInput()
+	defer t.stopInput()
+
 	var buf [1]byte
 	for {
-		n, err := t.channel.Read(buf[:])
+		var err error
+		buf[0], err = t.readByte()
 		if err != nil {
-			if err == io.EOF {
+			switch err {
+			case io.EOF:
+				t.clear()
+				t.showCursor()
+				t.writeLine(t.strings.Goodbye)
+				return nil
+			case errIdleTimeout, errSessionExpired:
+				message := t.strings.IdleLogout
+				if err == errSessionExpired {
+					message = t.strings.SessionExpired
+				}
 				t.clear()
 				t.showCursor()
-				t.write("Goodbye!\r\n")
+				t.write(message + "\r\n")
 				return nil
 			}
 			return fmt.Errorf("read error: %v", err)
 		}
 
-		if n == 0 {
-			continue
-		}
-
 		// Handle registration mode
 		if t.mode == ModeRegister {
-			switch buf[0] {
-			case 3: // Ctrl+C
+			// Read keys whole so the bytes of an arrow key don't end up
+			// in the password
+			key, err := t.readKey(buf[0])
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "\x03": // Ctrl+C
+				t.interrupted = true
+				if err := t.userStore.CancelRegistration(t.username); err != nil {
+					log.Printf("Error cancelling registration: %v", err)
+				}
 				t.clear()
 				t.showCursor()
-				t.write("Registration cancelled. Goodbye!\r\n")
+				t.write(t.strings.RegistrationCancelled + "\r\n")
 				return nil
-			case 13: // Enter
+			case "\r": // Enter
 				if t.handleRegistration() {
 					return nil // Exit if registration failed
 				}
 				t.refreshDisplay()
 				continue
-			case 127: // Backspace
+			case "\x08", "\x7f": // Backspace (BS or DEL, depending on the client)
 				if len(t.inputText) > 0 {
 					t.inputText = t.inputText[:len(t.inputText)-1]
 				}
@@ -329,45 +1024,119 @@ func (t *TerminalUI) handleInput() error {
 				continue
 			default:
 				// Only allow printable ASCII characters for password
-				if buf[0] >= 32 && buf[0] <= 126 {
-					t.inputText += string(buf[0])
+				if len(key) == 1 && key[0] >= 32 && key[0] <= 126 && t.canInsert() {
+					t.inputText += key
 				}
 				t.refreshDisplay()
 				continue
 			}
 		}
 
-		switch buf[0] {
-		case 3: // Ctrl+C
+		// Handle the second factor prompt
+		if t.mode == ModeTOTP {
+			if t.handleTOTPKey(buf[0]) {
+				return nil
+			}
+			t.refreshDisplay()
+			continue
+		}
+
+		key, err := t.readKey(buf[0])
+		if err != nil {
+			continue
+		}
+		if key == "\x03" { // Ctrl+C
+			t.interrupted = true
 			t.clear()
 			t.showCursor()
-			t.write("Goodbye!\r\n")
+			t.writeLine(t.strings.Goodbye)
 			return nil
-		case 9: // Tab
-			if t.mode == ModeNormal {
-				t.mode = ModeInput
-				t.inputLabel = "New todo: "
+		}
+
+		if t.mode == ModeFocus {
+			t.handleFocusKey(key)
+			t.refreshDisplay()
+			continue
+		}
+		if t.mode == ModeActivity {
+			t.handleActivityKey(key)
+			t.refreshDisplay()
+			continue
+		}
+
+		// The list is driven by the keymap
+		if t.mode == ModeNormal {
+			if action, ok := t.bindings[key]; ok {
+				t.runAction(action)
+			}
+			t.refreshDisplay()
+			continue
+		}
+
+		switch key {
+		case "\t":
+			if t.mode == ModeInput && t.keysInput() {
+				t.mode = ModeKeys
+			} else {
+				t.mode = ModeNormal
+			}
+			t.inputText = ""
+			t.cursorPos = 0
+		case "\r":
+			if t.mode == ModeInput && t.inputAction == inputSearch {
+				t.filter = strings.TrimSpace(t.inputText)
+				t.selected = 0
+				t.mode = ModeNormal
 				t.inputText = ""
 				t.cursorPos = 0
-			} else {
+			} else if t.mode == ModeInput && t.inputAction == inputKey {
+				t.addKey(strings.TrimSpace(t.inputText))
+				t.mode = ModeKeys
+				t.inputText = ""
+				t.cursorPos = 0
+			} else if t.mode == ModeInput && t.inputAction == inputTOTP {
+				t.confirmTOTP(t.inputText)
+				t.mode = ModeKeys
+				t.inputText = ""
+				t.cursorPos = 0
+			} else if t.mode == ModeInput && t.inputAction == inputBlockers {
+				t.setBlockers(t.inputText)
 				t.mode = ModeNormal
 				t.inputText = ""
 				t.cursorPos = 0
-			}
-		case 13: // Enter
-			if t.mode == ModeInput {
+			} else if t.mode == ModeInput && t.inputAction == inputTheme {
+				t.chooseTheme(t.inputText)
+				t.mode = ModeNormal
+				t.inputText = ""
+				t.cursorPos = 0
+			} else if t.mode == ModeInput && t.inputAction == inputSnooze {
+				t.snoozeSelected(t.inputText)
+				t.mode = ModeNormal
+				t.inputText = ""
+				t.cursorPos = 0
+			} else if t.mode == ModeInput && t.inputAction == inputAddMany {
+				// Keep the input open for the next todo until an empty
+				// line, Tab, or a failure ends the run
+				text := strings.TrimSpace(t.inputText)
+				t.inputText = ""
+				t.cursorPos = 0
+				if text == "" || !t.addTodo(text) {
+					t.mode = ModeNormal
+				}
+			} else if t.mode == ModeInput {
 				text := strings.TrimSpace(t.inputText)
 				if text != "" {
-					if t.inputLabel == "New todo: " {
-						_, err := t.todoStore.Add(t.username, text)
-						if err != nil {
-							log.Printf("Error adding todo: %v", err)
-						}
+					if t.inputAction == inputAdd {
+						t.addTodo(text)
 					} else {
-						// Extract the actual todo ID from the selected todo
-						id := t.todos[t.selected].ID
-						_, err := t.todoStore.Update(t.username, id, text)
-						if err != nil {
+						// The todo selected when the edit began, even if the
+						// list has changed since
+						_, err := t.todoStore.Update(t.username, t.targetID, text)
+						if errors.Is(err, todo.ErrQuotaExceeded) {
+							t.status = t.strings.QuotaExceeded
+						} else if errors.Is(err, todo.ErrReadOnly) {
+							t.status = t.strings.Maintenance
+						} else if err != nil {
 							log.Printf("Error updating todo: %v", err)
 						}
 					}
@@ -375,82 +1144,61 @@ func (t *TerminalUI) handleInput() error {
 				t.mode = ModeNormal
 				t.inputText = ""
 				t.cursorPos = 0
-			} else if len(t.todos) > 0 {
-				t.mode = ModeInput
-				t.inputText = t.todos[t.selected].Text
-				// Just show "Edit todo:" instead of showing the ID
-				t.inputLabel = "Edit todo: "
-				t.cursorPos = len(t.inputText)
 			}
-		case 127: // Backspace
+		case "\x1b": // Escape
+			// Ends adding several todos, like Tab, keeping those added
+			if t.mode == ModeInput && t.inputAction == inputAddMany {
+				t.mode = ModeNormal
+				t.inputText = ""
+				t.cursorPos = 0
+			}
+		case "\x08", "\x7f": // Backspace (BS or DEL, depending on the client)
 			if t.mode == ModeInput && len(t.inputText) > 0 && t.cursorPos > 0 {
 				t.inputText = t.inputText[:t.cursorPos-1] + t.inputText[t.cursorPos:]
 				t.cursorPos--
 			}
-		case 32: // Space
-			if t.mode == ModeNormal && len(t.todos) > 0 {
-				// Use the actual ID from the selected todo
-				_, err := t.todoStore.ToggleComplete(t.username, t.todos[t.selected].ID)
-				if err != nil {
-					log.Printf("Error toggling todo: %v", err)
-				}
-			} else if t.mode == ModeInput {
-				t.inputText = t.inputText[:t.cursorPos] + " " + t.inputText[t.cursorPos:]
-				t.cursorPos++
+		case "\x1b[A": // Up arrow
+			if t.mode == ModeKeys && t.keySelected > 0 {
+				t.keySelected--
 			}
-		case 27: // Escape sequence
-			seq := make([]byte, 2)
-			if _, err := t.channel.Read(seq); err != nil {
-				continue
+		case "\x1b[B": // Down arrow
+			if t.mode == ModeKeys {
+				t.keySelected++ // Clamped when the list is drawn
 			}
-			if seq[0] != 91 { // Not a '[' character
-				continue
+		case "\x1b[C": // Right arrow
+			if t.mode == ModeInput && t.cursorPos < len(t.inputText) {
+				t.cursorPos++
 			}
-			switch seq[1] {
-			case 65: // Up arrow
-				if t.mode == ModeNormal && t.selected > 0 {
-					t.selected--
-				}
-			case 66: // Down arrow
-				if t.mode == ModeNormal && t.selected < len(t.todos)-1 {
-					t.selected++
-				}
-			case 67: // Right arrow
-				if t.mode == ModeInput && t.cursorPos < len(t.inputText) {
-					t.cursorPos++
-				}
-			case 68: // Left arrow
-				if t.mode == ModeInput && t.cursorPos > 0 {
-					t.cursorPos--
-				}
-			case 51: // Delete key (starts with 27, 91, 51)
-				extraByte := make([]byte, 1)
-				if _, err := t.channel.Read(extraByte); err != nil {
-					continue
-				}
-				if extraByte[0] != 126 { // Not a '~' character
-					continue
-				}
-				if t.mode == ModeNormal && len(t.todos) > 0 {
-					// Use the actual ID from the selected todo
-					if err := t.todoStore.Delete(t.username, t.todos[t.selected].ID); err != nil {
-						log.Printf("Error deleting todo: %v", err)
-					}
-					if t.selected >= len(t.todos)-1 {
-						t.selected = max(0, len(t.todos)-2)
-					}
-				} else if t.mode == ModeInput && t.cursorPos < len(t.inputText) {
-					t.inputText = t.inputText[:t.cursorPos] + t.inputText[t.cursorPos+1:]
-				}
+		case "\x1b[D": // Left arrow
+			if t.mode == ModeInput && t.cursorPos > 0 {
+				t.cursorPos--
+			}
+		case "\x1b[H", "\x1b[1~": // Home, as sent by different terminals
+			t.jumpToStart()
+		case "\x1b[F", "\x1b[4~": // End
+			t.jumpToEnd()
+		case "\x1b[3~": // Delete
+			if t.mode == ModeKeys {
+				t.removeSelectedKey()
+			} else if t.mode == ModeInput && t.cursorPos < len(t.inputText) {
+				t.inputText = t.inputText[:t.cursorPos] + t.inputText[t.cursorPos+1:]
 			}
 		default:
-			// Only handle printable ASCII characters in input mode
-			if t.mode == ModeInput && buf[0] >= 32 && buf[0] <= 126 {
-				t.inputText = t.inputText[:t.cursorPos] + string(buf[0]) + t.inputText[t.cursorPos:]
+			switch {
+			case t.mode == ModeKeys && key == "t":
+				t.toggleTOTP()
+			case t.mode == ModeKeys && key == "a":
+				t.mode = ModeInput
+				t.inputLabel = t.strings.KeyLabel
+				t.inputAction = inputKey
+				t.inputText = ""
+				t.cursorPos = 0
+			case t.mode == ModeInput && len(key) == 1 && key[0] >= 32 && key[0] <= 126 && t.canInsert():
+				// Only handle printable ASCII characters in input mode
+				t.inputText = t.inputText[:t.cursorPos] + key + t.inputText[t.cursorPos:]
 				t.cursorPos++
 			}
 		}
-
 		t.refreshDisplay()
 	}
 }
@@ -462,19 +1210,71 @@ func max(a, b int) int {
 	return b
 }
 
-func parsePtyRequest(payload []byte) (width, height int) {
-	// Simplified parsing for example
-	if len(payload) >= 8 {
-		return int(payload[2])<<8 | int(payload[3]), int(payload[4])<<8 | int(payload[5])
+// exitSignalPayload builds an exit-signal request for the named signal,
+// without the "SIG" prefix (RFC 4254 section 6.10)
+func exitSignalPayload(signal string) []byte {
+	return ssh.Marshal(struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}{Signal: signal})
+}
+
+// parseEnvRequest extracts the variable from an env request (RFC 4254
+// section 6.4)
+func parseEnvRequest(payload []byte) (name, value string, ok bool) {
+	var env struct {
+		Name  string
+		Value string
+	}
+	if err := ssh.Unmarshal(payload, &env); err != nil {
+		return "", "", false
+	}
+	return env.Name, env.Value, true
+}
+
+// parseSignalRequest extracts the signal name, without the "SIG" prefix,
+// from a signal payload (RFC 4254 section 6.9)
+func parseSignalRequest(payload []byte) (name string, ok bool) {
+	var signal struct {
+		Name string
+	}
+	if err := ssh.Unmarshal(payload, &signal); err != nil {
+		return "", false
+	}
+	return signal.Name, true
+}
+
+// parsePtyRequest extracts the terminal type and size from a pty-req payload
+// (RFC 4254 section 6.2). Malformed payloads, including ones declaring an
+// absurd terminal name length, are rejected with ok set to false.
+func parsePtyRequest(payload []byte) (term string, width, height int, ok bool) {
+	if len(payload) < 4 {
+		return "", 0, 0, false
+	}
+	termLen := binary.BigEndian.Uint32(payload)
+	if termLen > maxTermNameLength || uint32(len(payload)-4) < termLen+8 {
+		return "", 0, 0, false
+	}
+	term = string(payload[4 : 4+termLen])
+	rest := payload[4+termLen:]
+	width, height, ok = validSize(binary.BigEndian.Uint32(rest), binary.BigEndian.Uint32(rest[4:]))
+	return term, width, height, ok
+}
+
+// parseWinchRequest extracts the terminal size from a window-change payload
+func parseWinchRequest(payload []byte) (width, height int, ok bool) {
+	if len(payload) < 8 {
+		return 0, 0, false
 	}
-	return 80, 24
+	return validSize(binary.BigEndian.Uint32(payload), binary.BigEndian.Uint32(payload[4:]))
 }
 
-func parseWinchRequest(payload []byte) (width, height int) {
-	// Simplified parsing for example
-	if len(payload) >= 8 {
-		return int(payload[0])<<24 | int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3]),
-			int(payload[4])<<24 | int(payload[5])<<16 | int(payload[6])<<8 | int(payload[7])
+// validSize converts a reported size, rejecting zero or absurd dimensions
+func validSize(cols, rows uint32) (width, height int, ok bool) {
+	if cols == 0 || rows == 0 || cols > maxTermDimension || rows > maxTermDimension {
+		return 0, 0, false
 	}
-	return 80, 24
+	return int(cols), int(rows), true
 }
@@ -0,0 +1,1378 @@
+package ui
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"todoissh/pkg/todo"
+	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeChannel is an ssh.Channel that replays scripted input and records output
+type fakeChannel struct {
+	in   io.Reader
+	out  bytes.Buffer
+	sent []string // Types of the requests sent to the client
+}
+
+func newFakeChannel(input string) *fakeChannel {
+	return &fakeChannel{in: bytes.NewReader([]byte(input))}
+}
+
+func (c *fakeChannel) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *fakeChannel) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *fakeChannel) Close() error                { return nil }
+func (c *fakeChannel) CloseWrite() error           { return nil }
+func (c *fakeChannel) Stderr() io.ReadWriter       { return &bytes.Buffer{} }
+func (c *fakeChannel) SendRequest(name string, _ bool, _ []byte) (bool, error) {
+	c.sent = append(c.sent, name)
+	return true, nil
+}
+
+var _ ssh.Channel = (*fakeChannel)(nil)
+
+// newTestUI creates a TerminalUI reading the given input, backed by stores
+// in a temporary directory
+func newTestUI(t *testing.T, input string, isNewUser bool) *TerminalUI {
+	tempDir, err := os.MkdirTemp("", "todoissh-ui-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tempDir) })
+
+	todoStore, err := todo.NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	userStore, err := user.NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("user.NewStore() error = %v", err)
+	}
+
+	return NewTerminalUI(newFakeChannel(input), todoStore, userStore, "testuser", isNewUser)
+}
+
+// todosOf returns the todo store behind a UI created by newTestUI, for
+// setup beyond what the UI itself needs
+func todosOf(ui *TerminalUI) *todo.Store {
+	return ui.todoStore.(*todo.Store)
+}
+
+// usersOf returns the user store behind a UI created by newTestUI
+func usersOf(ui *TerminalUI) *user.Store {
+	return ui.userStore.(*user.Store)
+}
+
+// TestBackspaceInInput verifies that both BS (8) and DEL (127) erase the
+// character before the cursor, and are ignored at the start of the line
+func TestBackspaceInInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"DEL", "\tabc\x7f", "ab"},
+		{"BS", "\tabc\x08", "ab"},
+		{"mixed", "\tabc\x08\x7f", "a"},
+		{"at start", "\t\x08\x7fx", "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := newTestUI(t, tt.input, false)
+			if err := ui.handleInput(); err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+			if ui.inputText != tt.want {
+				t.Errorf("inputText = %q, want %q", ui.inputText, tt.want)
+			}
+			if ui.cursorPos != len(tt.want) {
+				t.Errorf("cursorPos = %d, want %d", ui.cursorPos, len(tt.want))
+			}
+		})
+	}
+}
+
+// TestBackspaceInRegistration verifies that both BS (8) and DEL (127) erase
+// password characters during registration
+func TestBackspaceInRegistration(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"DEL", "secret\x7f", "secre"},
+		{"BS", "secret\x08", "secre"},
+		{"empty", "\x08\x7f", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := newTestUI(t, tt.input, true)
+			if err := ui.handleInput(); err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+			if ui.mode != ModeRegister {
+				t.Fatalf("mode = %v, want ModeRegister", ui.mode)
+			}
+			if ui.inputText != tt.want {
+				t.Errorf("inputText = %q, want %q", ui.inputText, tt.want)
+			}
+		})
+	}
+}
+
+// TestRegistrationArrowKeys verifies that arrow keys neither end up in the
+// password nor leave stray bytes behind at a "press any key" prompt
+func TestRegistrationArrowKeys(t *testing.T) {
+	ui := newTestUI(t, "se\x1b[Ccret1\rsecret1\r\x1b[A\t", true)
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if _, ok := usersOf(ui).Authenticate(ui.username, "secret1"); !ok {
+		t.Error("Authenticate() failed; the arrow key changed the password")
+	}
+	// The whole arrow key dismissed the success message, so Tab opened
+	// the new todo input instead of the [ and A being typed
+	if ui.mode != ModeInput || ui.inputText != "" {
+		t.Errorf("mode = %v, inputText = %q; want ModeInput with no text", ui.mode, ui.inputText)
+	}
+}
+
+// TestExecRegister verifies registering over exec, with exit statuses for
+// scripts and the same limits as the UI
+func TestExecRegister(t *testing.T) {
+	ui := newTestUI(t, "secret12\n", true)
+	usersOf(ui).SetPasswordPolicy(user.Policy{MinLength: 8})
+
+	tests := []struct {
+		command string
+		stdin   string
+		want    int
+	}{
+		{"", "", ExitUsage},
+		{"delete everything", "", ExitUsage},
+		{"register", "", ExitUsage},
+		{"register", "short\n", ExitFailed},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if got := ui.runCommand(tt.command, strings.NewReader(tt.stdin), &stdout, &stderr); got != tt.want {
+			t.Errorf("runCommand(%q) with %q = %d; want %d", tt.command, tt.stdin, got, tt.want)
+		}
+		if stderr.Len() == 0 {
+			t.Errorf("runCommand(%q) with %q explained nothing on stderr", tt.command, tt.stdin)
+		}
+	}
+	if _, pending := usersOf(ui).PendingRegistration(ui.username); pending {
+		t.Error("rejected password left a pending registration")
+	}
+
+	// A real session neither sets up the terminal nor shows the UI
+	requests := make(chan *ssh.Request, 1)
+	requests <- &ssh.Request{Type: "exec", Payload: ssh.Marshal(struct{ Command string }{"register"})}
+	close(requests)
+	ui.HandleChannel(requests)
+	out := ui.channel.(*fakeChannel).out.String()
+	if out != "Registered "+ui.username+"\n" {
+		t.Errorf("output = %q; want only the confirmation", out)
+	}
+	if _, ok := usersOf(ui).Authenticate(ui.username, "secret12"); !ok {
+		t.Error("Authenticate() failed after registering over exec")
+	}
+	if got := ui.runCommand("register", strings.NewReader("secret12\n"), io.Discard, io.Discard); got != ExitFailed {
+		t.Errorf("registering twice = %d; want %d", got, ExitFailed)
+	}
+
+	// Registration limits apply as in the UI
+	closed := newTestUI(t, "", true)
+	usersOf(closed).SetInviteOnly(true)
+	if got := closed.runCommand("register", strings.NewReader("secret12\n"), io.Discard, io.Discard); got != ExitFailed {
+		t.Errorf("registering while invite-only = %d; want %d", got, ExitFailed)
+	}
+}
+
+// TestResumeRegistration verifies that a user who chose a password but
+// disconnected before confirming it resumes at the confirmation step
+func TestResumeRegistration(t *testing.T) {
+	// First session: choose a password, then disconnect
+	first := newTestUI(t, "secret1\r", true)
+	if err := first.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if first.registerStep != 1 {
+		t.Fatalf("registerStep = %d; want 1", first.registerStep)
+	}
+
+	// Second session with the same stores: only the confirmation is asked for
+	ui := NewTerminalUI(newFakeChannel("secret1\rx"), first.todoStore, first.userStore, first.username, true)
+	if !ui.resuming || ui.registerStep != 1 {
+		t.Fatalf("resuming = %v, registerStep = %d; want true, 1", ui.resuming, ui.registerStep)
+	}
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.mode != ModeNormal {
+		t.Errorf("mode = %v; want ModeNormal after confirming", ui.mode)
+	}
+	if _, ok := usersOf(ui).Authenticate(ui.username, "secret1"); !ok {
+		t.Error("Authenticate() failed after resumed registration")
+	}
+}
+
+// TestRegistrationPolicy verifies that a password breaking the policy is
+// rejected with the unmet requirements and registration starts over
+func TestRegistrationPolicy(t *testing.T) {
+	ui := newTestUI(t, "abcdef\rx", true)
+	usersOf(ui).SetPasswordPolicy(user.Policy{MinLength: 6, RequireDigit: true})
+	out := ui.channel.(*fakeChannel)
+
+	ui.refreshDisplay()
+	if !bytes.Contains(out.out.Bytes(), []byte("Password must be at least 6 characters long, contain a digit.")) {
+		t.Errorf("registration screen does not list the policy requirements")
+	}
+
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if !bytes.Contains(out.out.Bytes(), []byte("Password rejected: password must contain a digit.")) {
+		t.Errorf("rejection does not name the unmet requirement:\n%s", out.out.String())
+	}
+	if ui.registerStep != 0 {
+		t.Errorf("registerStep = %d; want 0 after rejection", ui.registerStep)
+	}
+	if _, pending := ui.userStore.PendingRegistration(ui.username); pending {
+		t.Error("rejected password started a pending registration")
+	}
+}
+
+// TestRegistrationRuleFollowsPolicy verifies that the registration screen
+// shows the minimum length the store enforces, not the default one
+func TestRegistrationRuleFollowsPolicy(t *testing.T) {
+	ui := newTestUI(t, "", true)
+	usersOf(ui).SetPasswordPolicy(user.Policy{MinLength: 10})
+
+	ui.refreshDisplay()
+	out := ui.channel.(*fakeChannel).out.String()
+	if !strings.Contains(out, "Password must be at least 10 characters long.") {
+		t.Errorf("registration screen does not show the configured minimum:\n%s", out)
+	}
+	if err := ui.userStore.StartRegistration(ui.username, "ninechars"); err == nil {
+		t.Error("StartRegistration() accepted a password shorter than the minimum shown")
+	}
+}
+
+// totpNow returns the current code for a base32 TOTP secret, as an
+// authenticator app would show it
+func totpNow(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// TestTOTPEnrollment verifies that two-factor is only turned on once a code
+// from the new secret is entered
+func TestTOTPEnrollment(t *testing.T) {
+	ui := newTestUI(t, "kt12345\r", false)
+	if err := usersOf(ui).Register(ui.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.userStore.HasTOTP(ui.username) || ui.mode != ModeKeys {
+		t.Errorf("HasTOTP() = %v, mode = %v after a wrong code; want off, ModeKeys", ui.userStore.HasTOTP(ui.username), ui.mode)
+	}
+
+	ui.toggleTOTP()
+	if ui.mode != ModeInput || ui.inputAction != inputTOTP || ui.totpSecret == "" {
+		t.Fatalf("mode = %v, action = %v after t; want a code for the new secret", ui.mode, ui.inputAction)
+	}
+	if ui.userStore.HasTOTP(ui.username) {
+		t.Error("HasTOTP() = true before a code was entered")
+	}
+	ui.confirmTOTP(totpNow(t, ui.totpSecret))
+	if !ui.userStore.HasTOTP(ui.username) || ui.status != ui.strings.TOTPEnabled {
+		t.Errorf("HasTOTP() = %v, status = %q after the right code; want on", ui.userStore.HasTOTP(ui.username), ui.status)
+	}
+}
+
+// TestTOTPPrompt verifies that users enrolled in TOTP must enter a valid
+// code before reaching their todos, and are disconnected after repeated
+// failures
+func TestTOTPPrompt(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	if err := usersOf(setup).Register(setup.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// Users without TOTP go straight to their todos
+	if setup.mode != ModeNormal {
+		t.Fatalf("mode = %v; want ModeNormal without TOTP", setup.mode)
+	}
+	secret, err := setup.userStore.EnrollTOTP(setup.username)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	if err := setup.userStore.ConfirmTOTP(setup.username, totpNow(t, secret)); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+
+	// Codes shorter than 6 digits are never valid
+	ui := NewTerminalUI(newFakeChannel("12345\r12345\r12345\r"), setup.todoStore, setup.userStore, setup.username, false)
+	if ui.mode != ModeTOTP {
+		t.Fatalf("mode = %v; want ModeTOTP", ui.mode)
+	}
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.totpAttempts != maxTOTPAttempts || ui.mode != ModeTOTP {
+		t.Errorf("totpAttempts = %d, mode = %v; want %d, ModeTOTP", ui.totpAttempts, ui.mode, maxTOTPAttempts)
+	}
+
+	// Only digits are accepted, and backspace edits the code
+	ui = NewTerminalUI(newFakeChannel(""), setup.todoStore, setup.userStore, setup.username, false)
+	ui.handleTOTPKey('4')
+	if ui.inputText != "4" {
+		t.Errorf("inputText = %q; want %q", ui.inputText, "4")
+	}
+	ui.handleTOTPKey('x')
+	ui.handleTOTPKey(127)
+	if ui.inputText != "" {
+		t.Errorf("inputText = %q after backspace; want empty", ui.inputText)
+	}
+}
+
+// TestToggleShowIDs verifies that rows can be numbered by todo ID and that
+// the choice is saved in the user's preferences
+func TestToggleShowIDs(t *testing.T) {
+	ui := newTestUI(t, "i", false)
+	if err := usersOf(ui).Register(ui.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	for _, text := range []string{"First", "Second"} {
+		if _, err := ui.todoStore.Add(ui.username, text); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if _, err := ui.todoStore.Delete(ui.username, 1); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	out := ui.channel.(*fakeChannel)
+
+	ui.refreshDisplay()
+	if !strings.Contains(out.out.String(), "1. Second") {
+		t.Errorf("rows are not numbered by position by default:\n%s", out.out.String())
+	}
+
+	out.out.Reset()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if !strings.Contains(out.out.String(), "2. Second") {
+		t.Errorf("rows are not numbered by ID after pressing i:\n%s", out.out.String())
+	}
+	if !ui.userStore.GetUser(ui.username).Preferences.ShowIDs {
+		t.Error("ShowIDs preference was not saved")
+	}
+}
+
+// TestThemeByName verifies that a theme picked with c is used, saved in the
+// user's preferences and applied again next session, and that unknown
+// names leave the theme alone
+func TestThemeByName(t *testing.T) {
+	ui := newTestUI(t, "cplaid\rc HighContrast\r", false)
+	if err := usersOf(ui).Register(ui.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, `Could not change theme: unknown theme "plaid"`) {
+		t.Errorf("unknown theme name was not reported:\n%s", out)
+	}
+	if ui.theme != HighContrastTheme {
+		t.Errorf("theme after choosing highcontrast = %q", ui.theme)
+	}
+	prefs := ui.userStore.GetUser(ui.username).Preferences
+	if prefs.Theme != "highcontrast" {
+		t.Errorf("saved theme = %q; want highcontrast", prefs.Theme)
+	}
+
+	next := NewTerminalUI(newFakeChannel(""), ui.todoStore, ui.userStore, ui.username, false)
+	next.ApplyPreferences(prefs)
+	if next.theme != HighContrastTheme {
+		t.Errorf("theme in the next session = %q; want highcontrast", next.theme)
+	}
+	if err := next.SetThemeByName("nope"); err == nil || next.theme != HighContrastTheme {
+		t.Errorf("SetThemeByName(nope) = %v; want error and theme unchanged", err)
+	}
+}
+
+// TestKeymap verifies that custom bindings drive the list, and that
+// conflicting or untypeable bindings are rejected without taking effect
+func TestKeymap(t *testing.T) {
+	ui := newTestUI(t, "j\x1b[B ", false)
+	todosOf(ui).AddMany(ui.username, []string{"First", "Second", "Third"})
+
+	keymap := DefaultKeymap()
+	keymap[ActionDown] = []string{"j"}
+	keymap[ActionUp] = []string{"k"}
+	if err := ui.SetKeymap(keymap); err == nil || !strings.Contains(err.Error(), "bound to both") {
+		t.Errorf("SetKeymap() with k bound twice error = %v; want a conflict", err)
+	}
+	for _, bad := range []Keymap{
+		{ActionDown: {"jj"}},
+		{ActionDown: {"\x03"}},
+		{ActionDown: {""}},
+		{"jump": {"J"}},
+	} {
+		if err := ui.SetKeymap(bad); err == nil {
+			t.Errorf("SetKeymap(%q) succeeded; want error", bad)
+		}
+	}
+	keymap[ActionKeys] = []string{"K"}
+	if err := ui.SetKeymap(keymap); err != nil {
+		t.Fatalf("SetKeymap() error = %v", err)
+	}
+
+	// j moves down, while the unbound down arrow does nothing
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	todos, _ := ui.todoStore.List(ui.username)
+	if todos[0].Completed || !todos[1].Completed || todos[2].Completed {
+		t.Errorf("completed = %v, %v, %v; want only Second", todos[0].Completed, todos[1].Completed, todos[2].Completed)
+	}
+}
+
+// TestFocusMode verifies that the focus screen shows one todo with its
+// notes, that the arrows move between todos, and that Escape leaves it
+func TestFocusMode(t *testing.T) {
+	ui := newTestUI(t, ".\x1b[C\x1b[C\x1b[C\x1b[D\x1b", false)
+	added, _ := todosOf(ui).AddMany(ui.username, []string{"First", "Second", "Third"})
+	todosOf(ui).SetNotes(ui.username, added[1].ID, "Call back before noon")
+	todosOf(ui).SetTags(ui.username, added[1].ID, []string{"work"})
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.mode != ModeNormal {
+		t.Errorf("mode = %v after Escape; want ModeNormal", ui.mode)
+	}
+	if ui.selected != 1 {
+		t.Errorf("selected = %d; want 1, stopping at the last todo", ui.selected)
+	}
+	out := ui.channel.(*fakeChannel).out.String()
+	for _, want := range []string{"Todo 2 of 3", "Call back before noon", "Tags: work", "Todo 3 of 3", DefaultStrings.FocusHelp} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q", want)
+		}
+	}
+}
+
+// TestSnoozeView verifies snoozing the selected todo for a typed duration
+// and listing snoozed todos
+func TestSnoozeView(t *testing.T) {
+	ui := newTestUI(t, "\x1b[Bs3d\rssoon\rz", false)
+	todosOf(ui).AddMany(ui.username, []string{"First", "Second", "Third"})
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if len(ui.todos) != 1 || ui.todos[0].Text != "Second" {
+		t.Fatalf("snoozed view lists %d todos; want only Second", len(ui.todos))
+	}
+	until := ui.todos[0].SnoozedUntil
+	if until == nil || time.Until(*until) < 71*time.Hour {
+		t.Errorf("SnoozedUntil = %v; want 3 days from now", until)
+	}
+	out := ui.channel.(*fakeChannel).out.String()
+	if !strings.Contains(out, "Snoozed until ") || !strings.Contains(out, "Could not snooze: \"soon\" is not a duration") {
+		t.Errorf("output lacks the snooze confirmation or the error for a bad duration:\n%s", out)
+	}
+	if visible, _ := ui.todoStore.List(ui.username); len(visible) != 2 {
+		t.Errorf("List() has %d todos; want the 2 not snoozed", len(visible))
+	}
+}
+
+// TestRefresh verifies that Ctrl+R picks up changes another process saved
+// to the todo file
+func TestRefresh(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	dataDir := t.TempDir()
+	cached, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	other, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	ui := NewTerminalUI(newFakeChannel("\x12"), cached, setup.userStore, setup.username, false)
+	cached.Add(ui.username, "Cached")
+
+	ui.refreshDisplay()
+	other.Add(ui.username, "Saved elsewhere")
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if len(ui.todos) != 2 || ui.todos[1].Text != "Saved elsewhere" {
+		t.Errorf("todos after Ctrl+R = %d; want 2, including the one saved elsewhere", len(ui.todos))
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, DefaultStrings.Refreshed) {
+		t.Errorf("output is missing %q", DefaultStrings.Refreshed)
+	}
+}
+
+// TestInputLayout verifies that the input field and cursor follow the
+// configured row and separator, and that invalid layouts are rejected
+func TestInputLayout(t *testing.T) {
+	for _, layout := range []InputLayout{{Separator: "──", Row: 2}, {Separator: "\t", Row: 2}, {Separator: "-", Row: 0}} {
+		if err := layout.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded; want error", layout)
+		}
+	}
+
+	ui := newTestUI(t, "\tMilk", false)
+	if err := ui.SetInputLayout(InputLayout{Separator: "=", Row: 1}); err != nil {
+		t.Fatalf("SetInputLayout() error = %v", err)
+	}
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	out := ui.channel.(*fakeChannel).out.String()
+	if !strings.Contains(out, "\x1b[23;1H"+strings.Repeat("=", ui.width)) {
+		t.Error("separator not drawn on the row above the input")
+	}
+	cursor := fmt.Sprintf("\x1b[24;%dH", len(ui.strings.NewTodoLabel)+len("Milk")+1)
+	if !strings.Contains(out, "\x1b[24;1H"+ui.strings.NewTodoLabel+"Milk") || !strings.Contains(out, cursor) {
+		t.Errorf("input or cursor not on the last row; want the cursor at %q", cursor)
+	}
+
+	ui = newTestUI(t, "\tMilk", false)
+	ui.SetInputLayout(InputLayout{Row: 2})
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); strings.Contains(out, "\x1b[22;1H") {
+		t.Error("something drawn above the input without a separator")
+	}
+}
+
+// TestActivityView verifies that l lists recent actions newest first, that
+// scrolling stops at the last entry, and that Escape leaves the screen
+func TestActivityView(t *testing.T) {
+	ui := newTestUI(t, "l\x1b[B\x1b[B\x1b[B\x1b[B\x1b", false)
+	ui.height = 8 // Room for three entries
+	todosOf(ui).AddMany(ui.username, []string{"Milk", "Bread", "Eggs"})
+	todosOf(ui).ToggleComplete(ui.username, 1)
+	todosOf(ui).Delete(ui.username, 3)
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.mode != ModeNormal {
+		t.Errorf("mode = %v after Escape; want ModeNormal", ui.mode)
+	}
+	if ui.activityOffset != 2 {
+		t.Errorf("activityOffset = %d; want 2, the last three of five entries", ui.activityOffset)
+	}
+	out := ui.channel.(*fakeChannel).out.String()
+	deleted := strings.Index(out, fmt.Sprintf(DefaultStrings.ActivityEntryFormat, "", DefaultStrings.ActivityDeleted, "Eggs"))
+	completed := strings.Index(out, fmt.Sprintf(DefaultStrings.ActivityEntryFormat, "", DefaultStrings.ActivityCompleted, "Milk"))
+	if deleted < 0 || completed < 0 || deleted > completed {
+		t.Error("activity is missing the deletion and completion, newest first")
+	}
+}
+
+// TestSaveNow verifies that the list notes unsaved changes, and that w
+// saves them
+func TestSaveNow(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	dataDir := t.TempDir()
+	store, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	ui := NewTerminalUI(newFakeChannel("w"), store, setup.userStore, setup.username, false)
+
+	// A file from before schema versions can't be upgraded while read-only
+	legacy := `{"todos":{"1":{"id":1,"text":"Old","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}},"next_id":2}`
+	if err := os.MkdirAll(filepath.Join(dataDir, "todos"), 0700); err != nil {
+		t.Fatalf("Failed to create todos directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "todos", ui.username+".json"), []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+	store.SetReadOnly(true)
+	ui.refreshDisplay()
+	pending := fmt.Sprintf(DefaultStrings.PendingWritesFormat, 1)
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, pending) {
+		t.Errorf("output is missing %q", pending)
+	}
+
+	store.SetReadOnly(false)
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if n := store.PendingWrites(); n != 0 {
+		t.Errorf("PendingWrites() after w = %d; want 0", n)
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, DefaultStrings.Saved) {
+		t.Errorf("output is missing %q", DefaultStrings.Saved)
+	}
+}
+
+// TestInputWithFinalError verifies that a byte a read returns along with
+// an error is still handled
+func TestInputWithFinalError(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	ui.channel.(*fakeChannel).in = iotest.DataErrReader(strings.NewReader("\tMilk\r"))
+
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if todos, _ := ui.todoStore.List(ui.username); len(todos) != 1 || todos[0].Text != "Milk" {
+		t.Errorf("todos = %v; want Milk, added by the final Enter", todos)
+	}
+}
+
+// TestEscapeThenKey verifies that Escape followed by a key other than [ or
+// O is read as that pair alone, leaving the next key to act on its own
+func TestEscapeThenKey(t *testing.T) {
+	ui := newTestUI(t, "\x1bx ", false)
+	todosOf(ui).AddMany(ui.username, []string{"First", "Second"})
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if todos, _ := ui.todoStore.List(ui.username); !todos[0].Completed {
+		t.Error("Space after Alt+x was swallowed; want First toggled")
+	}
+}
+
+// TestListScrolls verifies that a list longer than the screen scrolls to
+// keep the selected todo visible
+func TestListScrolls(t *testing.T) {
+	ui := newTestUI(t, "G", false)
+	texts := make([]string, 40)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("Item %02d", i+1)
+	}
+	todosOf(ui).AddMany(ui.username, texts)
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	out := &ui.channel.(*fakeChannel).out
+	out.Reset()
+	ui.refreshDisplay()
+	if screen := out.String(); !strings.Contains(screen, "Item 40") || strings.Contains(screen, "Item 01") {
+		t.Errorf("screen after G does not show the last todo in place of the first:\n%s", screen)
+	}
+
+	ui.runAction(ActionFirst)
+	out.Reset()
+	ui.refreshDisplay()
+	if screen := out.String(); !strings.Contains(screen, "Item 01") || strings.Contains(screen, "Item 40") {
+		t.Errorf("screen after g does not show the first todo in place of the last:\n%s", screen)
+	}
+}
+
+// TestTruncateAndWrap verifies the helpers that fit todo text to the width
+func TestTruncateAndWrap(t *testing.T) {
+	truncates := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"this is too long", 10, "this is t…"},
+		{"[✓] café au lait", 8, "[✓] caf…"},
+		{"anything", 0, ""},
+	}
+	for _, tt := range truncates {
+		if got := truncate(tt.in, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q; want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+
+	got := wrap("the quick brown fox jumps", 10)
+	want := []string{"the quick", "brown fox", "jumps"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("wrap() = %q; want %q", got, want)
+	}
+	if got := wrap("abcdefghijkl", 5); strings.Join(got, "|") != "abcde|fghij|kl" {
+		t.Errorf("wrap() without spaces = %q", got)
+	}
+}
+
+// TestLongTodoTruncated verifies that long todos are cut to the terminal
+// width, with the full text shown under the selected one
+func TestLongTodoTruncated(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	ui.setSize(30, 24)
+	long := "a todo whose text is much longer than thirty columns"
+	for _, text := range []string{long, long + " too"} {
+		if _, err := ui.todoStore.Add(ui.username, text); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	out := ui.channel.(*fakeChannel)
+
+	ui.refreshDisplay()
+	screen := out.out.String()
+	if !strings.Contains(screen, "> [ ] 1. a todo whose text is…") {
+		t.Errorf("selected row was not truncated to 30 columns:\n%s", screen)
+	}
+	if !strings.Contains(screen, "  [ ] 2. a todo whose text is…") {
+		t.Errorf("other row was not truncated to 30 columns:\n%s", screen)
+	}
+	if !strings.Contains(screen, "    a todo whose text is much\r\n    longer than thirty columns\r\n") {
+		t.Errorf("full text of the selected todo is not shown:\n%s", screen)
+	}
+	if strings.Contains(screen, "    longer than thirty columns too") {
+		t.Errorf("full text shown for an unselected todo:\n%s", screen)
+	}
+}
+
+// TestNewBadge verifies that todos created since the previous visit are
+// badged as new, and that the badge clears on the following visit
+func TestNewBadge(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	if err := usersOf(setup).Register(setup.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := setup.todoStore.Add(setup.username, "Old"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	visit := func() string {
+		ui := NewTerminalUI(newFakeChannel(""), setup.todoStore, setup.userStore, setup.username, false)
+		ui.refreshDisplay()
+		return ui.channel.(*fakeChannel).out.String()
+	}
+
+	// Nothing is new on the first visit
+	if screen := visit(); strings.Contains(screen, "NEW") {
+		t.Errorf("first visit shows NEW badges:\n%s", screen)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := setup.todoStore.Add(setup.username, "Fresh"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	screen := visit()
+	if !strings.Contains(screen, "Fresh NEW") || strings.Contains(screen, "Old NEW") {
+		t.Errorf("only the todo added since the last visit should be NEW:\n%s", screen)
+	}
+
+	if screen := visit(); strings.Contains(screen, "NEW") {
+		t.Errorf("NEW badge did not clear on the next visit:\n%s", screen)
+	}
+}
+
+// TestGreetings verifies custom welcome and goodbye messages, and that an
+// empty goodbye is hidden without skipping the terminal teardown
+func TestGreetings(t *testing.T) {
+	ui := newTestUI(t, "", true)
+	ui.SetGreetings("Welcome to ACME todos", "")
+	out := ui.channel.(*fakeChannel)
+
+	ui.refreshDisplay()
+	if !strings.Contains(out.out.String(), "Welcome to ACME todos\r\n") {
+		t.Errorf("custom welcome message not shown:\n%q", out.out.String())
+	}
+
+	out.out.Reset()
+	requests := make(chan *ssh.Request, 1)
+	requests <- &ssh.Request{Type: "shell"}
+	close(requests)
+	ui.HandleChannel(requests)
+
+	screen := out.out.String()
+	if strings.Contains(screen, DefaultStrings.Goodbye) {
+		t.Errorf("empty goodbye message still shows the default:\n%q", screen)
+	}
+	if !strings.HasSuffix(screen, "\x1b[?25h\x1b[?7h\x1b[?1049l") {
+		t.Errorf("terminal was not restored at the end of the session:\n%q", screen)
+	}
+}
+
+// TestExitSignal verifies that a session ended with Ctrl+C reports SIGINT
+// to the client, while a disconnect reports a clean exit status
+func TestExitSignal(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  string
+	}{
+		{"\x03", "exit-signal"},
+		{"", "exit-status"},
+	} {
+		ui := newTestUI(t, tt.input, false)
+		requests := make(chan *ssh.Request, 1)
+		requests <- &ssh.Request{Type: "shell"}
+		close(requests)
+		ui.HandleChannel(requests)
+
+		if sent := ui.channel.(*fakeChannel).sent; len(sent) != 1 || sent[0] != tt.want {
+			t.Errorf("input %q sent %v; want [%s]", tt.input, sent, tt.want)
+		}
+	}
+}
+
+// TestEnvRequest verifies that accepted variables from env requests are
+// captured and others ignored
+func TestEnvRequest(t *testing.T) {
+	ui := newTestUI(t, "", false)
+
+	env := func(name, value string) *ssh.Request {
+		payload := ssh.Marshal(struct{ Name, Value string }{name, value})
+		return &ssh.Request{Type: "env", Payload: payload}
+	}
+	requests := make(chan *ssh.Request, 5)
+	requests <- env("LANG", "de_DE.UTF-8")
+	requests <- env("LD_PRELOAD", "/tmp/evil.so")
+	requests <- env("TERM", strings.Repeat("x", maxEnvValueLength+1))
+	requests <- &ssh.Request{Type: "env", Payload: []byte{0, 0, 0, 9}}
+	requests <- &ssh.Request{Type: "shell"}
+	close(requests)
+	ui.HandleChannel(requests)
+
+	if got := ui.Env("LANG"); got != "de_DE.UTF-8" {
+		t.Errorf("Env(LANG) = %q; want de_DE.UTF-8", got)
+	}
+	if got := ui.Env("LD_PRELOAD"); got != "" {
+		t.Errorf("Env(LD_PRELOAD) = %q; want it ignored", got)
+	}
+	if got := ui.Env("TERM"); got != "" {
+		t.Errorf("Env(TERM) kept an oversized value")
+	}
+}
+
+// TestDeleteConfirmation verifies that deleting a todo names it in the status line
+func TestDeleteConfirmation(t *testing.T) {
+	ui := newTestUI(t, "\x1b[3~", false)
+	if _, err := ui.todoStore.Add(ui.username, "Buy milk"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+
+	if todos, _ := ui.todoStore.List(ui.username); len(todos) != 0 {
+		t.Errorf("todos after delete = %d; want 0", len(todos))
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, "Deleted: Buy milk") {
+		t.Errorf("deletion was not confirmed:\n%s", out)
+	}
+}
+
+// TestTodayView verifies that t switches to today's todos and back
+func TestTodayView(t *testing.T) {
+	ui := newTestUI(t, "t", false)
+	ui.todoStore.Add(ui.username, "Created today")
+	later := time.Now().Add(72 * time.Hour)
+	if _, err := todosOf(ui).SetDueDate(ui.username, 1, &later); err != nil {
+		t.Fatalf("SetDueDate() error = %v", err)
+	}
+	out := ui.channel.(*fakeChannel)
+
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if !strings.Contains(out.out.String(), "Today: due, overdue or created today (1 found") {
+		t.Errorf("today view summary missing:\n%s", out.out.String())
+	}
+	if !strings.Contains(out.out.String(), "Created today") {
+		t.Errorf("todo created today is not in the today view:\n%s", out.out.String())
+	}
+}
+
+// TestMaintenanceBanner verifies that a read-only store shows the maintenance
+// banner and refuses new todos without logging the user out
+func TestMaintenanceBanner(t *testing.T) {
+	ui := newTestUI(t, "\tMilk\r", false)
+	todosOf(ui).SetReadOnly(true)
+
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, ui.strings.Maintenance) {
+		t.Errorf("maintenance banner missing:\n%s", out)
+	}
+	if todos, _ := ui.todoStore.List(ui.username); len(todos) != 0 {
+		t.Errorf("todos during maintenance = %d; want 0", len(todos))
+	}
+}
+
+// TestMaintenanceKeys verifies that keys changing the selected todo explain
+// why nothing happens in maintenance mode
+func TestMaintenanceKeys(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	todosOf(ui).Add(ui.username, "Milk")
+	todosOf(ui).Add(ui.username, "Eggs")
+	ui.refreshDisplay()
+	todosOf(ui).SetReadOnly(true)
+
+	for _, action := range []Action{ActionToggle, ActionDelete, ActionMoveDown} {
+		ui.status = ""
+		ui.runAction(action)
+		if ui.status != ui.strings.Maintenance {
+			t.Errorf("status after %s = %q; want the maintenance message", action, ui.status)
+		}
+	}
+	todos, _ := ui.todoStore.List(ui.username)
+	if len(todos) != 2 || todos[0].Completed {
+		t.Errorf("todos changed during maintenance: %+v", todos)
+	}
+}
+
+// TestEditAfterListChanges verifies that an edit is saved to the todo it
+// began on, even if the list changed while it was open
+func TestEditAfterListChanges(t *testing.T) {
+	ui := newTestUI(t, " 2\r", false)
+	milk, _ := todosOf(ui).Add(ui.username, "Milk")
+	eggs, _ := todosOf(ui).Add(ui.username, "Eggs")
+	ui.refreshDisplay()
+	ui.runAction(ActionEdit)
+
+	// Another session moves Eggs above Milk
+	if err := todosOf(ui).Move(ui.username, eggs.ID, -1); err != nil {
+		t.Fatalf("Move() error = %v", err)
+	}
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if got, _ := todosOf(ui).Get(ui.username, milk.ID); got.Text != "Milk 2" {
+		t.Errorf("edited todo text = %q; want %q", got.Text, "Milk 2")
+	}
+	if got, _ := todosOf(ui).Get(ui.username, eggs.ID); got.Text != "Eggs" {
+		t.Errorf("other todo text = %q; want it unchanged", got.Text)
+	}
+
+	// The todo being edited is deleted, leaving the list empty
+	ui = newTestUI(t, " 2\r", false)
+	milk, _ = todosOf(ui).Add(ui.username, "Milk")
+	ui.refreshDisplay()
+	ui.runAction(ActionEdit)
+	todosOf(ui).Delete(ui.username, milk.ID)
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+}
+
+// TestAutoAdd verifies that an empty list opens the new todo input once,
+// and that a list with todos doesn't
+func TestAutoAdd(t *testing.T) {
+	ui := newTestUI(t, "Milk\r", false)
+	ui.SetAutoAdd(true)
+	ui.SetEmptyListMessage("Nothing here yet")
+
+	ui.refreshDisplay()
+	if ui.mode != ModeInput || ui.inputAction != inputAdd {
+		t.Fatalf("mode = %v; want the new todo input", ui.mode)
+	}
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if todos, _ := ui.todoStore.List(ui.username); len(todos) != 1 || todos[0].Text != "Milk" {
+		t.Errorf("todos = %+v; want Milk", todos)
+	}
+	if !strings.Contains(ui.channel.(*fakeChannel).out.String(), "Nothing here yet") {
+		t.Error("custom empty list message not shown")
+	}
+
+	ui = newTestUI(t, "", false)
+	ui.SetAutoAdd(true)
+	ui.todoStore.Add(ui.username, "Existing")
+	ui.refreshDisplay()
+	if ui.mode != ModeNormal {
+		t.Errorf("mode = %v with existing todos; want normal", ui.mode)
+	}
+}
+
+// fullTodoStore is a todo store whose Add always reports a full quota
+type fullTodoStore struct {
+	todo.TodoStore
+}
+
+func (fullTodoStore) Add(string, string) (*todo.Todo, error) {
+	return nil, todo.ErrQuotaExceeded
+}
+
+// TestCustomTodoStore verifies that the UI works with any TodoStore, here
+// one whose quota is always full
+func TestCustomTodoStore(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	ui := NewTerminalUI(newFakeChannel("\tMilk\r"), fullTodoStore{setup.todoStore}, setup.userStore, setup.username, false)
+
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, ui.strings.QuotaExceeded) {
+		t.Errorf("quota message missing:\n%s", out)
+	}
+}
+
+// TestAddSeveral verifies that a keeps the input open after each todo until
+// an empty line, while Tab still adds a single todo
+func TestAddSeveral(t *testing.T) {
+	ui := newTestUI(t, "aMilk\rBread\r\r\tEggs\r", false)
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+
+	todos, _ := ui.todoStore.List(ui.username)
+	var texts []string
+	for _, todo := range todos {
+		texts = append(texts, todo.Text)
+	}
+	if strings.Join(texts, ",") != "Milk,Bread,Eggs" {
+		t.Errorf("todos = %v; want Milk, Bread and Eggs", texts)
+	}
+	if ui.mode != ModeNormal {
+		t.Errorf("mode = %v after single add; want normal", ui.mode)
+	}
+}
+
+// TestBlockedBy verifies that b sets a todo's blockers, that it is marked
+// until they are done, and that completing it early is explained
+func TestBlockedBy(t *testing.T) {
+	// Block Paint fence on Buy paint, try to complete it, then try to
+	// block Buy paint on Paint fence
+	ui := newTestUI(t, "\x1b[Bb1\r \x1b[Ab2\r", false)
+	todosOf(ui).AddMany(ui.username, []string{"Buy paint", "Paint fence"})
+	todosOf(ui).SetEnforceBlockers(true)
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+
+	fence, _ := ui.todoStore.Get(ui.username, 2)
+	if len(fence.BlockedBy) != 1 || fence.BlockedBy[0] != 1 || fence.Completed {
+		t.Errorf("todo 2 = %+v; want blocked by 1 and incomplete", fence)
+	}
+	out := ui.channel.(*fakeChannel).out.String()
+	for _, want := range []string{
+		"Paint fence " + ui.strings.BlockedBadge,
+		ui.strings.StillBlocked,
+		"Could not set blockers: " + todo.ErrDependencyCycle.Error(),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// TestTinyTerminal verifies that a terminal smaller than the layout needs
+// gets a notice instead of a mangled screen, and the list once it grows
+func TestTinyTerminal(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	ui.todoStore.Add(ui.username, "Buy milk")
+	out := ui.channel.(*fakeChannel)
+
+	ui.setSize(1, 1)
+	ui.mode = ModeInput
+	ui.refreshDisplay()
+	screen := out.out.String()
+	if !strings.Contains(screen, "Terminal too small") {
+		t.Errorf("tiny terminal screen lacks the too small notice:\n%q", screen)
+	}
+	if strings.Contains(screen, "Buy milk") || strings.Contains(screen, "\x1b[-") || strings.Contains(screen, "\x1b[0;") {
+		t.Errorf("tiny terminal screen drew the list or moved off screen:\n%q", screen)
+	}
+
+	out.out.Reset()
+	ui.mode = ModeNormal
+	ui.setSize(80, 24)
+	ui.refreshDisplay()
+	if !strings.Contains(out.out.String(), "Buy milk") {
+		t.Errorf("list not shown after the terminal grew:\n%s", out.out.String())
+	}
+}
+
+// TestSelectionResume verifies that the todo selected when a session ends is
+// selected again in the next one, and that a deleted todo falls back to the top
+func TestSelectionResume(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	if err := usersOf(setup).Register(setup.username, "password"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	todosOf(setup).AddMany(setup.username, []string{"One", "Two", "Three"})
+
+	// Select Three, then end the session
+	first := NewTerminalUI(newFakeChannel("\x1b[B\x1b[B"), setup.todoStore, setup.userStore, setup.username, false)
+	requests := make(chan *ssh.Request, 1)
+	requests <- &ssh.Request{Type: "shell"}
+	close(requests)
+	first.HandleChannel(requests)
+
+	resume := func() *TerminalUI {
+		ui := NewTerminalUI(newFakeChannel(""), setup.todoStore, setup.userStore, setup.username, false)
+		ui.ApplyPreferences(setup.userStore.GetUser(setup.username).Preferences)
+		ui.refreshDisplay()
+		return ui
+	}
+	if ui := resume(); ui.selected != 2 {
+		t.Errorf("selected = %d after reconnecting; want 2", ui.selected)
+	}
+
+	setup.todoStore.Delete(setup.username, 3)
+	if ui := resume(); ui.selected != 0 {
+		t.Errorf("selected = %d after the todo was deleted; want 0", ui.selected)
+	}
+}
+
+// brokenChannel is a channel whose client is gone: every write fails
+type brokenChannel struct {
+	*fakeChannel
+	writes int
+}
+
+func (c *brokenChannel) Write(p []byte) (int, error) {
+	c.writes++
+	return 0, io.ErrClosedPipe
+}
+
+// TestWriteFailureEndsSession verifies that the session ends cleanly, without
+// further writes, once output can't be delivered
+func TestWriteFailureEndsSession(t *testing.T) {
+	setup := newTestUI(t, "", false)
+	channel := &brokenChannel{fakeChannel: newFakeChannel(strings.Repeat("g", 100))}
+	ui := NewTerminalUI(channel, setup.todoStore, setup.userStore, setup.username, false)
+
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v; want a clean exit", err)
+	}
+	if channel.writes != 1 {
+		t.Errorf("writes = %d; want only the first failed one", channel.writes)
+	}
+}
+
+// TestAddSeveralEscape verifies that Escape ends adding several todos,
+// keeping those already added and dropping the unfinished one
+func TestAddSeveralEscape(t *testing.T) {
+	ui := newTestUI(t, "aMilk\rHalf\x1b", false)
+	ui.refreshDisplay()
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+
+	if todos, _ := ui.todoStore.List(ui.username); len(todos) != 1 || todos[0].Text != "Milk" {
+		t.Errorf("todos = %v; want just Milk", todos)
+	}
+	if ui.mode != ModeNormal || ui.inputText != "" {
+		t.Errorf("mode = %v, input = %q after Escape; want normal with no input", ui.mode, ui.inputText)
+	}
+}
+
+// TestDueDateTimeZone verifies that due dates are shown in the store's time zone
+func TestDueDateTimeZone(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	todosOf(ui).SetLocation(time.FixedZone("UTC+2", 2*60*60))
+	if err := ui.SetRowTemplate("{{.Text}} due {{.Due}}"); err != nil {
+		t.Fatalf("SetRowTemplate() error = %v", err)
+	}
+	ui.todoStore.Add(ui.username, "Meeting")
+	due := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := todosOf(ui).SetDueDate(ui.username, 1, &due); err != nil {
+		t.Fatalf("SetDueDate() error = %v", err)
+	}
+
+	ui.refreshDisplay()
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, "Meeting due 2025-05-01 12:00") {
+		t.Errorf("due date not shown in the configured time zone:\n%s", out)
+	}
+}
+
+// TestRedrawPausedOnRepeatedFailures verifies that a list that keeps failing
+// to load is drawn as a static error instead of being redrawn, and comes
+// back once loading works again
+func TestRedrawPausedOnRepeatedFailures(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	dataDir := t.TempDir()
+	store, err := todo.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("todo.NewStore() error = %v", err)
+	}
+	ui.todoStore = store
+	todosPath := filepath.Join(dataDir, "todos", ui.username+".json")
+	if err := os.WriteFile(todosPath, []byte("{broken"), 0600); err != nil {
+		t.Fatalf("Failed to write todos file: %v", err)
+	}
+	out := ui.channel.(*fakeChannel)
+
+	for i := 0; i < maxRefreshFailures+2; i++ {
+		ui.refreshDisplay()
+	}
+	screen := out.out.String()
+	// One clear per failed redraw, plus one for the static error
+	if n := strings.Count(screen, "\x1b[2J"); n != maxRefreshFailures+1 {
+		t.Errorf("screen cleared %d times; want %d before redraws pause", n, maxRefreshFailures+1)
+	}
+	if strings.Count(screen, "can't be loaded right now") != 1 {
+		t.Errorf("static error not shown exactly once:\n%s", screen)
+	}
+
+	if err := os.WriteFile(todosPath, []byte(`{"todos":[{"id":1,"text":"Recovered"}],"next_id":2}`), 0600); err != nil {
+		t.Fatalf("Failed to write todos file: %v", err)
+	}
+	out.out.Reset()
+	ui.refreshDisplay()
+	if !strings.Contains(out.out.String(), "Recovered") {
+		t.Errorf("list not redrawn after the store recovered:\n%s", out.out.String())
+	}
+	if ui.refreshFailures != 0 {
+		t.Errorf("refreshFailures = %d after recovery; want 0", ui.refreshFailures)
+	}
+}
+
+// TestJumpToStartAndEnd verifies g/G and Home/End in the list, and that
+// Home/End move the cursor while g/G are typed as text in input mode
+func TestJumpToStartAndEnd(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"G", "G", 3},
+		{"g", "Gg", 0},
+		{"End", "\x1b[F", 3},
+		{"Home", "\x1b[F\x1b[H", 0},
+		{"End tilde", "\x1b[4~", 3},
+		{"Home tilde", "\x1b[4~\x1b[1~", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := newTestUI(t, tt.input, false)
+			todosOf(ui).AddMany(ui.username, []string{"One", "Two", "Three", "Four"})
+			ui.refreshDisplay()
+			if err := ui.handleInput(); err != nil {
+				t.Fatalf("handleInput() error = %v", err)
+			}
+			if ui.selected != tt.want {
+				t.Errorf("selected = %d; want %d", ui.selected, tt.want)
+			}
+		})
+	}
+
+	// Tab, type "ac", Home, type "gG", End, type "b"
+	ui := newTestUI(t, "\tac\x1b[HgG\x1b[Fb", false)
+	if err := ui.handleInput(); err != nil {
+		t.Fatalf("handleInput() error = %v", err)
+	}
+	if ui.inputText != "gGacb" {
+		t.Errorf("inputText = %q; want %q", ui.inputText, "gGacb")
+	}
+}
+
+// TestASCIIFallback verifies that clients without UTF-8 get ASCII symbols
+func TestASCIIFallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ui *TerminalUI)
+		ascii bool
+	}{
+		{"no information", func(ui *TerminalUI) {}, false},
+		{"UTF-8 locale", func(ui *TerminalUI) { ui.setEnv("LANG", "en_US.UTF-8") }, false},
+		{"Latin-1 locale", func(ui *TerminalUI) { ui.setEnv("LANG", "de_DE.ISO-8859-1") }, true},
+		{"LC_ALL wins", func(ui *TerminalUI) { ui.setEnv("LANG", "C"); ui.setEnv("LC_ALL", "en_US.utf8") }, false},
+		{"old terminal", func(ui *TerminalUI) { ui.setTerm("vt100") }, true},
+		{"old terminal, UTF-8 locale", func(ui *TerminalUI) { ui.setTerm("vt100"); ui.setEnv("LANG", "C.UTF-8") }, false},
+		{"forced", func(ui *TerminalUI) { ui.setEnv("LANG", "en_US.UTF-8"); ui.SetASCII(true) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ui := newTestUI(t, "", false)
+			ui.todoStore.Add(ui.username, "Done")
+			ui.todoStore.ToggleComplete(ui.username, 1)
+			tt.setup(ui)
+
+			ui.refreshDisplay()
+			out := ui.channel.(*fakeChannel).out.String()
+			if tt.ascii {
+				if !strings.Contains(out, "[x] 1. Done") || !strings.Contains(out, "--------") {
+					t.Errorf("ASCII symbols not used:\n%s", out)
+				}
+				for _, r := range out {
+					if r > 127 {
+						t.Fatalf("output contains %q:\n%s", r, out)
+					}
+				}
+			} else if !strings.Contains(out, "[✓] 1. Done") || !strings.Contains(out, "────────") {
+				t.Errorf("UTF-8 symbols not used:\n%s", out)
+			}
+		})
+	}
+}
+
+// TestSignalRequest verifies that stop signals from the client end the
+// session like Ctrl+C and other signals are ignored
+func TestSignalRequest(t *testing.T) {
+	ui := newTestUI(t, "", false)
+	input, keyboard := io.Pipe()
+	defer keyboard.Close()
+	ui.channel = &fakeChannel{in: input}
+
+	signal := func(name ssh.Signal) *ssh.Request {
+		return &ssh.Request{Type: "signal", Payload: ssh.Marshal(struct{ Name string }{string(name)})}
+	}
+	requests := make(chan *ssh.Request, 3)
+	requests <- &ssh.Request{Type: "shell"}
+	requests <- signal(ssh.SIGUSR1)
+	requests <- signal(ssh.SIGINT)
+	defer close(requests)
+
+	done := make(chan struct{})
+	go func() {
+		ui.HandleChannel(requests)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end after SIGINT")
+	}
+
+	if out := ui.channel.(*fakeChannel).out.String(); !strings.Contains(out, DefaultStrings.Goodbye) {
+		t.Errorf("session did not end like Ctrl+C:\n%q", out)
+	}
+}
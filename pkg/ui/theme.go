@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Theme holds the ANSI escape sequences used to color parts of the UI
+type Theme struct {
+	Overdue string // Todos past their due date
+	DueSoon string // Todos due within the next 24 hours
+	Reset   string // Restores the default attributes
+}
+
+// DefaultTheme is the theme used when none is configured
+var DefaultTheme = Theme{
+	Overdue: "\x1b[31m", // Red
+	DueSoon: "\x1b[33m", // Yellow
+	Reset:   "\x1b[0m",
+}
+
+// MonoTheme marks due todos with text attributes instead of colors, for
+// terminals or users that don't show colors well
+var MonoTheme = Theme{
+	Overdue: "\x1b[1m", // Bold
+	DueSoon: "\x1b[4m", // Underlined
+	Reset:   "\x1b[0m",
+}
+
+// HighContrastTheme marks due todos with bold text on a solid background
+var HighContrastTheme = Theme{
+	Overdue: "\x1b[1;97;41m", // Bold bright white on red
+	DueSoon: "\x1b[1;30;43m", // Bold black on yellow
+	Reset:   "\x1b[0m",
+}
+
+// themes are the themes users can pick by name
+var themes = map[string]Theme{
+	"default":      DefaultTheme,
+	"mono":         MonoTheme,
+	"highcontrast": HighContrastTheme,
+}
+
+// ThemeNames lists the names accepted by SetThemeByName, sorted
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetThemeByName switches to one of the themes listed by ThemeNames. An
+// unknown name returns an error and keeps the current theme.
+func (t *TerminalUI) SetThemeByName(name string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.setThemeByName(name)
+}
+
+// setThemeByName is SetThemeByName for callers that already hold the lock
+func (t *TerminalUI) setThemeByName(name string) error {
+	theme, ok := themes[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return fmt.Errorf("unknown theme %q, choose one of %s", name, strings.Join(ThemeNames(), ", "))
+	}
+	t.theme = theme
+	return nil
+}
+
+// chooseTheme switches to the theme typed by the user, saving the choice in
+// their preferences
+func (t *TerminalUI) chooseTheme(name string) {
+	if err := t.setThemeByName(name); err != nil {
+		t.status = fmt.Sprintf(t.strings.ThemeFailedFormat, err)
+		return
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	t.status = fmt.Sprintf(t.strings.ThemeSetFormat, name)
+
+	if u := t.userStore.GetUser(t.username); u != nil {
+		prefs := u.Preferences
+		prefs.Theme = name
+		if err := t.userStore.SetPreferences(t.username, prefs); err != nil {
+			log.Printf("Error saving preferences for %s: %v", t.username, err)
+		}
+	}
+}
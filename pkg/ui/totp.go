@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"todoissh/pkg/user"
+)
+
+// maxTOTPAttempts is how many wrong codes end the session
+const maxTOTPAttempts = 3
+
+// totpCodeLength is the number of digits in a TOTP code
+const totpCodeLength = 6
+
+// displayTOTPScreen asks for the second factor of a user enrolled in TOTP
+func (t *TerminalUI) displayTOTPScreen() {
+	t.write(t.strings.TOTPTitle + "\r\n")
+	t.write(strings.Repeat("─", t.width) + "\r\n\r\n")
+	t.write(t.strings.TOTPPrompt + "\r\n\r\n")
+
+	if t.status != "" {
+		t.write(t.status + "\r\n\r\n")
+		t.status = ""
+	}
+
+	t.write(t.strings.TOTPLabel + t.inputText)
+	t.showCursor() // Cursor is left right after the code
+}
+
+// handleTOTPKey processes a keypress on the TOTP screen, reporting whether
+// the session should end
+func (t *TerminalUI) handleTOTPKey(b byte) bool {
+	switch {
+	case b == 3: // Ctrl+C
+		t.interrupted = true
+		t.clear()
+		t.showCursor()
+		t.writeLine(t.strings.Goodbye)
+		return true
+	case b == 13: // Enter
+		code := t.inputText
+		t.inputText = ""
+		if t.userStore.VerifyTOTP(t.username, code) {
+			t.mode = ModeNormal
+			t.markViewed()
+			return false
+		}
+		t.totpAttempts++
+		log.Printf("Invalid TOTP code for %s (attempt %d)", t.username, t.totpAttempts)
+		if t.totpAttempts >= maxTOTPAttempts {
+			t.clear()
+			t.showCursor()
+			t.write(t.strings.TOTPTooManyAttempts + "\r\n")
+			return true
+		}
+		t.status = t.strings.TOTPInvalid
+	case b == 8 || b == 127: // Backspace
+		if len(t.inputText) > 0 {
+			t.inputText = t.inputText[:len(t.inputText)-1]
+		}
+	case b >= '0' && b <= '9' && len(t.inputText) < totpCodeLength:
+		t.inputText += string(b)
+	}
+	return false
+}
+
+// toggleTOTP starts enrolling the user in TOTP, showing a new secret and
+// asking for a code from it, or turns it off if they are already enrolled
+func (t *TerminalUI) toggleTOTP() {
+	if t.userStore.HasTOTP(t.username) {
+		if err := t.userStore.DisableTOTP(t.username); err != nil {
+			t.status = fmt.Sprintf(t.strings.TOTPChangeFailedFormat, err)
+			return
+		}
+		t.status = t.strings.TOTPDisabled
+		return
+	}
+
+	secret, err := t.userStore.EnrollTOTP(t.username)
+	if err != nil {
+		t.status = fmt.Sprintf(t.strings.TOTPChangeFailedFormat, err)
+		return
+	}
+	t.totpSecret = secret
+	t.mode = ModeInput
+	t.inputLabel = t.strings.TOTPLabel
+	t.inputAction = inputTOTP
+	t.inputText = ""
+	t.cursorPos = 0
+}
+
+// confirmTOTP turns on the secret shown by toggleTOTP if code is valid for
+// it, so a secret that never reached an authenticator app can't lock the
+// user out
+func (t *TerminalUI) confirmTOTP(code string) {
+	t.totpSecret = ""
+	err := t.userStore.ConfirmTOTP(t.username, code)
+	switch {
+	case err == nil:
+		t.status = t.strings.TOTPEnabled
+	case errors.Is(err, user.ErrInvalidTOTPCode):
+		t.status = t.strings.TOTPNotEnabled
+	default:
+		t.status = fmt.Sprintf(t.strings.TOTPChangeFailedFormat, err)
+	}
+}
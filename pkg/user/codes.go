@@ -0,0 +1,186 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultCodeTTL is how long a one-time login code can be used, and how
+// long its user then has to finish registering
+const DefaultCodeTTL = 7 * 24 * time.Hour
+
+// oneTimeCode lets a user who doesn't have an account yet log in once and
+// choose a password
+type oneTimeCode struct {
+	CodeHash  string    `json:"code_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Redeemed  bool      `json:"redeemed,omitempty"` // Used to log in; registration is now open to this user
+}
+
+// SetCodeTTL sets how long new one-time codes stay valid. Zero or less
+// restores DefaultCodeTTL.
+func (s *Store) SetCodeTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ttl <= 0 {
+		ttl = DefaultCodeTTL
+	}
+	s.codeTTL = ttl
+}
+
+// CreateOneTimeCode provisions username with a code they can log in with
+// once instead of a password, after which they choose their password as
+// new users do. This works even when registration is invite-only or the
+// name is reserved. A new code replaces any earlier one for the name.
+func (s *Store) CreateOneTimeCode(username string) (string, error) {
+	if username == "" {
+		return "", fmt.Errorf("username must not be empty")
+	}
+	if err := ValidateUsername(username); err != nil {
+		return "", err
+	}
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate code: %v", err)
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(raw))
+	hash, err := s.hashPassword(code)
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[username]; exists {
+		return "", ErrUserExists
+	}
+	prev, hadPrev := s.codes[username]
+	s.codes[username] = &oneTimeCode{
+		CodeHash:  string(hash),
+		ExpiresAt: time.Now().Add(s.codeTTL),
+	}
+	if err := s.saveCodes(); err != nil {
+		if hadPrev {
+			s.codes[username] = prev
+		} else {
+			delete(s.codes, username)
+		}
+		return "", err
+	}
+	return code, nil
+}
+
+// RedeemOneTimeCode reports whether code is the unused, unexpired one-time
+// code of username, using it up if so. The user may then register until the
+// code would have expired.
+func (s *Store) RedeemOneTimeCode(username, code string) bool {
+	s.mutex.RLock()
+	entry, exists := s.codes[username]
+	usable := exists && !entry.Redeemed && time.Now().Before(entry.ExpiresAt)
+	s.mutex.RUnlock()
+
+	if !usable || bcrypt.CompareHashAndPassword([]byte(entry.CodeHash), []byte(strings.ToLower(code))) != nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Another login may have used the code while it was being checked
+	if s.codes[username] != entry || entry.Redeemed {
+		return false
+	}
+	entry.Redeemed = true
+	if err := s.saveCodes(); err != nil {
+		entry.Redeemed = false
+		return false
+	}
+	return true
+}
+
+// ResumeInvite reports whether password lets username come back to a
+// registration their one-time code let them start, e.g. after a dropped
+// connection. Until the code expires, that is the password they chose or,
+// if they hadn't chosen one yet, the code itself.
+func (s *Store) ResumeInvite(username, password string) bool {
+	s.mutex.RLock()
+	_, registered := s.users[username]
+	entry := s.codes[username]
+	pending := s.pending[username]
+	usable := !registered && s.invited(username)
+	if pending != nil && s.pendingExpired(pending, time.Now()) {
+		pending = nil
+	}
+	s.mutex.RUnlock()
+
+	if !usable {
+		return false
+	}
+	if pending != nil {
+		return bcrypt.CompareHashAndPassword([]byte(pending.PasswordHash), []byte(password)) == nil
+	}
+	return bcrypt.CompareHashAndPassword([]byte(entry.CodeHash), []byte(strings.ToLower(password))) == nil
+}
+
+// invited reports whether the user redeemed a one-time code that hasn't
+// expired, which lets them register despite the registration limits.
+// We assume the caller already has the lock.
+func (s *Store) invited(username string) bool {
+	entry, exists := s.codes[username]
+	return exists && entry.Redeemed && time.Now().Before(entry.ExpiresAt)
+}
+
+// loadCodes reads one-time codes from disk, dropping expired ones
+func (s *Store) loadCodes() error {
+	data, err := os.ReadFile(s.codesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var codes map[string]*oneTimeCode
+	if err := json.Unmarshal(data, &codes); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for username, code := range codes {
+		if code == nil || !now.Before(code.ExpiresAt) {
+			delete(codes, username)
+		}
+	}
+	if codes != nil {
+		s.codes = codes
+	}
+	return nil
+}
+
+// saveCodes writes one-time codes to disk, dropping expired ones.
+// We assume the caller already has the lock.
+func (s *Store) saveCodes() error {
+	now := time.Now()
+	for username, code := range s.codes {
+		if !now.Before(code.ExpiresAt) {
+			delete(s.codes, username)
+		}
+	}
+
+	data, err := json.MarshalIndent(s.codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.codesPath, data, 0600)
+}
@@ -0,0 +1,32 @@
+package user
+
+import "time"
+
+// UserStore is the set of account operations front ends such as the
+// terminal UI rely on. *Store implements it; tests can substitute their own.
+type UserStore interface {
+	GetUser(username string) *User
+	SetPreferences(username string, prefs Preferences) error
+	MarkViewed(username string, now time.Time) (time.Time, error)
+
+	// Self-service registration
+	PasswordPolicy() Policy
+	StartRegistration(username, password string) error
+	PendingRegistration(username string) (time.Time, bool)
+	ConfirmRegistration(username, password string) error
+	CancelRegistration(username string) error
+
+	// Public keys
+	AuthorizedKeys(username string) []AuthorizedKey
+	AddAuthorizedKey(username, line string) error
+	RemoveAuthorizedKey(username, fingerprint string) error
+
+	// Two-factor authentication
+	HasTOTP(username string) bool
+	EnrollTOTP(username string) (string, error)
+	ConfirmTOTP(username, code string) error
+	VerifyTOTP(username, code string) bool
+	DisableTOTP(username string) error
+}
+
+var _ UserStore = (*Store)(nil)
@@ -0,0 +1,51 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrDataDirInUse is returned by NewLockedStore when another store, usually
+// another running server, holds the lock on the data directory
+var ErrDataDirInUse = errors.New("data directory already in use")
+
+// lockFileName is the lock file NewLockedStore holds in the data directory
+const lockFileName = ".lock"
+
+// NewLockedStore creates a user store like NewStore, but first takes a lock
+// on the data directory, failing with ErrDataDirInUse if another store holds
+// it. This keeps two servers from overwriting each other's users and todos.
+// Close releases the lock.
+func NewLockedStore(dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+	lock, err := lockDir(filepath.Join(dataDir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewStore(dataDir)
+	if err != nil {
+		lock.release()
+		return nil, err
+	}
+	store.lock = lock
+	return store, nil
+}
+
+// Close releases the data directory lock taken by NewLockedStore. It does
+// nothing for stores created with NewStore.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.release()
+	s.lock = nil
+	return err
+}
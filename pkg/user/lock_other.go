@@ -0,0 +1,33 @@
+//go:build !unix
+
+package user
+
+import (
+	"fmt"
+	"os"
+)
+
+// dirLock is a lock file created exclusively. Unlike an flock it outlives a
+// crashed process, and has to be removed by hand then.
+type dirLock struct {
+	path string
+}
+
+// lockDir creates the file at path, failing if it already exists
+func lockDir(path string) (*dirLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return nil, ErrDataDirInUse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %v", err)
+	}
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+	return &dirLock{path: path}, nil
+}
+
+// release removes the lock file
+func (l *dirLock) release() error {
+	return os.Remove(l.path)
+}
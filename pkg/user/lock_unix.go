@@ -0,0 +1,38 @@
+//go:build unix
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// dirLock is an flock on the lock file, which the kernel also releases if
+// the process dies, so a crash leaves no stale lock behind
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir takes an exclusive lock on the file at path, creating it if needed
+func lockDir(path string) (*dirLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDataDirInUse
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+	return &dirLock{file: file}, nil
+}
+
+// release unlocks the file. It stays in place, since removing it could let
+// a store that just opened it lock a file nobody else sees.
+func (l *dirLock) release() error {
+	return l.file.Close()
+}
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultPendingTTL is how long a registration that was started but never
+// confirmed can be resumed before it expires
+const DefaultPendingTTL = 24 * time.Hour
+
+// Errors returned when confirming a pending registration
+var (
+	ErrNoPendingRegistration = errors.New("no pending registration")
+	ErrPasswordMismatch      = errors.New("passwords do not match")
+)
+
+// pendingRegistration is a password chosen during registration that has not
+// been confirmed yet
+type pendingRegistration struct {
+	PasswordHash string    `json:"password_hash"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// SetPendingTTL sets how long unconfirmed registrations are kept. Zero or
+// less restores DefaultPendingTTL.
+func (s *Store) SetPendingTTL(ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ttl <= 0 {
+		ttl = DefaultPendingTTL
+	}
+	s.pendingTTL = ttl
+}
+
+// StartRegistration records the password a new user chose, so the
+// registration can be resumed if they disconnect before confirming it
+func (s *Store) StartRegistration(username, password string) error {
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+	if err := s.PasswordPolicy().Validate(password); err != nil {
+		return err
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.users[username]; exists {
+		return ErrUserExists
+	}
+	if err := s.canRegister(username); err != nil && !s.invited(username) {
+		return err
+	}
+
+	s.pending[username] = &pendingRegistration{
+		PasswordHash: string(hash),
+		StartedAt:    time.Now(),
+	}
+	return s.savePending()
+}
+
+// PendingRegistration reports whether the user started registering and has
+// not confirmed their password yet, and when they started
+func (s *Store) PendingRegistration(username string) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, registered := s.users[username]; registered {
+		return time.Time{}, false
+	}
+	pending, exists := s.pending[username]
+	if !exists || s.pendingExpired(pending, time.Now()) {
+		return time.Time{}, false
+	}
+	return pending.StartedAt, true
+}
+
+// ConfirmRegistration completes a pending registration if the password
+// matches the one chosen when it was started
+func (s *Store) ConfirmRegistration(username, password string) error {
+	s.mutex.RLock()
+	pending, exists := s.pending[username]
+	expired := exists && s.pendingExpired(pending, time.Now())
+	limited := s.canRegister(username)
+	if s.invited(username) {
+		limited = nil
+	}
+	s.mutex.RUnlock()
+
+	if !exists || expired {
+		return ErrNoPendingRegistration
+	}
+	if limited != nil {
+		return limited
+	}
+	if bcrypt.CompareHashAndPassword([]byte(pending.PasswordHash), []byte(password)) != nil {
+		return ErrPasswordMismatch
+	}
+
+	// Register clears the pending entry
+	return s.Register(username, password)
+}
+
+// CancelRegistration forgets a pending registration
+func (s *Store) CancelRegistration(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.pending[username]; !exists {
+		return nil
+	}
+	delete(s.pending, username)
+	return s.savePending()
+}
+
+// pendingExpired reports whether a pending registration is too old to resume
+func (s *Store) pendingExpired(pending *pendingRegistration, now time.Time) bool {
+	return now.Sub(pending.StartedAt) > s.pendingTTL
+}
+
+// loadPending reads pending registrations from disk, dropping expired ones
+func (s *Store) loadPending() error {
+	data, err := os.ReadFile(s.pendingPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var pending map[string]*pendingRegistration
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for username, p := range pending {
+		if s.pendingExpired(p, now) {
+			delete(pending, username)
+		}
+	}
+	s.pending = pending
+	return nil
+}
+
+// savePending writes pending registrations to disk, dropping expired ones.
+// We assume the caller already has the lock.
+func (s *Store) savePending() error {
+	now := time.Now()
+	for username, p := range s.pending {
+		if s.pendingExpired(p, now) {
+			delete(s.pending, username)
+		}
+	}
+
+	data, err := json.MarshalIndent(s.pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.pendingPath, data, 0600)
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Policy lists the requirements a password must meet when registering
+type Policy struct {
+	MinLength        int
+	RequireDigit     bool
+	RequireMixedCase bool // at least one upper and one lower case letter
+	RequireSymbol    bool // at least one character that is not a letter or digit
+}
+
+// DefaultPolicy only requires a minimum length of 6 characters
+var DefaultPolicy = Policy{MinLength: 6}
+
+// PolicyError lists the requirements a rejected password did not meet
+type PolicyError struct {
+	Unmet []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password must " + strings.Join(e.Unmet, ", ")
+}
+
+// Requirements describes every rule of the policy, for display to users
+func (p Policy) Requirements() []string {
+	var reqs []string
+	if p.MinLength > 0 {
+		reqs = append(reqs, fmt.Sprintf("be at least %d characters long", p.MinLength))
+	}
+	if p.RequireDigit {
+		reqs = append(reqs, "contain a digit")
+	}
+	if p.RequireMixedCase {
+		reqs = append(reqs, "contain upper and lower case letters")
+	}
+	if p.RequireSymbol {
+		reqs = append(reqs, "contain a symbol")
+	}
+	return reqs
+}
+
+// Validate checks a password against the policy, returning a *PolicyError
+// listing every unmet requirement
+func (p Policy) Validate(password string) error {
+	var hasDigit, hasUpper, hasLower, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case !unicode.IsLetter(r):
+			hasSymbol = true
+		}
+	}
+
+	var unmet []string
+	if len([]rune(password)) < p.MinLength {
+		unmet = append(unmet, fmt.Sprintf("be at least %d characters long", p.MinLength))
+	}
+	if p.RequireDigit && !hasDigit {
+		unmet = append(unmet, "contain a digit")
+	}
+	if p.RequireMixedCase && !(hasUpper && hasLower) {
+		unmet = append(unmet, "contain upper and lower case letters")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		unmet = append(unmet, "contain a symbol")
+	}
+	if len(unmet) > 0 {
+		return &PolicyError{Unmet: unmet}
+	}
+	return nil
+}
+
+// SetPasswordPolicy sets the rules new passwords must meet
+func (s *Store) SetPasswordPolicy(policy Policy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.policy = policy
+}
+
+// PasswordPolicy returns the rules new passwords must meet
+func (s *Store) PasswordPolicy() Policy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.policy
+}
+
+// SetPasswordCost sets the bcrypt cost of new password hashes. Existing
+// hashes keep the cost they were made with. Costs below bcrypt.DefaultCost
+// are meant for tests, where bcrypt.MinCost makes registering users cheap.
+// Values outside bcrypt's range restore the default.
+func (s *Store) SetPasswordCost(cost int) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.cost = cost
+}
+
+// hashPassword hashes a password with the store's bcrypt cost, failing
+// with ErrBusy if too many hashes are already running; see
+// SetMaxRegistrations
+func (s *Store) hashPassword(password string) ([]byte, error) {
+	release, err := s.acquireHashSlot()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	s.mutex.RLock()
+	cost := s.cost
+	s.mutex.RUnlock()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+	return hash, nil
+}
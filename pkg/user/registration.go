@@ -0,0 +1,115 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRegistrationClosed is returned when new users can't sign themselves up
+	ErrRegistrationClosed = errors.New("registration is closed")
+	// ErrNameReserved is returned when registering a reserved username
+	ErrNameReserved = errors.New("username is reserved")
+	// ErrBusy is returned when too many passwords are being hashed at once
+	// for a registration to start within registrationWait
+	ErrBusy = errors.New("too many registrations at once, try again shortly")
+	// ErrInvalidUsername is returned for usernames that can't safely name a
+	// file, see ValidateUsername
+	ErrInvalidUsername = errors.New("invalid username")
+)
+
+// ValidateUsername returns ErrInvalidUsername unless username can be given
+// to a new account. Usernames name the user's todos file, so they may not
+// contain path separators or "..", or start with a dot.
+func ValidateUsername(username string) error {
+	if username == "" || strings.HasPrefix(username, ".") ||
+		strings.Contains(username, "..") || strings.ContainsAny(username, "/\\\x00") {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+// DefaultMaxRegistrations is how many passwords may be hashed at once
+// unless SetMaxRegistrations says otherwise
+const DefaultMaxRegistrations = 4
+
+// registrationWait is how long a registration waits for a hashing slot
+// before failing with ErrBusy; a variable so tests can shorten it
+var registrationWait = 2 * time.Second
+
+// SetMaxRegistrations limits how many passwords are hashed at once, so a
+// flood of new users can't tie up every CPU with bcrypt. This covers
+// registering, changing passwords and creating one-time codes. Zero or less
+// removes the limit.
+func (s *Store) SetMaxRegistrations(n int) {
+	var slots chan struct{}
+	if n > 0 {
+		slots = make(chan struct{}, n)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hashSlots = slots
+}
+
+// acquireHashSlot waits up to registrationWait for a hashing slot, returning
+// a function that frees it, or ErrBusy if none became free
+func (s *Store) acquireHashSlot() (func(), error) {
+	s.mutex.RLock()
+	slots := s.hashSlots
+	s.mutex.RUnlock()
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(registrationWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	}
+}
+
+// SetInviteOnly stops new users from registering themselves over SSH, so
+// accounts can only be created by the operator
+func (s *Store) SetInviteOnly(inviteOnly bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inviteOnly = inviteOnly
+}
+
+// SetReservedNames sets usernames nobody may register themselves, compared
+// case-insensitively. Existing accounts with these names keep working.
+func (s *Store) SetReservedNames(names []string) {
+	reserved := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			reserved[strings.ToLower(name)] = true
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reserved = reserved
+}
+
+// CanRegister reports why username can't be registered by its owner, or nil
+// if it can. It doesn't check whether the name is taken.
+func (s *Store) CanRegister(username string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.canRegister(username)
+}
+
+// canRegister is CanRegister for callers that already hold the lock
+func (s *Store) canRegister(username string) error {
+	if s.inviteOnly {
+		return ErrRegistrationClosed
+	}
+	if s.reserved[strings.ToLower(username)] {
+		return ErrNameReserved
+	}
+	return nil
+}
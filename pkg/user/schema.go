@@ -0,0 +1,56 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentSchemaVersion is the version of the users file format this build
+// writes. Files saved before versioning are a bare object of users keyed by
+// name and count as 0.
+const CurrentSchemaVersion = 1
+
+// usersFile is how the users file is laid out from version 1 on
+type usersFile struct {
+	SchemaVersion int              `json:"schema_version"`
+	Users         map[string]*User `json:"users"`
+}
+
+// encodeUsers lays out users as a users file at the current version
+func encodeUsers(users map[string]*User) ([]byte, error) {
+	return json.MarshalIndent(usersFile{
+		SchemaVersion: CurrentSchemaVersion,
+		Users:         users,
+	}, "", "  ")
+}
+
+// decodeUsers parses a users file of any supported version, returning the
+// users and the version they were stored at. Files written by a newer
+// version are refused rather than risk losing what they added.
+func decodeUsers(data []byte) (map[string]*User, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	// A version 0 file could hold a user named schema_version, but its
+	// value would be an object rather than a number
+	raw, versioned := fields["schema_version"]
+	if !versioned || bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		var users map[string]*User
+		if err := json.Unmarshal(data, &users); err != nil {
+			return nil, 0, err
+		}
+		return users, 0, nil
+	}
+
+	var file usersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, 0, err
+	}
+	if file.SchemaVersion > CurrentSchemaVersion {
+		return nil, 0, fmt.Errorf("users file has schema version %d, but this version of todoissh only supports up to %d", file.SchemaVersion, CurrentSchemaVersion)
+	}
+	return file.Users, file.SchemaVersion, nil
+}
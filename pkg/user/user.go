@@ -2,19 +2,60 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
+// ErrUserExists is returned when registering a username that is taken
+var ErrUserExists = errors.New("user already exists")
+
 // User represents a user in the system
 type User struct {
-	Username     string `json:"username"`
-	PasswordHash string `json:"password_hash"`
-	IsNew        bool   `json:"-"` // Not stored, used for first-time login detection
+	Username       string          `json:"username"`
+	PasswordHash   string          `json:"password_hash"`
+	CreatedAt      time.Time       `json:"created_at,omitempty"`
+	Preferences    Preferences     `json:"preferences"`
+	AuthorizedKeys []AuthorizedKey `json:"authorized_keys,omitempty"` // Public keys allowed to log in
+	TOTPSecret     string          `json:"totp_secret,omitempty"`     // Base32 TOTP secret; empty when not enrolled
+	LastViewed     time.Time       `json:"last_viewed,omitempty"`     // When the user last opened their list
+	IsNew          bool            `json:"-"`                         // Not stored, used for first-time login detection
+}
+
+// clone returns a copy of the user that shares nothing with the stored one,
+// so it can be read without holding the store's lock
+func (u *User) clone() *User {
+	c := *u
+	c.AuthorizedKeys = append([]AuthorizedKey(nil), u.AuthorizedKeys...)
+	if u.Preferences.Colors != nil {
+		colors := *u.Preferences.Colors
+		c.Preferences.Colors = &colors
+	}
+	return &c
+}
+
+// AuthorizedKey is an SSH public key a user has registered for login
+type AuthorizedKey struct {
+	Line        string    `json:"line"`        // authorized_keys format, including any comment
+	Fingerprint string    `json:"fingerprint"` // SHA256 fingerprint
+	AddedAt     time.Time `json:"added_at"`
+}
+
+// Preferences holds per-user UI settings that persist across sessions
+type Preferences struct {
+	Colors      *bool  `json:"colors,omitempty"`       // nil uses the server default
+	RowTemplate string `json:"row_template,omitempty"` // empty uses the default layout
+	ShowIDs     bool   `json:"show_ids,omitempty"`     // number rows by todo ID instead of position
+	SelectedID  int    `json:"selected_id,omitempty"`  // todo selected when the last session ended
+	Theme       string `json:"theme,omitempty"`        // empty uses the default theme
 }
 
 // Store manages users and their authentication
@@ -22,6 +63,34 @@ type Store struct {
 	users map[string]*User
 	mutex sync.RWMutex
 	path  string
+
+	policy    Policy        // Rules for new passwords
+	cost      int           // bcrypt cost of new password hashes
+	hashSlots chan struct{} // Limits concurrent hashing; nil means unlimited
+
+	// Limits on who may register themselves; see registration.go
+	inviteOnly bool
+	reserved   map[string]bool
+
+	// Registrations started but not confirmed yet, persisted separately
+	pending     map[string]*pendingRegistration
+	pendingPath string
+	pendingTTL  time.Duration
+
+	// One-time login codes for provisioned users; see codes.go
+	codes     map[string]*oneTimeCode
+	codesPath string
+	codeTTL   time.Duration
+
+	// TOTP secrets waiting for a first code, and the last step each
+	// user's codes were accepted for; see totp.go. Neither is persisted.
+	totpPending  map[string]string
+	totpLastStep map[string]int64
+
+	// Profiles of deleted users are kept here; see SetDeletionArchiveDir
+	deletionDir string
+
+	lock *dirLock // Held on the data directory; see NewLockedStore
 }
 
 // NewStore creates a new user store
@@ -33,8 +102,19 @@ func NewStore(dataDir string) (*Store, error) {
 
 	path := filepath.Join(dataDir, "users.json")
 	store := &Store{
-		users: make(map[string]*User),
-		path:  path,
+		users:        make(map[string]*User),
+		path:         path,
+		policy:       DefaultPolicy,
+		cost:         bcrypt.DefaultCost,
+		hashSlots:    make(chan struct{}, DefaultMaxRegistrations),
+		pending:      make(map[string]*pendingRegistration),
+		pendingPath:  filepath.Join(dataDir, "pending.json"),
+		pendingTTL:   DefaultPendingTTL,
+		codes:        make(map[string]*oneTimeCode),
+		totpPending:  make(map[string]string),
+		totpLastStep: make(map[string]int64),
+		codesPath:    filepath.Join(dataDir, "codes.json"),
+		codeTTL:      DefaultCodeTTL,
 	}
 
 	// Load existing users if the file exists
@@ -43,6 +123,12 @@ func NewStore(dataDir string) (*Store, error) {
 			return nil, fmt.Errorf("failed to load users: %v", err)
 		}
 	}
+	if err := store.loadPending(); err != nil {
+		return nil, fmt.Errorf("failed to load pending registrations: %v", err)
+	}
+	if err := store.loadCodes(); err != nil {
+		return nil, fmt.Errorf("failed to load one-time codes: %v", err)
+	}
 
 	return store, nil
 }
@@ -52,6 +138,9 @@ func NewStore(dataDir string) (*Store, error) {
 func (s *Store) Authenticate(username, password string) (*User, bool) {
 	s.mutex.RLock()
 	user, exists := s.users[username]
+	if exists {
+		user = user.clone()
+	}
 	s.mutex.RUnlock()
 
 	if !exists {
@@ -64,34 +153,349 @@ func (s *Store) Authenticate(username, password string) (*User, bool) {
 	return user, err == nil
 }
 
-// Register creates a new user or updates an existing user's password
+// Register creates a new user, failing with ErrUserExists if the name is
+// taken. The password must satisfy the store's password policy.
 func (s *Store) Register(username, password string) error {
+	return s.setPassword(username, password, true, false)
+}
+
+// UpdatePassword changes the password of an existing user, keeping the rest
+// of the profile. The password must satisfy the store's password policy.
+func (s *Store) UpdatePassword(username, newPassword string) error {
+	return s.setPassword(username, newPassword, false, true)
+}
+
+// Upsert creates the user if needed, or else updates their password
+func (s *Store) Upsert(username, password string) error {
+	return s.setPassword(username, password, true, true)
+}
+
+// setPassword validates and hashes password, then either creates the user
+// or updates an existing one, as allowed by create and update
+func (s *Store) setPassword(username, password string, create, update bool) error {
+	if err := s.PasswordPolicy().Validate(password); err != nil {
+		return err
+	}
+
 	// Generate password hash
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := s.hashPassword(password)
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %v", err)
+		return err
 	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Create or update user
+	// Update the password of an existing user, keeping the rest of the profile
+	if user, exists := s.users[username]; exists {
+		if !update {
+			return ErrUserExists
+		}
+		prev := user.PasswordHash
+		user.PasswordHash = string(hash)
+		if err := s.save(); err != nil {
+			user.PasswordHash = prev
+			return err
+		}
+		return nil
+	}
+	if !create {
+		return fmt.Errorf("user %s not found", username)
+	}
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+
+	// Create user
 	s.users[username] = &User{
 		Username:     username,
 		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
 	}
 
 	// Save changes
+	if err := s.save(); err != nil {
+		delete(s.users, username)
+		return err
+	}
+
+	// Any pending registration is now complete, and any one-time code
+	// spent. Clearing them is best effort, since both are ignored once the
+	// user exists.
+	if _, exists := s.pending[username]; exists {
+		delete(s.pending, username)
+		s.savePending()
+	}
+	if _, exists := s.codes[username]; exists {
+		delete(s.codes, username)
+		s.saveCodes()
+	}
+	return nil
+}
+
+// AdminResetPassword sets a new password for an existing user on behalf of
+// the operator. Unlike Register it never creates users and skips the
+// password policy, and it drops any pending registration for the name.
+func (s *Store) AdminResetPassword(username, newPassword string) error {
+	if newPassword == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+	hash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %s not found", username)
+	}
+
+	prev := user.PasswordHash
+	user.PasswordHash = string(hash)
+	if err := s.save(); err != nil {
+		user.PasswordHash = prev
+		return err
+	}
+
+	if _, exists := s.pending[username]; exists {
+		delete(s.pending, username)
+		s.savePending()
+	}
+	return nil
+}
+
+// Usernames returns the names of all registered users, sorted
+func (s *Store) Usernames() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.users))
+	for name := range s.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Count returns the number of registered users
+func (s *Store) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.users)
+}
+
+// Delete removes a registered user, first keeping a copy of their profile
+// if a deletion archive directory is set
+func (s *Store) Delete(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %s not found", username)
+	}
+
+	if err := s.keepDeleted(user); err != nil {
+		return err
+	}
+	delete(s.users, username)
+	if err := s.save(); err != nil {
+		s.users[username] = user
+		return err
+	}
+	delete(s.totpPending, username)
+	delete(s.totpLastStep, username)
+	return nil
+}
+
+// SetDeletionArchiveDir makes Delete keep a copy of the user's profile,
+// including their password hash and keys, in a subdirectory of dir named
+// after the user. Copies are never removed automatically. Empty, the
+// default, keeps nothing.
+func (s *Store) SetDeletionArchiveDir(dir string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deletionDir = dir
+}
+
+// keepDeleted writes the profile of a user about to be deleted into the
+// deletion archive directory, if one is set.
+// We assume the caller already has the lock.
+func (s *Store) keepDeleted(user *User) error {
+	if s.deletionDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.deletionDir, user.Username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create deletion archive directory: %v", err)
+	}
+	name := "profile-" + time.Now().Format("20060102-150405") + ".json"
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		return fmt.Errorf("failed to keep deleted profile: %v", err)
+	}
+	return nil
+}
+
+// MarkViewed records that the user is viewing their list now, returning
+// when they last viewed it. The previous time is zero on a first visit.
+func (s *Store) MarkViewed(username string, now time.Time) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return time.Time{}, fmt.Errorf("user %s not found", username)
+	}
+
+	prev := user.LastViewed
+	user.LastViewed = now
+	if err := s.save(); err != nil {
+		user.LastViewed = prev
+		return time.Time{}, err
+	}
+	return prev, nil
+}
+
+// SetPreferences replaces the preferences of an existing user
+func (s *Store) SetPreferences(username string, prefs Preferences) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %s not found", username)
+	}
+
+	user.Preferences = prefs
 	return s.save()
 }
 
-// GetUser retrieves a user by username
+// AddAuthorizedKey validates a public key in authorized_keys format and
+// allows it to log in as the given user
+func (s *Store) AddAuthorizedKey(username, line string) error {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+
+	normalized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		normalized += " " + comment
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %s not found", username)
+	}
+	for _, existing := range user.AuthorizedKeys {
+		if existing.Fingerprint == fingerprint {
+			return fmt.Errorf("key %s is already authorized", fingerprint)
+		}
+	}
+
+	user.AuthorizedKeys = append(user.AuthorizedKeys, AuthorizedKey{
+		Line:        normalized,
+		Fingerprint: fingerprint,
+		AddedAt:     time.Now(),
+	})
+	return s.save()
+}
+
+// RemoveAuthorizedKey revokes the key with the given fingerprint
+func (s *Store) RemoveAuthorizedKey(username, fingerprint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("user %s not found", username)
+	}
+	for i, existing := range user.AuthorizedKeys {
+		if existing.Fingerprint == fingerprint {
+			user.AuthorizedKeys = append(user.AuthorizedKeys[:i:i], user.AuthorizedKeys[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("key %s not found", fingerprint)
+}
+
+// AuthorizedKeys returns a copy of the keys authorized for the given user
+func (s *Store) AuthorizedKeys(username string) []AuthorizedKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return nil
+	}
+	return append([]AuthorizedKey(nil), user.AuthorizedKeys...)
+}
+
+// AuthenticateKey reports whether the public key is authorized for the user
+func (s *Store) AuthenticateKey(username string, key ssh.PublicKey) bool {
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, existing := range s.AuthorizedKeys(username) {
+		if existing.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUser retrieves a copy of a user by username
 func (s *Store) GetUser(username string) *User {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	if user, exists := s.users[username]; exists {
-		return user
+		return user.clone()
+	}
+	return nil
+}
+
+// Backup writes the users, pending registrations and one-time codes into
+// dir, using the same file names and layout as the data directory
+func (s *Store) Backup(dir string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	users, err := encodeUsers(s.users)
+	if err != nil {
+		return err
+	}
+	pending, err := json.MarshalIndent(s.pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	codes, err := json.MarshalIndent(s.codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		filepath.Base(s.path):        users,
+		filepath.Base(s.pendingPath): pending,
+		filepath.Base(s.codesPath):   codes,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return fmt.Errorf("failed to write backup: %v", err)
+		}
 	}
 	return nil
 }
@@ -108,18 +512,25 @@ func (s *Store) load() error {
 		return nil
 	}
 
-	var users map[string]*User
-	if err := json.Unmarshal(data, &users); err != nil {
+	users, version, err := decodeUsers(data)
+	if err != nil {
 		return err
 	}
+	if users != nil {
+		s.users = users
+	}
 
-	s.users = users
+	if version < CurrentSchemaVersion {
+		// Rewrite at the current version; if this fails, the next
+		// successful save does it instead
+		s.save()
+	}
 	return nil
 }
 
 // save writes users to disk
 func (s *Store) save() error {
-	data, err := json.MarshalIndent(s.users, "", "  ")
+	data, err := encodeUsers(s.users)
 	if err != nil {
 		return err
 	}
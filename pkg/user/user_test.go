@@ -1,11 +1,19 @@
 package user
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
 // Test constants
@@ -30,6 +38,8 @@ func setupTestStore(t *testing.T) (*Store, string) {
 		os.RemoveAll(tempDir) // Clean up on error
 		t.Fatalf("NewStore() error = %v", err)
 	}
+	// Hashing at the default cost would dominate the run time
+	store.SetPasswordCost(bcrypt.MinCost)
 
 	return store, tempDir
 }
@@ -154,6 +164,15 @@ func TestGetUser(t *testing.T) {
 		t.Errorf("user.Username = %s; want %s", user.Username, testUsername)
 	}
 
+	// The user returned is a copy, so later changes don't show through
+	hash := user.PasswordHash
+	if err := store.UpdatePassword(testUsername, "newpassword123"); err != nil {
+		t.Fatalf("UpdatePassword() error = %v", err)
+	}
+	if user.PasswordHash != hash {
+		t.Error("user returned by GetUser() changed with UpdatePassword()")
+	}
+
 	// Get non-existent user
 	user = store.GetUser("nonexistent")
 	if user != nil {
@@ -207,9 +226,9 @@ func TestUpdateUser(t *testing.T) {
 
 	// Update password
 	newPassword := "new-password456"
-	err = store.Register(testUsername, newPassword)
+	err = store.UpdatePassword(testUsername, newPassword)
 	if err != nil {
-		t.Fatalf("Register() error = %v", err)
+		t.Fatalf("UpdatePassword() error = %v", err)
 	}
 
 	// Verify old password no longer works
@@ -225,6 +244,40 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+// TestRegisterExistingUser verifies that Register never overwrites an
+// account, while UpdatePassword only changes existing ones and Upsert does both
+func TestRegisterExistingUser(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if err := store.UpdatePassword(testUsername, testPassword); err == nil {
+		t.Error("UpdatePassword() for unknown user succeeded")
+	}
+	if store.GetUser(testUsername) != nil {
+		t.Fatal("UpdatePassword() created a user")
+	}
+
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := store.Register(testUsername, "other-password"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Register() of existing user error = %v; want ErrUserExists", err)
+	}
+	if _, ok := store.Authenticate(testUsername, testPassword); !ok {
+		t.Error("Register() of existing user changed the password")
+	}
+
+	if err := store.Upsert(testUsername, "upserted-password"); err != nil {
+		t.Fatalf("Upsert() error = %v", err)
+	}
+	if _, ok := store.Authenticate(testUsername, "upserted-password"); !ok {
+		t.Error("Upsert() did not update the password")
+	}
+	if err := store.Upsert("newuser", testPassword); err != nil || store.GetUser("newuser") == nil {
+		t.Errorf("Upsert() of new user error = %v; want the user created", err)
+	}
+}
+
 // TestConcurrentOperations verifies that concurrent operations work correctly
 func TestConcurrentOperations(t *testing.T) {
 	store, tempDir := setupTestStore(t)
@@ -248,11 +301,11 @@ func TestConcurrentOperations(t *testing.T) {
 					t.Errorf("Authenticate() failed in goroutine %d", i)
 				}
 			} else {
-				// Update password (write operation)
-				tempPass := testPassword + "-" + string(rune(i+'0'))
-				err := store.Register(testUsername, tempPass)
+				// Update password (write operation). The password is
+				// unchanged so the readers' credentials stay valid.
+				err := store.UpdatePassword(testUsername, testPassword)
 				if err != nil {
-					t.Errorf("Register() error = %v in goroutine %d", err, i)
+					t.Errorf("UpdatePassword() error = %v in goroutine %d", err, i)
 				}
 			}
 			done <- true
@@ -407,3 +460,688 @@ func TestLoadError(t *testing.T) {
 		t.Fatal("load() did not return error when reading unreadable file")
 	}
 }
+
+// TestPreferences verifies that preferences persist and survive a password change
+func TestPreferences(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	// Setting preferences for an unknown user fails
+	if err := store.SetPreferences(testUsername, Preferences{}); err == nil {
+		t.Error("SetPreferences() did not return error for unknown user")
+	}
+
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	created := store.GetUser(testUsername).CreatedAt
+	if created.IsZero() {
+		t.Error("CreatedAt not set on registration")
+	}
+
+	colors := false
+	prefs := Preferences{Colors: &colors, RowTemplate: "{{.Text}}"}
+	if err := store.SetPreferences(testUsername, prefs); err != nil {
+		t.Fatalf("SetPreferences() error = %v", err)
+	}
+
+	// Changing the password keeps the rest of the profile
+	if err := store.UpdatePassword(testUsername, "another-password"); err != nil {
+		t.Fatalf("UpdatePassword() error = %v", err)
+	}
+
+	// Reload from disk
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	user := store2.GetUser(testUsername)
+	if user == nil {
+		t.Fatal("User not found after reload")
+	}
+	if user.Preferences.Colors == nil || *user.Preferences.Colors {
+		t.Errorf("Preferences.Colors = %v; want false", user.Preferences.Colors)
+	}
+	if user.Preferences.RowTemplate != "{{.Text}}" {
+		t.Errorf("Preferences.RowTemplate = %q; want %q", user.Preferences.RowTemplate, "{{.Text}}")
+	}
+	if !user.CreatedAt.Equal(created) {
+		t.Errorf("CreatedAt = %v; want %v", user.CreatedAt, created)
+	}
+}
+
+// TestAuthorizedKeys verifies adding, authenticating with and removing public keys
+func TestAuthorizedKeys(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " me@laptop"
+
+	// Keys can only be added for existing users
+	if err := store.AddAuthorizedKey(testUsername, line); err == nil {
+		t.Error("AddAuthorizedKey() did not return error for unknown user")
+	}
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	if err := store.AddAuthorizedKey(testUsername, "not a key"); err == nil {
+		t.Error("AddAuthorizedKey() did not return error for invalid key")
+	}
+	if store.AuthenticateKey(testUsername, key) {
+		t.Error("AuthenticateKey() succeeded before the key was added")
+	}
+
+	if err := store.AddAuthorizedKey(testUsername, line); err != nil {
+		t.Fatalf("AddAuthorizedKey() error = %v", err)
+	}
+	if err := store.AddAuthorizedKey(testUsername, line); err == nil {
+		t.Error("AddAuthorizedKey() did not return error for duplicate key")
+	}
+	if !store.AuthenticateKey(testUsername, key) {
+		t.Error("AuthenticateKey() failed for authorized key")
+	}
+	if store.AuthenticateKey("someone-else", key) {
+		t.Error("AuthenticateKey() succeeded for a different user")
+	}
+
+	// Keys persist with their comment
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	keys := store2.AuthorizedKeys(testUsername)
+	if len(keys) != 1 || keys[0].Line != line || keys[0].Fingerprint != ssh.FingerprintSHA256(key) {
+		t.Fatalf("AuthorizedKeys() = %+v; want one key %q", keys, line)
+	}
+
+	if err := store.RemoveAuthorizedKey(testUsername, "SHA256:unknown"); err == nil {
+		t.Error("RemoveAuthorizedKey() did not return error for unknown fingerprint")
+	}
+	if err := store.RemoveAuthorizedKey(testUsername, keys[0].Fingerprint); err != nil {
+		t.Fatalf("RemoveAuthorizedKey() error = %v", err)
+	}
+	if store.AuthenticateKey(testUsername, key) {
+		t.Error("AuthenticateKey() succeeded after the key was removed")
+	}
+}
+
+// TestPendingRegistration verifies that a started registration survives a
+// restart, can be confirmed only with the same password, and expires
+func TestPendingRegistration(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if _, pending := store.PendingRegistration(testUsername); pending {
+		t.Fatal("PendingRegistration() = true before registration started")
+	}
+	if err := store.ConfirmRegistration(testUsername, testPassword); err != ErrNoPendingRegistration {
+		t.Errorf("ConfirmRegistration() error = %v; want ErrNoPendingRegistration", err)
+	}
+
+	if err := store.StartRegistration(testUsername, testPassword); err != nil {
+		t.Fatalf("StartRegistration() error = %v", err)
+	}
+
+	// Pending registrations persist, but don't create the user
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if _, pending := store2.PendingRegistration(testUsername); !pending {
+		t.Fatal("PendingRegistration() = false after restart")
+	}
+	if store2.GetUser(testUsername) != nil {
+		t.Error("GetUser() returned a user for a pending registration")
+	}
+
+	if err := store2.ConfirmRegistration(testUsername, "wrong-password"); err != ErrPasswordMismatch {
+		t.Errorf("ConfirmRegistration() error = %v; want ErrPasswordMismatch", err)
+	}
+	if err := store2.ConfirmRegistration(testUsername, testPassword); err != nil {
+		t.Fatalf("ConfirmRegistration() error = %v", err)
+	}
+	if _, ok := store2.Authenticate(testUsername, testPassword); !ok {
+		t.Error("Authenticate() failed after confirming registration")
+	}
+	if _, pending := store2.PendingRegistration(testUsername); pending {
+		t.Error("PendingRegistration() = true after confirming registration")
+	}
+	if err := store2.StartRegistration(testUsername, testPassword); err == nil {
+		t.Error("StartRegistration() did not return error for a registered user")
+	}
+
+	// Cancelled and expired registrations can't be resumed
+	if err := store2.StartRegistration("cancelled", testPassword); err != nil {
+		t.Fatalf("StartRegistration() error = %v", err)
+	}
+	if err := store2.CancelRegistration("cancelled"); err != nil {
+		t.Fatalf("CancelRegistration() error = %v", err)
+	}
+	if _, pending := store2.PendingRegistration("cancelled"); pending {
+		t.Error("PendingRegistration() = true after cancelling")
+	}
+
+	if err := store2.StartRegistration("expired", testPassword); err != nil {
+		t.Fatalf("StartRegistration() error = %v", err)
+	}
+	store2.SetPendingTTL(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, pending := store2.PendingRegistration("expired"); pending {
+		t.Error("PendingRegistration() = true after expiry")
+	}
+	if err := store2.ConfirmRegistration("expired", testPassword); err != ErrNoPendingRegistration {
+		t.Errorf("ConfirmRegistration() error = %v; want ErrNoPendingRegistration", err)
+	}
+}
+
+// TestPasswordPolicy verifies that Register enforces the configured policy
+// and reports every unmet requirement
+func TestPasswordPolicy(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	// The default policy only requires 6 characters
+	if err := store.Register("short", "12345"); err == nil {
+		t.Error("Register() accepted a 5 character password with the default policy")
+	}
+	if err := store.Register("plain", "abcdef"); err != nil {
+		t.Errorf("Register() error = %v with the default policy", err)
+	}
+
+	store.SetPasswordPolicy(Policy{MinLength: 8, RequireDigit: true, RequireMixedCase: true, RequireSymbol: true})
+
+	err := store.Register(testUsername, "abc")
+	policyErr, ok := err.(*PolicyError)
+	if !ok {
+		t.Fatalf("Register() error = %v; want *PolicyError", err)
+	}
+	if len(policyErr.Unmet) != 4 {
+		t.Errorf("Unmet = %q; want all 4 requirements", policyErr.Unmet)
+	}
+	if !strings.Contains(err.Error(), "at least 8 characters") {
+		t.Errorf("Error() = %q; want it to mention the minimum length", err.Error())
+	}
+
+	err = store.Register(testUsername, "abcdefgh1!")
+	if policyErr, ok := err.(*PolicyError); !ok || len(policyErr.Unmet) != 1 {
+		t.Errorf("Register() error = %v; want only the mixed case requirement", err)
+	}
+	if err := store.Register(testUsername, "Abcdefgh1!"); err != nil {
+		t.Errorf("Register() error = %v for a compliant password", err)
+	}
+	if got := len(store.PasswordPolicy().Requirements()); got != 4 {
+		t.Errorf("Requirements() has %d entries; want 4", got)
+	}
+}
+
+// TestTOTP verifies TOTP enrollment, code verification and removal
+func TestTOTP(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	// RFC 6238 test vector for SHA-1, truncated to 6 digits
+	if got := totpCode([]byte("12345678901234567890"), 59/30); got != "287082" {
+		t.Errorf("totpCode() = %s; want 287082", got)
+	}
+
+	if _, err := store.EnrollTOTP(testUsername); err == nil {
+		t.Error("EnrollTOTP() did not return error for unknown user")
+	}
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if store.HasTOTP(testUsername) {
+		t.Error("HasTOTP() = true before enrolling")
+	}
+
+	secret, err := store.EnrollTOTP(testUsername)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+
+	now := time.Now()
+	step := uint64(now.Unix() / 30)
+
+	// Nothing changes until a code for the new secret is entered
+	if store.HasTOTP(testUsername) {
+		t.Error("HasTOTP() = true before confirming")
+	}
+	if err := store.ConfirmTOTP(testUsername, totpCode(key, step+10)); err != ErrInvalidTOTPCode {
+		t.Errorf("ConfirmTOTP() with a wrong code error = %v; want ErrInvalidTOTPCode", err)
+	}
+	if err := store.ConfirmTOTP("someone-else", totpCode(key, step)); err != ErrNoPendingTOTP {
+		t.Errorf("ConfirmTOTP() without enrolling error = %v; want ErrNoPendingTOTP", err)
+	}
+	if err := store.ConfirmTOTP(testUsername, totpCode(key, step-1)); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+	if !store.HasTOTP(testUsername) {
+		t.Error("HasTOTP() = false after confirming")
+	}
+
+	// Codes work once, and never for an earlier step than one already used
+	if store.VerifyTOTP(testUsername, totpCode(key, step-1)) {
+		t.Error("VerifyTOTP() accepted the code used to confirm")
+	}
+	if !store.VerifyTOTP(testUsername, totpCode(key, step)) {
+		t.Error("VerifyTOTP() rejected the current code")
+	}
+	if store.VerifyTOTP(testUsername, totpCode(key, step)) {
+		t.Error("VerifyTOTP() accepted the current code twice")
+	}
+	if _, ok := verifyTOTP(secret, totpCode(key, step-1), now); !ok {
+		t.Error("verifyTOTP() rejected the previous code within the window")
+	}
+	if _, ok := verifyTOTP(secret, totpCode(key, step-5), now); ok {
+		t.Error("verifyTOTP() accepted a code outside the window")
+	}
+	if store.VerifyTOTP(testUsername, "12345") {
+		t.Error("VerifyTOTP() accepted a short code")
+	}
+
+	// The secret persists
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if !store2.HasTOTP(testUsername) {
+		t.Error("HasTOTP() = false after reload")
+	}
+
+	if err := store2.DisableTOTP(testUsername); err != nil {
+		t.Fatalf("DisableTOTP() error = %v", err)
+	}
+	if store2.HasTOTP(testUsername) || store2.VerifyTOTP(testUsername, totpCode(key, step+1)) {
+		t.Error("TOTP still active after DisableTOTP()")
+	}
+}
+
+// TestAdminResetPassword verifies that an operator can reset an existing
+// user's password regardless of the password policy
+func TestAdminResetPassword(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if err := store.AdminResetPassword(testUsername, "new-password"); err == nil {
+		t.Error("AdminResetPassword() did not return error for unknown user")
+	}
+	if store.GetUser(testUsername) != nil {
+		t.Error("AdminResetPassword() created a user")
+	}
+
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	store.SetPasswordPolicy(Policy{MinLength: 20})
+
+	if err := store.AdminResetPassword(testUsername, ""); err == nil {
+		t.Error("AdminResetPassword() accepted an empty password")
+	}
+	if err := store.AdminResetPassword(testUsername, "tmp1"); err != nil {
+		t.Fatalf("AdminResetPassword() error = %v", err)
+	}
+	if _, ok := store.Authenticate(testUsername, testPassword); ok {
+		t.Error("Authenticate() accepted the old password")
+	}
+
+	// The new password persists
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if _, ok := store2.Authenticate(testUsername, "tmp1"); !ok {
+		t.Error("Authenticate() rejected the reset password after reload")
+	}
+}
+
+// TestUsernamesAndDelete verifies listing and deleting users
+func TestUsernamesAndDelete(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	for _, name := range []string{"carol", "alice", "bob"} {
+		if err := store.Register(name, testPassword); err != nil {
+			t.Fatalf("Register(%s) error = %v", name, err)
+		}
+	}
+	if got := strings.Join(store.Usernames(), ","); got != "alice,bob,carol" {
+		t.Errorf("Usernames() = %s; want alice,bob,carol", got)
+	}
+	if got := store.Count(); got != 3 {
+		t.Errorf("Count() = %d; want 3", got)
+	}
+
+	if err := store.Delete("bob"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete("bob"); err == nil {
+		t.Error("Delete() did not return error for unknown user")
+	}
+
+	store2, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	if got := strings.Join(store2.Usernames(), ","); got != "alice,carol" {
+		t.Errorf("Usernames() after reload = %s; want alice,carol", got)
+	}
+}
+
+// TestRegistrationLimits verifies that invite-only stores and reserved names
+// stop self-service registration but not existing users or the operator
+func TestRegistrationLimits(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	store.SetReservedNames([]string{"Admin", " root "})
+	for _, name := range []string{"admin", "ADMIN", "root"} {
+		if err := store.CanRegister(name); !errors.Is(err, ErrNameReserved) {
+			t.Errorf("CanRegister(%q) = %v; want ErrNameReserved", name, err)
+		}
+	}
+	if err := store.StartRegistration("admin", testPassword); !errors.Is(err, ErrNameReserved) {
+		t.Errorf("StartRegistration() of reserved name error = %v; want ErrNameReserved", err)
+	}
+	if err := store.CanRegister(testUsername); err != nil {
+		t.Errorf("CanRegister(%q) = %v; want nil", testUsername, err)
+	}
+
+	// A registration started before the store became invite-only can't finish
+	if err := store.StartRegistration(testUsername, testPassword); err != nil {
+		t.Fatalf("StartRegistration() error = %v", err)
+	}
+	store.SetInviteOnly(true)
+	if err := store.ConfirmRegistration(testUsername, testPassword); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("ConfirmRegistration() error = %v; want ErrRegistrationClosed", err)
+	}
+	if err := store.StartRegistration("newuser", testPassword); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("StartRegistration() error = %v; want ErrRegistrationClosed", err)
+	}
+
+	// The operator can still add users
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, ok := store.Authenticate(testUsername, testPassword); !ok {
+		t.Error("Authenticate() failed for user added while invite-only")
+	}
+}
+
+// TestValidateUsername verifies that names which could escape the data
+// directory can't be registered
+func TestValidateUsername(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	for _, name := range []string{"", "../users", "..", ".hidden", "a/b", `a\b`, "a..b"} {
+		if err := ValidateUsername(name); err != ErrInvalidUsername {
+			t.Errorf("ValidateUsername(%q) = %v; want ErrInvalidUsername", name, err)
+		}
+		if err := store.StartRegistration(name, testPassword); err != ErrInvalidUsername {
+			t.Errorf("StartRegistration(%q) error = %v; want ErrInvalidUsername", name, err)
+		}
+		if err := store.Register(name, testPassword); err != ErrInvalidUsername {
+			t.Errorf("Register(%q) error = %v; want ErrInvalidUsername", name, err)
+		}
+	}
+	for _, name := range []string{testUsername, "first.last", "user-1_b"} {
+		if err := ValidateUsername(name); err != nil {
+			t.Errorf("ValidateUsername(%q) = %v; want nil", name, err)
+		}
+	}
+}
+
+// TestOneTimeCode verifies that a one-time code works once, lets its user
+// register on an invite-only store, survives a restart and expires
+func TestOneTimeCode(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	store.SetInviteOnly(true)
+
+	if err := store.Register("taken", testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if _, err := store.CreateOneTimeCode("taken"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("CreateOneTimeCode() for existing user error = %v; want ErrUserExists", err)
+	}
+
+	code, err := store.CreateOneTimeCode(testUsername)
+	if err != nil {
+		t.Fatalf("CreateOneTimeCode() error = %v", err)
+	}
+	if store.RedeemOneTimeCode(testUsername, "wrong") || store.RedeemOneTimeCode("someone-else", code) {
+		t.Error("RedeemOneTimeCode() accepted a wrong code or user")
+	}
+	if err := store.StartRegistration(testUsername, testPassword); !errors.Is(err, ErrRegistrationClosed) {
+		t.Errorf("StartRegistration() before redeeming error = %v; want ErrRegistrationClosed", err)
+	}
+
+	// The code is remembered across restarts, and only works once
+	reloaded, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	reloaded.SetPasswordCost(bcrypt.MinCost)
+	reloaded.SetInviteOnly(true)
+	if !reloaded.RedeemOneTimeCode(testUsername, strings.ToUpper(code)) {
+		t.Fatal("RedeemOneTimeCode() rejected the code")
+	}
+	if reloaded.RedeemOneTimeCode(testUsername, code) {
+		t.Error("RedeemOneTimeCode() accepted a code twice")
+	}
+
+	if err := reloaded.StartRegistration(testUsername, testPassword); err != nil {
+		t.Fatalf("StartRegistration() after redeeming error = %v", err)
+	}
+	if err := reloaded.ConfirmRegistration(testUsername, testPassword); err != nil {
+		t.Fatalf("ConfirmRegistration() error = %v", err)
+	}
+	if _, ok := reloaded.Authenticate(testUsername, testPassword); !ok {
+		t.Error("Authenticate() failed after registering with a code")
+	}
+	if len(reloaded.codes) != 0 {
+		t.Errorf("codes after registering = %v; want none", reloaded.codes)
+	}
+
+	// Expired codes are refused
+	reloaded.SetCodeTTL(time.Millisecond)
+	code, err = reloaded.CreateOneTimeCode("late")
+	if err != nil {
+		t.Fatalf("CreateOneTimeCode() error = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if reloaded.RedeemOneTimeCode("late", code) {
+		t.Error("RedeemOneTimeCode() accepted an expired code")
+	}
+}
+
+// TestSchemaVersion verifies that an unversioned users file is loaded and
+// rewritten at the current version, and that a newer one is refused
+func TestSchemaVersion(t *testing.T) {
+	tempDir := t.TempDir()
+	usersPath := filepath.Join(tempDir, "users.json")
+
+	// A user whose name is the version key mustn't be taken for it
+	legacy := `{"alice":{"username":"alice","password_hash":"hash"},"schema_version":{"username":"schema_version","password_hash":"hash"}}`
+	if err := os.WriteFile(usersPath, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	store, err := NewStore(tempDir)
+	if err != nil {
+		t.Fatalf("NewStore() with an unversioned file error = %v", err)
+	}
+	if store.GetUser("alice") == nil || store.GetUser("schema_version") == nil {
+		t.Errorf("users after loading = %v; want alice and schema_version", store.users)
+	}
+
+	data, err := os.ReadFile(usersPath)
+	if err != nil {
+		t.Fatalf("Failed to read users file: %v", err)
+	}
+	var saved usersFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse users file: %v", err)
+	}
+	if saved.SchemaVersion != CurrentSchemaVersion || len(saved.Users) != 2 {
+		t.Errorf("rewritten users file = %s; want version %d with both users", data, CurrentSchemaVersion)
+	}
+	if _, err := NewStore(tempDir); err != nil {
+		t.Errorf("NewStore() with a versioned file error = %v", err)
+	}
+
+	// Files from the future are refused
+	future := fmt.Sprintf(`{"schema_version":%d,"users":{}}`, CurrentSchemaVersion+1)
+	if err := os.WriteFile(usersPath, []byte(future), 0600); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+	if _, err := NewStore(tempDir); err == nil || !strings.Contains(err.Error(), "schema version") {
+		t.Errorf("NewStore() with a newer file error = %v; want a schema version error", err)
+	}
+}
+
+// TestMaxRegistrations verifies that registrations give up with ErrBusy
+// while every hashing slot is taken, and go through once one frees up
+func TestMaxRegistrations(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	defer func(wait time.Duration) { registrationWait = wait }(registrationWait)
+	registrationWait = 10 * time.Millisecond
+
+	store.SetMaxRegistrations(1)
+	release, err := store.acquireHashSlot()
+	if err != nil {
+		t.Fatalf("acquireHashSlot() error = %v", err)
+	}
+	if err := store.Register(testUsername, testPassword); !errors.Is(err, ErrBusy) {
+		t.Errorf("Register() with no free slot error = %v; want ErrBusy", err)
+	}
+	if err := store.StartRegistration(testUsername, testPassword); !errors.Is(err, ErrBusy) {
+		t.Errorf("StartRegistration() with no free slot error = %v; want ErrBusy", err)
+	}
+	release()
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Errorf("Register() once a slot is free error = %v", err)
+	}
+
+	// No limit at all
+	store.SetMaxRegistrations(0)
+	store.acquireHashSlot()
+	if err := store.UpdatePassword(testUsername, testPassword+"!"); err != nil {
+		t.Errorf("UpdatePassword() without a limit error = %v", err)
+	}
+}
+
+// TestDeletionArchive verifies that Delete keeps a copy of the profile
+// when a deletion archive directory is set
+func TestDeletionArchive(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+	archiveDir := filepath.Join(tempDir, "deleted")
+	store.SetDeletionArchiveDir(archiveDir)
+
+	if err := store.Register("alice", testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := store.Delete("alice"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	copies, _ := filepath.Glob(filepath.Join(archiveDir, "alice", "profile-*.json"))
+	if len(copies) != 1 {
+		t.Fatalf("kept %d copies; want 1", len(copies))
+	}
+	data, err := os.ReadFile(copies[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	var kept User
+	if err := json.Unmarshal(data, &kept); err != nil || kept.Username != "alice" || kept.PasswordHash == "" {
+		t.Errorf("kept profile = %+v, %v; want alice with the password hash", kept, err)
+	}
+	if store.GetUser("alice") != nil {
+		t.Error("GetUser() found the deleted user")
+	}
+}
+
+// TestNewLockedStore verifies that only one locked store can use a data
+// directory at a time, and that Close lets the next one in
+func TestNewLockedStore(t *testing.T) {
+	dataDir := t.TempDir()
+
+	store, err := NewLockedStore(dataDir)
+	if err != nil {
+		t.Fatalf("NewLockedStore() error = %v", err)
+	}
+	if _, err := NewLockedStore(dataDir); err != ErrDataDirInUse {
+		t.Errorf("second NewLockedStore() error = %v; want ErrDataDirInUse", err)
+	}
+
+	// Unlocked stores, e.g. in tests, are not affected
+	if _, err := NewStore(dataDir); err != nil {
+		t.Errorf("NewStore() on a locked directory error = %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	next, err := NewLockedStore(dataDir)
+	if err != nil {
+		t.Fatalf("NewLockedStore() after Close() error = %v", err)
+	}
+	defer next.Close()
+}
+
+// TestBackup verifies that backups use the same versioned layout as the
+// users file, so restoring one keeps everything
+func TestBackup(t *testing.T) {
+	store, tempDir := setupTestStore(t)
+	defer cleanupTestStore(tempDir)
+
+	if err := store.Register(testUsername, testPassword); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	secret, err := store.EnrollTOTP(testUsername)
+	if err != nil {
+		t.Fatalf("EnrollTOTP() error = %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	if err := store.ConfirmTOTP(testUsername, totpCode(key, uint64(time.Now().Unix()/30))); err != nil {
+		t.Fatalf("ConfirmTOTP() error = %v", err)
+	}
+
+	backupDir := t.TempDir()
+	if err := store.Backup(backupDir); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(tempDir, "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to read users file: %v", err)
+	}
+	backup, err := os.ReadFile(filepath.Join(backupDir, "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	if string(backup) != string(saved) {
+		t.Errorf("backup = %s; want it laid out like the users file:\n%s", backup, saved)
+	}
+
+	restored, err := NewStore(backupDir)
+	if err != nil {
+		t.Fatalf("NewStore() from backup error = %v", err)
+	}
+	if u := restored.GetUser(testUsername); u == nil || u.TOTPSecret == "" {
+		t.Errorf("restored user = %+v; want their TOTP secret kept", u)
+	}
+}
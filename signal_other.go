@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// flushSignals ask a running server to save any unsaved todos; there is no
+// such signal on this platform
+var flushSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// flushSignals ask a running server to save any unsaved todos
+var flushSignals = []os.Signal{syscall.SIGUSR1}
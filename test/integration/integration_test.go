@@ -27,6 +27,8 @@ import (
 
 	"todoissh/pkg/todo"
 	"todoissh/pkg/user"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -55,6 +57,7 @@ func setupTestEnvironment(t *testing.T) (string, *user.Store, *todo.Store) {
 		os.RemoveAll(dataDir)
 		t.Fatalf("Failed to create user store: %v", err)
 	}
+	userStore.SetPasswordCost(bcrypt.MinCost)
 
 	// Initialize todo store
 	todoStore, err := todo.NewStore(dataDir)
@@ -173,7 +176,7 @@ func TestBasicUserAndTodoIntegration(t *testing.T) {
 	}
 
 	// Test deleting a todo
-	err = todoStore.Delete(testUsername, todo1.ID)
+	_, err = todoStore.Delete(testUsername, todo1.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete todo: %v", err)
 	}
@@ -360,7 +363,7 @@ func TestTodoOperationsEdgeCases(t *testing.T) {
 	}
 
 	// Test 7: Delete a non-existent todo ID
-	err = todoStore.Delete(username, 99999)
+	_, err = todoStore.Delete(username, 99999)
 	if err == nil {
 		t.Errorf("Deleting non-existent todo should fail")
 	}
@@ -371,16 +374,30 @@ func TestTodoOperationsEdgeCases(t *testing.T) {
 		t.Errorf("Adding todo for non-existent user should still succeed")
 	}
 
-	// Test 9: Zero ID todo (if IDs start at 1)
+	// Test 9: Zero ID todo (IDs start at 1)
 	_, err = todoStore.Get(username, 0)
-	if err == nil {
-		t.Errorf("Getting todo with ID 0 should fail if IDs start at 1")
+	if err != todo.ErrInvalidID {
+		t.Errorf("Getting todo with ID 0 error = %v; want ErrInvalidID", err)
 	}
 
 	// Test 10: Negative ID todo
 	_, err = todoStore.Get(username, -1)
-	if err == nil {
-		t.Errorf("Getting todo with negative ID should fail")
+	if err != todo.ErrInvalidID {
+		t.Errorf("Getting todo with negative ID error = %v; want ErrInvalidID", err)
+	}
+
+	// Test 11: Invalid IDs are told apart from missing todos by every operation
+	if _, err := todoStore.Update(username, 0, "Text"); err != todo.ErrInvalidID {
+		t.Errorf("Updating todo with ID 0 error = %v; want ErrInvalidID", err)
+	}
+	if _, err := todoStore.ToggleComplete(username, -1); err != todo.ErrInvalidID {
+		t.Errorf("Toggling todo with negative ID error = %v; want ErrInvalidID", err)
+	}
+	if _, err := todoStore.Delete(username, 0); err != todo.ErrInvalidID {
+		t.Errorf("Deleting todo with ID 0 error = %v; want ErrInvalidID", err)
+	}
+	if _, err := todoStore.Get(username, 99999); err == nil || err == todo.ErrInvalidID {
+		t.Errorf("Getting non-existent todo error = %v; want not found", err)
 	}
 }
 
@@ -549,7 +566,7 @@ func TestStorePersistence(t *testing.T) {
 	}
 
 	// Delete third todo
-	err = todoStore.Delete(username, todos[2].ID)
+	_, err = todoStore.Delete(username, todos[2].ID)
 	if err != nil {
 		t.Fatalf("Failed to delete third todo: %v", err)
 	}
@@ -625,10 +642,10 @@ func TestUserDataIsolation(t *testing.T) {
 		password string
 		todos    int // number of todos to create
 	}{
-		{"user1", "pass1", 3},
-		{"user2", "pass2", 5},
-		{"user3", "pass3", 0}, // user with no todos
-		{"user4", "pass4", 10},
+		{"user1", "password1", 3},
+		{"user2", "password2", 5},
+		{"user3", "password3", 0}, // user with no todos
+		{"user4", "password4", 10},
 	}
 
 	// Register users and add todos
@@ -690,7 +707,7 @@ func TestUserDataIsolation(t *testing.T) {
 		}
 
 		// Try to delete it as user2
-		err = todoStore.Delete("user2", todoID)
+		_, err = todoStore.Delete("user2", todoID)
 		if err == nil {
 			// If the operation succeeds, make sure it didn't affect user1's todo
 			_, getErr := todoStore.Get("user1", todoID)
@@ -758,28 +775,33 @@ func TestErrorRecovery(t *testing.T) {
 		t.Logf("Store returned error for corrupted data as expected: %v", err)
 	}
 
-	// Try adding a new todo (should still work)
+	// Adding a todo is refused rather than replacing the corrupted file,
+	// so whatever is left of it can still be recovered by hand
+	if _, err := todoStore2.Add(username, "Recovery Todo"); err == nil {
+		t.Errorf("Add() after corruption succeeded; want the load error")
+	}
+	data, err := os.ReadFile(corruptedDir)
+	if err != nil {
+		t.Fatalf("Failed to read corrupted file: %v", err)
+	}
+	if string(data) != "corrupted data" {
+		t.Errorf("Corrupted file was overwritten with %q", data)
+	}
+
+	// Once the file is repaired, the store works again
+	if err := os.Remove(corruptedDir); err != nil {
+		t.Fatalf("Failed to remove corrupted file: %v", err)
+	}
+	todoStore2.Invalidate(username)
 	newTodo, err := todoStore2.Add(username, "Recovery Todo")
 	if err != nil {
-		t.Errorf("Failed to add todo after corruption: %v", err)
+		t.Errorf("Failed to add todo after repair: %v", err)
 	} else {
-		t.Logf("Successfully added todo after corruption")
-
-		// Verify the new todo
 		todos, err := todoStore2.List(username)
 		if err != nil {
-			t.Logf("List still returns error after new add: %v", err)
-		} else {
-			var found bool
-			for _, todo := range todos {
-				if todo.ID == newTodo.ID && todo.Text == "Recovery Todo" {
-					found = true
-					break
-				}
-			}
-			if !found {
-				t.Errorf("New todo not found in list after corruption recovery")
-			}
+			t.Errorf("Failed to list todos after repair: %v", err)
+		} else if len(todos) != 1 || todos[0].ID != newTodo.ID || todos[0].Text != "Recovery Todo" {
+			t.Errorf("Todos after repair = %v; want just the new todo", todos)
 		}
 	}
 